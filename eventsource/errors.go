@@ -10,3 +10,56 @@ type AggregateLockError struct {
 func (err *AggregateLockError) Error() string {
 	return fmt.Sprintf("AggregateLockError: Aggregate with id %s has already processed sequence: %d", err.ID, err.Sequence)
 }
+
+// AggregateTypeError is returned when an aggregate is loaded from the events of a different type of aggregate
+type AggregateTypeError struct {
+	ID       string
+	Type     string
+	Expected string
+}
+
+func (err *AggregateTypeError) Error() string {
+	return fmt.Sprintf("AggregateTypeError: Aggregate with id %s is a %s, not a %s", err.ID, err.Type, err.Expected)
+}
+
+// EventGapError is returned by ProjectionRunner for an event which arrived before earlier events of
+// its aggregate were applied
+type EventGapError struct {
+	AggregateID string
+	Sequence    int
+	Last        int
+}
+
+func (err *EventGapError) Error() string {
+	return fmt.Sprintf("EventGapError: Aggregate with id %s received sequence %d, but has only applied through %d", err.AggregateID, err.Sequence, err.Last)
+}
+
+// CommandValidationError is returned by ProcessCommand when a command fails validation.  Err
+// holds the validator's errors, e.g. validator.ValidationErrors.
+type CommandValidationError struct {
+	Command string
+	Err     error
+}
+
+func (err *CommandValidationError) Error() string {
+	return fmt.Sprintf("CommandValidationError: %s is not valid, details: %s", err.Command, err.Err)
+}
+
+func (err *CommandValidationError) Unwrap() error {
+	return err.Err
+}
+
+// UnauthorizedError is returned by ProcessCommand when an Authorizer rejects a command
+type UnauthorizedError struct {
+	UserID  string
+	Command string
+	Err     error
+}
+
+func (err *UnauthorizedError) Error() string {
+	return fmt.Sprintf("UnauthorizedError: user %q may not process %s, details: %s", err.UserID, err.Command, err.Err)
+}
+
+func (err *UnauthorizedError) Unwrap() error {
+	return err.Err
+}
@@ -1,7 +1,9 @@
 package eventsource
 
 import (
+	"context"
 	"encoding/json"
+	"reflect"
 	"time"
 
 	"github.com/google/uuid"
@@ -12,7 +14,9 @@ type Command interface {
 	AggregateID() string
 }
 
-// Event stores the data for every event
+// Event stores the data for every event.  Position orders the event among every event in
+// the store, it's assigned once the event is added to the global stream, which may be after
+// it's saved.  Metadata records the request or event which caused it.
 type Event struct {
 	EventID           string      `json:"eventId"`
 	AggregateID       string      `json:"aggregateId"`
@@ -21,6 +25,8 @@ type Event struct {
 	EventTypeVersion  int         `json:"eventVersion"`
 	EventType         string      `json:"eventType"`
 	Timestamp         time.Time   `json:"eventTimestamp"`
+	Position          int64       `json:"position,omitempty"`
+	Metadata          Metadata    `json:"metadata"`
 	Data              interface{} `json:"eventData"`
 }
 
@@ -64,37 +70,107 @@ func (b *AggregateBase) getSequence() int {
 	return b.Sequence
 }
 
-type Projection interface {
-	HandleEvent(event Event) error
-}
-
 type EventStorer interface {
-	SaveEvent(event Event) error
-	EventsForAggregate(aggregateID string) ([]Event, error)
+	SaveEvent(ctx context.Context, event Event) error
+	// SaveEvents saves all of the events atomically, either every event is saved or none are.
+	// Saving no events does nothing.
+	SaveEvents(ctx context.Context, events []Event) error
+	EventsForAggregate(ctx context.Context, aggregateID string) ([]Event, error)
+	// EventsForAggregateAfter returns the events with a sequence greater than the one given
+	EventsForAggregateAfter(ctx context.Context, aggregateID string, sequence int) ([]Event, error)
+	// EventsForAggregateAsOf returns the events within the AsOf bounds, without reading the
+	// events past them where the store can avoid it
+	EventsForAggregateAsOf(ctx context.Context, aggregateID string, asOf AsOf) ([]Event, error)
+	// ReadAll returns up to limit events from the global stream, in Position order, starting
+	// after the position given.  Reading from position 0 starts at the first event.  Events
+	// saved but not yet added to the stream aren't returned.
+	ReadAll(ctx context.Context, fromPosition int64, limit int) ([]Event, error)
+	// ReadByType reads the global stream, returning only the events of the type given
+	ReadByType(ctx context.Context, eventType string, fromPosition int64, limit int) ([]Event, error)
+	// ReadByAggregateType reads the global stream, returning only the events of aggregates of the type given
+	ReadByAggregateType(ctx context.Context, aggregateType string, fromPosition int64, limit int) ([]Event, error)
 }
 
+// EventSourceAPI passes the context given to each method on to the stores, so a cancelled
+// request or an expiring Lambda stops waiting on them
 type EventSourceAPI interface {
-	LoadAggregate(a Aggregate) error
-	ProcessCommand(c Command, a Aggregate) error
+	LoadAggregate(ctx context.Context, a Aggregate) error
+	LoadAggregateAt(ctx context.Context, a Aggregate, asOf AsOf) error
+	// ProcessCommand records the Metadata carried by the context on the command's events
+	ProcessCommand(ctx context.Context, c Command, a Aggregate) error
 }
 
 type EventSource struct {
-	store EventStorer
+	store          EventStorer
+	retryPolicy    RetryPolicy
+	snapshots      SnapshotStore
+	snapshotPolicy SnapshotPolicy
+	middleware     []Middleware
+}
+
+func New(eventStore EventStorer, opts ...Option) *EventSource {
+	es := &EventSource{
+		store:       eventStore,
+		retryPolicy: DefaultRetryPolicy,
+	}
+	for _, opt := range opts {
+		opt(es)
+	}
+	return es
+}
+
+// LoadAggregate restores the aggregate from its latest snapshot, if any, and replays the events after it
+func (es *EventSource) LoadAggregate(ctx context.Context, a Aggregate) error {
+	sequence, err := es.restoreSnapshot(ctx, a)
+	if err != nil {
+		return err
+	}
+
+	events, err := es.store.EventsForAggregateAfter(ctx, a.AggregateID(), sequence)
+	if err != nil {
+		return err
+	}
+
+	for _, event := range events {
+		if err = a.ApplyEvent(event); err != nil {
+			return err
+		}
+		a.setSequence(event.AggregateSequence)
+	}
+
+	return nil
 }
 
-func New(eventStore EventStorer) *EventSource {
-	return &EventSource{
-		store: eventStore,
+// AsOf bounds the events LoadAggregateAt replays, zero values are ignored
+type AsOf struct {
+	// Sequence is the last AggregateSequence to apply
+	Sequence int
+	// Timestamp excludes the events recorded after it
+	Timestamp time.Time
+}
+
+// Includes reports whether the event is within the bounds.  An aggregate's timestamps increase
+// with its sequence, so once an event is excluded every later event of the aggregate is too.
+func (asOf AsOf) Includes(event Event) bool {
+	if asOf.Sequence > 0 && event.AggregateSequence > asOf.Sequence {
+		return false
 	}
+	return asOf.Timestamp.IsZero() || !event.Timestamp.After(asOf.Timestamp)
 }
 
-func (es *EventSource) LoadAggregate(a Aggregate) error {
-	events, err := es.store.EventsForAggregate(a.AggregateID())
+// LoadAggregateAt rebuilds the aggregate as it was at an earlier point.  Snapshots only hold
+// the latest state, so the events are always replayed from the start.  An AggregateTypeError is
+// returned if the events are for a different type of aggregate.
+func (es *EventSource) LoadAggregateAt(ctx context.Context, a Aggregate, asOf AsOf) error {
+	events, err := es.store.EventsForAggregateAsOf(ctx, a.AggregateID(), asOf)
 	if err != nil {
 		return err
 	}
 
 	for _, event := range events {
+		if event.AggregateType != a.Type() {
+			return &AggregateTypeError{ID: a.AggregateID(), Type: event.AggregateType, Expected: a.Type()}
+		}
 		if err = a.ApplyEvent(event); err != nil {
 			return err
 		}
@@ -104,35 +180,76 @@ func (es *EventSource) LoadAggregate(a Aggregate) error {
 	return nil
 }
 
-func (es *EventSource) ProcessCommand(c Command, a Aggregate) error {
+// ProcessCommand handles the command, retrying according to the RetryPolicy if
+// another process saved events for the aggregate first.  The events are given the Metadata
+// carried by the context, with a new CorrelationID if it has none.  Retries stop once the
+// context is done.  The command passes through the middleware first.
+func (es *EventSource) ProcessCommand(ctx context.Context, c Command, a Aggregate) error {
+	ctx = WithMetadata(ctx, metadataFor(ctx))
+	return chain(es.processWithRetries, es.middleware)(ctx, c, a)
+}
+
+func (es *EventSource) processWithRetries(ctx context.Context, c Command, a Aggregate) error {
+	metadata := MetadataFrom(ctx)
+	for attempt := 1; ; attempt++ {
+		err := es.processCommand(ctx, c, a, metadata)
+		lockErr, ok := es.retryPolicy.shouldRetry(attempt, err)
+		if !ok {
+			return err
+		}
+		if err := es.retryPolicy.wait(ctx, c, attempt, lockErr); err != nil {
+			return err
+		}
+
+		// Discard the stale state so the aggregate can be reloaded
+		resetAggregate(a)
+	}
+}
+
+func (es *EventSource) processCommand(ctx context.Context, c Command, a Aggregate, metadata Metadata) error {
 	// Restore the aggregate
 	a.Init(c.AggregateID())
 
-	if err := es.LoadAggregate(a); err != nil {
+	if err := es.LoadAggregate(ctx, a); err != nil {
 		return err
 	}
-	events, err := a.HandleCommand(c)
+	data, err := a.HandleCommand(c)
 	if err != nil {
 		return err
 	}
-	for _, event := range events {
+	if len(data) == 0 {
+		return nil
+	}
+
+	previous := a.getSequence()
+	events := make([]Event, len(data))
+	for i, d := range data {
 		a.IncrementSequence()
-		e := NewEvent(a, event)
-		err = es.store.SaveEvent(e)
-		// TODO - implement retries if we get an AggregateLockError here
-		if err != nil {
-			return err
-		}
+		events[i] = NewEvent(a, d)
+		events[i].Metadata = metadata
+	}
 
-		err := a.ApplyEvent(e)
-		if err != nil {
+	// Commit all events for the command together, so a failure can't leave it half applied
+	if err := es.store.SaveEvents(ctx, events); err != nil {
+		return err
+	}
+
+	for _, e := range events {
+		if err := a.ApplyEvent(e); err != nil {
 			return err
 		}
 	}
 
+	es.takeSnapshot(ctx, a, previous)
+
 	return nil
 }
 
+func resetAggregate(a Aggregate) {
+	v := reflect.ValueOf(a).Elem()
+	v.Set(reflect.Zero(v.Type()))
+}
+
 // NewEvent publishes the event
 func NewEvent(a Aggregate, p EventData) Event {
 	_, eventType := GetTypeName(p)
@@ -150,23 +267,19 @@ func NewEvent(a Aggregate, p EventData) Event {
 }
 
 func (e *Event) Load(data []byte) error {
-	eventType, err := GetEventOfType(e.EventType)
-	if err != nil {
-		return err
-	}
-
 	temp := &eventIntermediate{
 		Event: e,
 	}
-	if err = json.Unmarshal(data, temp); err != nil {
+	if err := json.Unmarshal(data, temp); err != nil {
 		return err
 	}
 
-	if err := eventType.Load(temp.Data, e.EventTypeVersion); err != nil {
+	eventData, err := LoadEventData(e.EventType, temp.Data, e.EventTypeVersion)
+	if err != nil {
 		return err
 	}
 
-	e.Data = eventType
+	e.Data = eventData
 
 	return nil
 }
@@ -1,8 +1,11 @@
 package eventsource
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/go-test/deep"
 )
@@ -61,10 +64,428 @@ func TestEvent_Load(t *testing.T) {
 	}
 }
 
+func TestEventSource_ProcessCommand_Retries(t *testing.T) {
+	cases := []struct {
+		Label            string
+		Policy           RetryPolicy
+		Conflicts        int
+		ExpectedAttempts int
+		ExpectedRetries  int
+		ShouldError      bool
+	}{
+		{
+			Label:            "succeeds without retrying when there is no contention",
+			Policy:           RetryPolicy{MaxAttempts: 3},
+			ExpectedAttempts: 1,
+		},
+		{
+			Label:            "reloads and retries after an AggregateLockError",
+			Policy:           RetryPolicy{MaxAttempts: 3},
+			Conflicts:        2,
+			ExpectedAttempts: 3,
+			ExpectedRetries:  2,
+		},
+		{
+			Label:            "gives up once MaxAttempts is reached",
+			Policy:           RetryPolicy{MaxAttempts: 2},
+			Conflicts:        2,
+			ExpectedAttempts: 2,
+			ExpectedRetries:  1,
+			ShouldError:      true,
+		},
+		{
+			Label:            "does not retry with the NoRetryPolicy",
+			Policy:           NoRetryPolicy,
+			Conflicts:        1,
+			ExpectedAttempts: 1,
+			ShouldError:      true,
+		},
+	}
+
+	for i, c := range cases {
+		store := &contendedStore{conflicts: c.Conflicts}
+		retries := 0
+		policy := c.Policy
+		policy.Backoff = func(int) time.Duration { return 0 }
+		policy.OnRetry = func(Command, int, *AggregateLockError) { retries++ }
+
+		es := New(store, WithRetryPolicy(policy))
+		a := &counterAggregate{}
+		err := es.ProcessCommand(context.Background(), &incrementCommand{ID: "counter"}, a)
+
+		if c.ShouldError {
+			if _, ok := err.(*AggregateLockError); !ok {
+				t.Errorf("Cases[%d] FAILED: %s.  Expected an AggregateLockError, got: %v", i, c.Label, err)
+			}
+		} else if err != nil {
+			t.Errorf("Cases[%d] FAILED: %s.  Error: %s", i, c.Label, err)
+		}
+
+		if store.attempts != c.ExpectedAttempts {
+			t.Errorf("Cases[%d] FAILED: %s.  Expected %d attempts, got %d", i, c.Label, c.ExpectedAttempts, store.attempts)
+		}
+		if retries != c.ExpectedRetries {
+			t.Errorf("Cases[%d] FAILED: %s.  Expected %d retries reported, got %d", i, c.Label, c.ExpectedRetries, retries)
+		}
+		if !c.ShouldError && (a.Count != c.Conflicts+1 || a.Sequence != c.Conflicts+1) {
+			t.Errorf("Cases[%d] FAILED: %s.  Aggregate was not reloaded correctly: %+v", i, c.Label, a)
+		}
+	}
+}
+
+func TestEventSource_ProcessCommand_Cancelled(t *testing.T) {
+	store := &contendedStore{conflicts: 2}
+	policy := RetryPolicy{MaxAttempts: 3, Backoff: func(int) time.Duration { return time.Hour }}
+	es := New(store, WithRetryPolicy(policy))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := es.ProcessCommand(ctx, &incrementCommand{ID: "counter"}, &counterAggregate{})
+	if err != context.Canceled {
+		t.Errorf("Expected the context's error, got: %v", err)
+	}
+	if store.attempts != 1 {
+		t.Errorf("Expected no retries once the context is done, got %d attempts", store.attempts)
+	}
+}
+
+func TestEventSource_ProcessCommand_SavesEventsTogether(t *testing.T) {
+	store := &contendedStore{}
+	es := New(store, WithRetryPolicy(NoRetryPolicy))
+	a := &counterAggregate{}
+	if err := es.ProcessCommand(context.Background(), &incrementCommand{ID: "counter", Times: 3}, a); err != nil {
+		t.Fatal(err)
+	}
+
+	if store.attempts != 1 {
+		t.Errorf("Expected all events to be saved in 1 call, got %d", store.attempts)
+	}
+	for i, e := range store.events {
+		if e.AggregateSequence != i+1 {
+			t.Errorf("Expected sequence %d for events[%d], got %d", i+1, i, e.AggregateSequence)
+		}
+	}
+	if a.Count != 3 || a.Sequence != 3 {
+		t.Errorf("Expected all events to be applied to the aggregate, got %+v", a)
+	}
+}
+
+func TestEventSource_ProcessCommand_Metadata(t *testing.T) {
+	cases := []struct {
+		Label    string
+		Metadata *Metadata
+	}{
+		{
+			Label:    "records the metadata from the context on every event",
+			Metadata: &Metadata{CorrelationID: "request", CausationID: "request", UserID: "user"},
+		},
+		{
+			Label: "starts a correlation for commands without metadata",
+		},
+	}
+
+	for i, c := range cases {
+		store := &contendedStore{}
+		es := New(store, WithRetryPolicy(NoRetryPolicy))
+		ctx := context.Background()
+		if c.Metadata != nil {
+			ctx = WithMetadata(ctx, *c.Metadata)
+		}
+
+		if err := es.ProcessCommand(ctx, &incrementCommand{ID: "counter", Times: 2}, &counterAggregate{}); err != nil {
+			t.Fatalf("Cases[%d] FAILED: %s.  Error: %s", i, c.Label, err)
+		}
+
+		first := store.events[0].Metadata
+		if first.CorrelationID == "" {
+			t.Errorf("Cases[%d] FAILED: %s.  Expected a CorrelationID", i, c.Label)
+		}
+		if c.Metadata != nil {
+			if diff := deep.Equal(first, *c.Metadata); diff != nil {
+				t.Errorf("Cases[%d] FAILED: %s.  Error: %s", i, c.Label, diff)
+			}
+		}
+		if diff := deep.Equal(store.events[1].Metadata, first); diff != nil {
+			t.Errorf("Cases[%d] FAILED: %s.  Expected the events to share metadata: %s", i, c.Label, diff)
+		}
+	}
+}
+
+func TestCausedBy(t *testing.T) {
+	cases := []struct {
+		Label    string
+		Event    Event
+		Expected Metadata
+	}{
+		{
+			Label:    "continues the correlation of the event",
+			Event:    Event{EventID: "event", Metadata: Metadata{CorrelationID: "request", CausationID: "request", UserID: "user"}},
+			Expected: Metadata{CorrelationID: "request", CausationID: "event", UserID: "user"},
+		},
+		{
+			Label:    "starts a correlation from an event without one",
+			Event:    Event{EventID: "event"},
+			Expected: Metadata{CorrelationID: "event", CausationID: "event"},
+		},
+	}
+
+	for i, c := range cases {
+		if diff := deep.Equal(CausedBy(c.Event), c.Expected); diff != nil {
+			t.Errorf("Cases[%d] FAILED: %s.  Error: %s", i, c.Label, diff)
+		}
+	}
+}
+
+func TestEventSource_Snapshots(t *testing.T) {
+	store := &contendedStore{}
+	snapshots := &mockSnapshotStore{}
+	es := New(store, WithRetryPolicy(NoRetryPolicy), WithSnapshots(snapshots, SnapshotEvery(3)))
+
+	for i := 0; i < 2; i++ {
+		if err := es.ProcessCommand(context.Background(), &incrementCommand{ID: "counter"}, &counterAggregate{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if snapshots.snapshot != nil {
+		t.Fatalf("Expected no snapshot before 3 events, got %+v", snapshots.snapshot)
+	}
+
+	if err := es.ProcessCommand(context.Background(), &incrementCommand{ID: "counter", Times: 2}, &counterAggregate{}); err != nil {
+		t.Fatal(err)
+	}
+	if snapshots.snapshot == nil || snapshots.snapshot.AggregateSequence != 4 {
+		t.Fatalf("Expected a snapshot at sequence 4, got %+v", snapshots.snapshot)
+	}
+
+	a := &counterAggregate{}
+	a.Init("counter")
+	if err := es.LoadAggregate(context.Background(), a); err != nil {
+		t.Fatal(err)
+	}
+	if store.after != 4 {
+		t.Errorf("Expected events to be replayed after sequence 4, got %d", store.after)
+	}
+	if a.Count != 4 || a.Sequence != 4 || !a.Restored {
+		t.Errorf("Expected the aggregate to be restored from the snapshot, got %+v", a)
+	}
+
+	// Snapshots with an outdated version are ignored
+	snapshots.snapshot.Version = 0
+	a = &counterAggregate{}
+	a.Init("counter")
+	if err := es.LoadAggregate(context.Background(), a); err != nil {
+		t.Fatal(err)
+	}
+	if store.after != 0 || a.Count != 4 || a.Restored {
+		t.Errorf("Expected the aggregate to be replayed from its events, got %+v", a)
+	}
+}
+
+func TestEventSource_LoadAggregateAt(t *testing.T) {
+	start := time.Date(2020, 4, 19, 19, 45, 0, 0, time.UTC)
+	store := &contendedStore{}
+	for i := 1; i <= 4; i++ {
+		store.events = append(store.events, Event{
+			AggregateID:       "counter",
+			AggregateType:     "CounterAggregate",
+			AggregateSequence: i,
+			Timestamp:         start.Add(time.Duration(i) * time.Minute),
+		})
+	}
+	es := New(store, WithSnapshots(&mockSnapshotStore{}, SnapshotEvery(1)))
+
+	cases := []struct {
+		Label    string
+		AsOf     AsOf
+		Expected int
+	}{
+		{Label: "applies every event without a bound", AsOf: AsOf{}, Expected: 4},
+		{Label: "stops at the sequence", AsOf: AsOf{Sequence: 2}, Expected: 2},
+		{Label: "stops at the timestamp", AsOf: AsOf{Timestamp: start.Add(3 * time.Minute)}, Expected: 3},
+		{Label: "applies the earlier of both bounds", AsOf: AsOf{Sequence: 3, Timestamp: start.Add(90 * time.Second)}, Expected: 1},
+		{Label: "applies nothing before the first event", AsOf: AsOf{Timestamp: start}, Expected: 0},
+	}
+
+	for i, c := range cases {
+		a := &counterAggregate{}
+		a.Init("counter")
+		if err := es.LoadAggregateAt(context.Background(), a, c.AsOf); err != nil {
+			t.Errorf("Cases[%d] FAILED: %s.  Error: %s", i, c.Label, err)
+			continue
+		}
+		if a.Count != c.Expected || a.Sequence != c.Expected || a.Restored {
+			t.Errorf("Cases[%d] FAILED: %s.  Expected %d events to be applied, got %+v", i, c.Label, c.Expected, a)
+		}
+	}
+
+	// Events of another type of aggregate with the same id aren't applied
+	store.events[0].AggregateType = "OtherAggregate"
+	a := &counterAggregate{}
+	a.Init("counter")
+	err := es.LoadAggregateAt(context.Background(), a, AsOf{})
+	if _, ok := err.(*AggregateTypeError); !ok || a.Count != 0 {
+		t.Errorf("Expected an AggregateTypeError without applying events, got %v and %+v", err, a)
+	}
+}
+
+func TestSnapshotEvery(t *testing.T) {
+	cases := []struct {
+		Previous int
+		Current  int
+		Expected bool
+	}{
+		{Previous: 0, Current: 1, Expected: false},
+		{Previous: 1, Current: 3, Expected: true},
+		{Previous: 3, Current: 5, Expected: false},
+		{Previous: 5, Current: 7, Expected: true},
+	}
+
+	policy := SnapshotEvery(3)
+	for i, c := range cases {
+		if got := policy(c.Previous, c.Current); got != c.Expected {
+			t.Errorf("Cases[%d] FAILED: expected %t, got %t", i, c.Expected, got)
+		}
+	}
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	backoff := ExponentialBackoff(10 * time.Millisecond)
+	expected := []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 40 * time.Millisecond}
+	for i, e := range expected {
+		if got := backoff(i + 1); got != e {
+			t.Errorf("Attempt %d: expected %s, got %s", i+1, e, got)
+		}
+	}
+}
+
 /*
  * Set up
  */
 
+type incrementCommand struct {
+	ID    string `validate:"required"`
+	Times int
+}
+
+func (c *incrementCommand) AggregateID() string {
+	return c.ID
+}
+
+type counterAggregate struct {
+	AggregateBase
+	ID       string `json:"id"`
+	Count    int    `json:"count"`
+	Restored bool   `json:"-"`
+}
+
+func (a *counterAggregate) Init(aggregateID string) {
+	a.ID = aggregateID
+}
+
+func (a *counterAggregate) AggregateID() string {
+	return a.ID
+}
+
+func (a *counterAggregate) Type() string {
+	return "CounterAggregate"
+}
+
+func (a *counterAggregate) HandleCommand(command Command) ([]EventData, error) {
+	switch c := command.(type) {
+	case *incrementCommand:
+		events := []EventData{&TestData{TestID: a.ID}}
+		for i := 1; i < c.Times; i++ {
+			events = append(events, &TestData{TestID: a.ID})
+		}
+		return events, nil
+	case counterID:
+		return []EventData{&TestData{TestID: a.ID}}, nil
+	default:
+		return nil, fmt.Errorf("No handler for command: %T", command)
+	}
+}
+
+func (a *counterAggregate) ApplyEvent(event Event) error {
+	a.Count++
+	return nil
+}
+
+func (a *counterAggregate) SnapshotVersion() int {
+	return 1
+}
+
+func (a *counterAggregate) TakeSnapshot() (json.RawMessage, error) {
+	return json.Marshal(a)
+}
+
+func (a *counterAggregate) RestoreSnapshot(data json.RawMessage, version int) error {
+	a.Restored = true
+	return json.Unmarshal(data, a)
+}
+
+type mockSnapshotStore struct {
+	snapshot *Snapshot
+}
+
+func (m *mockSnapshotStore) LatestSnapshot(_ context.Context, aggregateID string) (*Snapshot, error) {
+	return m.snapshot, nil
+}
+
+func (m *mockSnapshotStore) SaveSnapshot(_ context.Context, snapshot Snapshot) error {
+	m.snapshot = &snapshot
+	return nil
+}
+
+// contendedStore simulates another process saving an event for the
+// aggregate just before each of the first `conflicts` saves
+type contendedStore struct {
+	EventStorer
+	events    []Event
+	conflicts int
+	attempts  int
+	after     int
+}
+
+func (s *contendedStore) SaveEvent(ctx context.Context, event Event) error {
+	return s.SaveEvents(ctx, []Event{event})
+}
+
+func (s *contendedStore) SaveEvents(_ context.Context, events []Event) error {
+	s.attempts++
+	s.events = append(s.events, events...)
+	if s.conflicts > 0 {
+		s.conflicts--
+		return &AggregateLockError{ID: events[0].AggregateID, Sequence: events[0].AggregateSequence}
+	}
+	return nil
+}
+
+func (s *contendedStore) EventsForAggregate(ctx context.Context, aggregateID string) ([]Event, error) {
+	return s.EventsForAggregateAfter(ctx, aggregateID, 0)
+}
+
+func (s *contendedStore) EventsForAggregateAfter(_ context.Context, aggregateID string, sequence int) ([]Event, error) {
+	s.after = sequence
+	var events []Event
+	for _, e := range s.events {
+		if e.AggregateSequence > sequence {
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+func (s *contendedStore) EventsForAggregateAsOf(_ context.Context, aggregateID string, asOf AsOf) ([]Event, error) {
+	var events []Event
+	for _, e := range s.events {
+		if asOf.Includes(e) {
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
 type TestAggregate struct {
 	Aggregate
 	TestID string
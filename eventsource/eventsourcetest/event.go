@@ -1,8 +1,8 @@
 package eventsourcetest
 
 import (
+	"encoding/json"
 	"fmt"
-	"reflect"
 	"testing"
 
 	"github.com/go-test/deep"
@@ -21,10 +21,10 @@ type EventLoadTestCase struct {
 
 type EventLoadTestCases []*EventLoadTestCase
 
+// Test loads the event through the registry, so any registered upcasters are applied
 func (c *EventLoadTestCase) Test() error {
-	eventType, _ := eventsource.GetTypeName(c.Expected)
-	got := reflect.New(eventType).Interface().(eventsource.EventData)
-	err := got.Load([]byte(c.Event), c.Version)
+	_, eventType := eventsource.GetTypeName(c.Expected)
+	got, err := eventsource.LoadEventData(eventType, []byte(c.Event), c.Version)
 
 	if c.ShouldError {
 		if c.ExpectedError != nil && c.ExpectedError != err {
@@ -50,3 +50,56 @@ func (cases EventLoadTestCases) Test(t *testing.T) {
 		}
 	}
 }
+
+type UpcastTestCase struct {
+	Label           string
+	EventType       eventsource.EventData
+	Version         int
+	Event           string
+	Expected        string
+	ExpectedVersion int
+	ShouldError     bool
+}
+
+type UpcastTestCases []*UpcastTestCase
+
+func (c *UpcastTestCase) Test() error {
+	_, eventType := eventsource.GetTypeName(c.EventType)
+	got, version, err := eventsource.Upcast(eventType, []byte(c.Event), c.Version)
+
+	if c.ShouldError {
+		if err == nil {
+			return fmt.Errorf("FAILED: %s.  Error: Expected error, but got: %s", c.Label, got)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("FAILED: %s.  Error: %s", c.Label, err)
+	}
+
+	if version != c.ExpectedVersion {
+		return fmt.Errorf("FAILED: %s.  Error: Expected version %d, got %d", c.Label, c.ExpectedVersion, version)
+	}
+
+	// Compare decoded json, so formatting differences are ignored
+	var gotJSON, expectedJSON interface{}
+	if err := json.Unmarshal(got, &gotJSON); err != nil {
+		return fmt.Errorf("FAILED: %s.  Error: Upcast produced invalid json: %s", c.Label, err)
+	}
+	if err := json.Unmarshal([]byte(c.Expected), &expectedJSON); err != nil {
+		return fmt.Errorf("FAILED: %s.  Error: Expected is invalid json: %s", c.Label, err)
+	}
+	if diff := deep.Equal(gotJSON, expectedJSON); diff != nil {
+		return fmt.Errorf("FAILED: %s.  Error: %s", c.Label, diff)
+	}
+
+	return nil
+}
+
+func (cases UpcastTestCases) Test(t *testing.T) {
+	for i, c := range cases {
+		if err := c.Test(); err != nil {
+			t.Errorf("Case[%d] %s", i, err)
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package eventsourcetest
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"reflect"
 	"testing"
@@ -23,10 +25,11 @@ type SagaLoadTestCase struct {
 
 type SagaLoadTestCases []*SagaLoadTestCase
 
+// Test loads the saga as the SagaManager does, so any registered migrations are applied
 func (c *SagaLoadTestCase) Test() error {
 	sagaType, _ := eventsource.GetTypeName(c.Expected)
 	got := reflect.New(sagaType).Interface().(saga.SagaAPI)
-	err := got.Load([]byte(c.Saga), c.Version)
+	err := saga.LoadSaga(got, []byte(c.Saga), c.Version)
 
 	if c.ShouldError {
 		if c.ExpectedError != nil && c.ExpectedError != err {
@@ -53,6 +56,58 @@ func (cases SagaLoadTestCases) Test(t *testing.T) {
 	}
 }
 
+type SagaMigrationTestCase struct {
+	Label           string
+	Saga            saga.SagaAPI
+	Version         int
+	State           string
+	Expected        string
+	ExpectedVersion int
+	ShouldError     bool
+}
+
+type SagaMigrationTestCases []*SagaMigrationTestCase
+
+func (c *SagaMigrationTestCase) Test() error {
+	got, version, err := saga.Migrate(c.Saga.Type(), []byte(c.State), c.Version)
+
+	if c.ShouldError {
+		if err == nil {
+			return fmt.Errorf("FAILED: %s.  Error: Expected error, but got: %s", c.Label, got)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("FAILED: %s.  Error: %s", c.Label, err)
+	}
+
+	if version != c.ExpectedVersion {
+		return fmt.Errorf("FAILED: %s.  Error: Expected version %d, got %d", c.Label, c.ExpectedVersion, version)
+	}
+
+	// Compare decoded json, so formatting differences are ignored
+	var gotJSON, expectedJSON interface{}
+	if err := json.Unmarshal(got, &gotJSON); err != nil {
+		return fmt.Errorf("FAILED: %s.  Error: Migration produced invalid json: %s", c.Label, err)
+	}
+	if err := json.Unmarshal([]byte(c.Expected), &expectedJSON); err != nil {
+		return fmt.Errorf("FAILED: %s.  Error: Expected is invalid json: %s", c.Label, err)
+	}
+	if diff := deep.Equal(gotJSON, expectedJSON); diff != nil {
+		return fmt.Errorf("FAILED: %s.  Error: %s", c.Label, diff)
+	}
+
+	return nil
+}
+
+func (cases SagaMigrationTestCases) Test(t *testing.T) {
+	for i, c := range cases {
+		if err := c.Test(); err != nil {
+			t.Errorf("Case[%d] %s", i, err)
+		}
+	}
+}
+
 type SagaAssociationIDTestCase struct {
 	Label         string
 	Saga          saga.SagaAPI
@@ -115,9 +170,9 @@ func (c *SagaHandleEventTestCase) Test() error {
 	}
 
 	for _, e := range c.Given {
-		got.HandleEvent(e)
+		got.HandleEvent(handleEventContext(e), e)
 	}
-	result, err := got.HandleEvent(c.Event)
+	result, err := got.HandleEvent(handleEventContext(c.Event), c.Event)
 
 	if c.ShouldError {
 		if diff := deep.Equal(c.ExpectedError, err); c.ExpectedError != nil && diff != nil {
@@ -139,6 +194,11 @@ func (c *SagaHandleEventTestCase) Test() error {
 	return nil
 }
 
+// handleEventContext carries the metadata the SagaManager gives sagas handling the event
+func handleEventContext(event eventsource.Event) context.Context {
+	return eventsource.WithMetadata(context.Background(), eventsource.CausedBy(event))
+}
+
 func (cases SagaHandleEventTestCases) Test(t *testing.T) {
 	for i, c := range cases {
 		if err := c.Test(); err != nil {
@@ -0,0 +1,61 @@
+package idempotency
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// Record is the response to a command sent with an idempotency key, replayed when the command is
+// sent again with the same key until the record expires.  Keys are scoped to the command type,
+// so clients may reuse a key for different commands.
+type Record struct {
+	CommandType string
+	Key         string
+	// Fingerprint identifies the request which claimed the key, so the key can't be reused for a
+	// different request
+	Fingerprint string
+	// Completed is set once the response is recorded, until then the command is in progress
+	Completed   bool
+	StatusCode  int
+	ContentType string
+	Body        []byte
+	ExpiresAt   time.Time
+}
+
+// Store persists the records for idempotency keys
+type Store interface {
+	// Claim saves the record if its key is unused or the record using it has expired.  Otherwise
+	// the record already using the key is returned, with claimed false.
+	Claim(ctx context.Context, record *Record, now time.Time) (existing *Record, claimed bool, err error)
+	// Complete saves the response for a key the record still holds in progress.  A ClaimLostError
+	// is returned when the key has been claimed again, e.g. once the claim expired.
+	Complete(ctx context.Context, record *Record) error
+	// Release frees a key the record still holds in progress, without a response, so the command
+	// can be sent again.  A key claimed again is left alone.
+	Release(ctx context.Context, record *Record) error
+}
+
+// ClaimLostError is returned when completing a key which is no longer held by the request which
+// claimed it
+type ClaimLostError struct {
+	CommandType string
+	Key         string
+}
+
+func (err *ClaimLostError) Error() string {
+	return fmt.Sprintf("ClaimLostError: Idempotency key %s for %s is no longer held by this request", err.Key, err.CommandType)
+}
+
+// Fingerprint hashes the parts of a request which must match for it to be a repeat
+func Fingerprint(parts ...[]byte) string {
+	h := sha256.New()
+	for _, p := range parts {
+		// Length prefix each part, so moving bytes between parts changes the fingerprint
+		fmt.Fprintf(h, "%d:", len(p))
+		h.Write(p)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,187 @@
+package dynamodb
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"forge.lmig.com/n1505471/pizza-shop/eventsource/idempotency"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// Store keeps idempotency records keyed by commandType#key.  The table's time to live should be
+// set on expiresAt, so expired records are removed, though DynamoDB may take a while to remove them.
+type Store struct {
+	svc       *dynamodb.DynamoDB
+	tableName *string
+}
+
+func New(svc *dynamodb.DynamoDB, t string) *Store {
+	return &Store{
+		svc:       svc,
+		tableName: aws.String(t),
+	}
+}
+
+// recordDto is the DynamoDB representation of a record.  ExpiresAt is stored in Unix seconds, as
+// DynamoDB's time to live requires.
+type recordDto struct {
+	CompositeKey string `dynamodbav:"compositeKey"`
+	CommandType  string `dynamodbav:"commandType"`
+	Key          string `dynamodbav:"idempotencyKey"`
+	Fingerprint  string `dynamodbav:"fingerprint"`
+	Completed    bool   `dynamodbav:"completed"`
+	StatusCode   int    `dynamodbav:"statusCode"`
+	ContentType  string `dynamodbav:"contentType"`
+	Body         []byte `dynamodbav:"body"`
+	ExpiresAt    int64  `dynamodbav:"expiresAt"`
+}
+
+// Claim puts the record on the condition no record holds the key, or the record holding it has
+// expired but not yet been removed
+func (s *Store) Claim(ctx context.Context, record *idempotency.Record, now time.Time) (*idempotency.Record, bool, error) {
+	av, err := toItem(record)
+	if err != nil {
+		return nil, false, err
+	}
+	unixNow, err := dynamodbattribute.Marshal(now.Unix())
+	if err != nil {
+		return nil, false, err
+	}
+
+	_, err = s.svc.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName:           s.tableName,
+		Item:                av,
+		ConditionExpression: aws.String("attribute_not_exists(compositeKey) OR expiresAt <= :now"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":now": unixNow,
+		},
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case dynamodb.ErrCodeConditionalCheckFailedException:
+				existing, err := s.load(ctx, record)
+				return existing, false, err
+			}
+		}
+		return nil, false, err
+	}
+
+	return nil, true, nil
+}
+
+// Complete puts the record on the condition the key is still in progress for the same request,
+// so a key claimed again after this claim expired isn't overwritten
+func (s *Store) Complete(ctx context.Context, record *idempotency.Record) error {
+	av, err := toItem(record)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.svc.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName:                 s.tableName,
+		Item:                      av,
+		ConditionExpression:       aws.String(heldCondition),
+		ExpressionAttributeValues: heldValues(record),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case dynamodb.ErrCodeConditionalCheckFailedException:
+				return &idempotency.ClaimLostError{CommandType: record.CommandType, Key: record.Key}
+			}
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Release deletes the record on the same condition as Complete, a key claimed again is left alone
+func (s *Store) Release(ctx context.Context, record *idempotency.Record) error {
+	_, err := s.svc.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		TableName: s.tableName,
+		Key: map[string]*dynamodb.AttributeValue{
+			"compositeKey": {S: aws.String(compositeKey(record))},
+		},
+		ConditionExpression:       aws.String(heldCondition),
+		ExpressionAttributeValues: heldValues(record),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case dynamodb.ErrCodeConditionalCheckFailedException:
+				return nil
+			}
+		}
+		return err
+	}
+
+	return nil
+}
+
+// heldCondition holds when the key is in progress for the request with the record's fingerprint
+const heldCondition = "fingerprint = :fingerprint AND completed = :false"
+
+func heldValues(record *idempotency.Record) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		":fingerprint": {S: aws.String(record.Fingerprint)},
+		":false":       {BOOL: aws.Bool(false)},
+	}
+}
+
+// load reads the record holding the key, consistently so a claim made just before is seen
+func (s *Store) load(ctx context.Context, record *idempotency.Record) (*idempotency.Record, error) {
+	result, err := s.svc.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: s.tableName,
+		Key: map[string]*dynamodb.AttributeValue{
+			"compositeKey": {S: aws.String(compositeKey(record))},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.Item == nil {
+		return nil, fmt.Errorf("Idempotency key %s for %s was removed while it was being claimed", record.Key, record.CommandType)
+	}
+
+	dto := &recordDto{}
+	if err := dynamodbattribute.UnmarshalMap(result.Item, dto); err != nil {
+		return nil, err
+	}
+	return &idempotency.Record{
+		CommandType: dto.CommandType,
+		Key:         dto.Key,
+		Fingerprint: dto.Fingerprint,
+		Completed:   dto.Completed,
+		StatusCode:  dto.StatusCode,
+		ContentType: dto.ContentType,
+		Body:        dto.Body,
+		ExpiresAt:   time.Unix(dto.ExpiresAt, 0),
+	}, nil
+}
+
+func toItem(record *idempotency.Record) (map[string]*dynamodb.AttributeValue, error) {
+	return dynamodbattribute.MarshalMap(&recordDto{
+		CompositeKey: compositeKey(record),
+		CommandType:  record.CommandType,
+		Key:          record.Key,
+		Fingerprint:  record.Fingerprint,
+		Completed:    record.Completed,
+		StatusCode:   record.StatusCode,
+		ContentType:  record.ContentType,
+		Body:         record.Body,
+		ExpiresAt:    record.ExpiresAt.Unix(),
+	})
+}
+
+func compositeKey(record *idempotency.Record) string {
+	return fmt.Sprintf("%s#%s", record.CommandType, record.Key)
+}
+
+var _ idempotency.Store = (*Store)(nil)
@@ -0,0 +1,77 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"forge.lmig.com/n1505471/pizza-shop/eventsource/idempotency"
+)
+
+// Store keeps idempotency records in memory, for tests and running the write API locally.
+// Records are keyed by commandType#key, as in the DynamoDB Store.
+type Store struct {
+	mu      sync.Mutex
+	records map[string]idempotency.Record
+}
+
+func New() *Store {
+	return &Store{
+		records: make(map[string]idempotency.Record),
+	}
+}
+
+func (s *Store) Claim(_ context.Context, record *idempotency.Record, now time.Time) (*idempotency.Record, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := key(record)
+	if existing, ok := s.records[k]; ok && existing.ExpiresAt.After(now) {
+		return copyRecord(existing), false, nil
+	}
+
+	s.records[k] = *copyRecord(*record)
+	return nil, true, nil
+}
+
+func (s *Store) Complete(_ context.Context, record *idempotency.Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := key(record)
+	if !s.heldBy(k, record) {
+		return &idempotency.ClaimLostError{CommandType: record.CommandType, Key: record.Key}
+	}
+	s.records[k] = *copyRecord(*record)
+	return nil
+}
+
+func (s *Store) Release(_ context.Context, record *idempotency.Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := key(record)
+	if s.heldBy(k, record) {
+		delete(s.records, k)
+	}
+	return nil
+}
+
+// heldBy reports whether the key is still in progress for the record's request, as the
+// DynamoDB Store's conditions check
+func (s *Store) heldBy(k string, record *idempotency.Record) bool {
+	existing, ok := s.records[k]
+	return ok && existing.Fingerprint == record.Fingerprint && !existing.Completed
+}
+
+// copyRecord copies the body too, so callers can't change the stored response
+func copyRecord(r idempotency.Record) *idempotency.Record {
+	r.Body = append([]byte(nil), r.Body...)
+	return &r
+}
+
+func key(record *idempotency.Record) string {
+	return record.CommandType + "#" + record.Key
+}
+
+var _ idempotency.Store = (*Store)(nil)
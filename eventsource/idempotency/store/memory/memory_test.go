@@ -0,0 +1,133 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+
+	"forge.lmig.com/n1505471/pizza-shop/eventsource/idempotency"
+)
+
+func TestStore_Claim(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	store := New()
+
+	first := &idempotency.Record{CommandType: "StartOrderCommand", Key: "key", Fingerprint: "first", ExpiresAt: now.Add(time.Hour)}
+	if _, claimed, err := store.Claim(ctx, first, now); err != nil || !claimed {
+		t.Fatalf("Expected to claim an unused key, got claimed: %t, error: %v", claimed, err)
+	}
+
+	cases := []struct {
+		Label           string
+		Record          *idempotency.Record
+		Now             time.Time
+		ExpectedClaimed bool
+		Expected        *idempotency.Record
+	}{
+		{
+			Label:    "returns the record already using the key",
+			Record:   &idempotency.Record{CommandType: "StartOrderCommand", Key: "key", Fingerprint: "second", ExpiresAt: now.Add(time.Hour)},
+			Now:      now,
+			Expected: first,
+		},
+		{
+			Label:           "scopes keys to the command type",
+			Record:          &idempotency.Record{CommandType: "SubmitOrderCommand", Key: "key", ExpiresAt: now.Add(time.Hour)},
+			Now:             now,
+			ExpectedClaimed: true,
+		},
+		{
+			Label:           "claims keys whose record has expired",
+			Record:          &idempotency.Record{CommandType: "StartOrderCommand", Key: "key", Fingerprint: "third", ExpiresAt: now.Add(3 * time.Hour)},
+			Now:             now.Add(2 * time.Hour),
+			ExpectedClaimed: true,
+		},
+	}
+
+	for i, c := range cases {
+		existing, claimed, err := store.Claim(ctx, c.Record, c.Now)
+		if err != nil {
+			t.Errorf("Cases[%d] FAILED: %s.  Error: %s", i, c.Label, err)
+			continue
+		}
+		if claimed != c.ExpectedClaimed {
+			t.Errorf("Cases[%d] FAILED: %s.  Expected claimed to be %t", i, c.Label, c.ExpectedClaimed)
+		}
+		if c.Expected != nil {
+			if diff := deep.Equal(existing, c.Expected); diff != nil {
+				t.Errorf("Cases[%d] FAILED: %s.  Error: %s", i, c.Label, diff)
+			}
+		}
+	}
+}
+
+func TestStore_CompleteAndRelease(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	store := New()
+
+	record := &idempotency.Record{CommandType: "StartOrderCommand", Key: "key", Fingerprint: "first", ExpiresAt: now.Add(time.Minute)}
+	if _, claimed, _ := store.Claim(ctx, record, now); !claimed {
+		t.Fatal("Expected to claim an unused key")
+	}
+	if err := store.Release(ctx, record); err != nil {
+		t.Fatal(err)
+	}
+	if _, claimed, _ := store.Claim(ctx, record, now); !claimed {
+		t.Errorf("Expected a released key to be claimed again")
+	}
+
+	completed := *record
+	completed.Completed = true
+	completed.StatusCode = 200
+	completed.Body = []byte(`{"ok":true}`)
+	completed.ExpiresAt = now.Add(time.Hour)
+	if err := store.Complete(ctx, &completed); err != nil {
+		t.Fatal(err)
+	}
+	existing, _, _ := store.Claim(ctx, &idempotency.Record{CommandType: "StartOrderCommand", Key: "key"}, now)
+	if diff := deep.Equal(existing, &completed); diff != nil {
+		t.Errorf("Expected the completed response to be returned: %s", diff)
+	}
+
+	// A completed key is kept until it expires
+	if err := store.Release(ctx, record); err != nil {
+		t.Fatal(err)
+	}
+	if _, claimed, _ := store.Claim(ctx, record, now); claimed {
+		t.Errorf("Expected a completed key not to be released")
+	}
+}
+
+func TestStore_Complete_ClaimLost(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	store := New()
+
+	// The first claim lapses while its request is still running, and a retry claims the key
+	first := &idempotency.Record{CommandType: "StartOrderCommand", Key: "key", Fingerprint: "first", ExpiresAt: now.Add(time.Minute)}
+	store.Claim(ctx, first, now)
+	second := &idempotency.Record{CommandType: "StartOrderCommand", Key: "key", Fingerprint: "second", ExpiresAt: now.Add(3 * time.Minute)}
+	if _, claimed, _ := store.Claim(ctx, second, now.Add(2*time.Minute)); !claimed {
+		t.Fatal("Expected to claim a lapsed key")
+	}
+
+	completed := *first
+	completed.Completed = true
+	var lost *idempotency.ClaimLostError
+	if err := store.Complete(ctx, &completed); !errors.As(err, &lost) {
+		t.Errorf("Expected a ClaimLostError completing a key claimed again, got %v", err)
+	}
+	if err := store.Release(ctx, first); err != nil {
+		t.Fatal(err)
+	}
+
+	existing, _, _ := store.Claim(ctx, first, now.Add(2*time.Minute))
+	if diff := deep.Equal(existing, second); diff != nil {
+		t.Errorf("Expected the second claim to be kept: %s", diff)
+	}
+}
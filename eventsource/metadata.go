@@ -0,0 +1,61 @@
+package eventsource
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+// Metadata records where an event came from
+type Metadata struct {
+	// CorrelationID is shared by every event resulting from the same request, including the
+	// events of the commands issued by sagas in response
+	CorrelationID string `json:"correlationId,omitempty"`
+	// CausationID is the ID of the request or event which caused the event
+	CausationID string `json:"causationId,omitempty"`
+	// UserID is the user who made the request
+	UserID string `json:"userId,omitempty"`
+	// Values holds any other metadata about the event
+	Values map[string]string `json:"values,omitempty"`
+}
+
+type metadataKey struct{}
+
+// WithMetadata returns a copy of the context carrying the metadata, which ProcessCommand
+// records on the events of the command
+func WithMetadata(ctx context.Context, m Metadata) context.Context {
+	return context.WithValue(ctx, metadataKey{}, m)
+}
+
+// MetadataFrom returns the metadata carried by the context, if any
+func MetadataFrom(ctx context.Context) Metadata {
+	if ctx == nil {
+		return Metadata{}
+	}
+	m, _ := ctx.Value(metadataKey{}).(Metadata)
+	return m
+}
+
+// CausedBy returns the metadata for messages sent in response to the event.  They keep its
+// correlation and user, with the event as their cause.
+func CausedBy(event Event) Metadata {
+	correlationID := event.Metadata.CorrelationID
+	if correlationID == "" {
+		correlationID = event.EventID
+	}
+	return Metadata{
+		CorrelationID: correlationID,
+		CausationID:   event.EventID,
+		UserID:        event.Metadata.UserID,
+	}
+}
+
+// metadataFor returns the metadata for the events of a command, starting a new correlation
+// when the command isn't part of one
+func metadataFor(ctx context.Context) Metadata {
+	m := MetadataFrom(ctx)
+	if m.CorrelationID == "" {
+		m.CorrelationID = uuid.New().String()
+	}
+	return m
+}
@@ -0,0 +1,108 @@
+package eventsource
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"reflect"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// CommandHandler processes a command against the aggregate, as ProcessCommand does
+type CommandHandler func(ctx context.Context, c Command, a Aggregate) error
+
+// Middleware wraps the processing of every command, e.g. to validate, authorize, log or time it.
+// It runs once per command, around every retry, with the Metadata for the command's events in
+// the context.
+type Middleware func(next CommandHandler) CommandHandler
+
+// WithMiddleware adds middleware around ProcessCommand.  The first middleware given runs first,
+// so it sees the command before, and the result after, the middleware following it.
+func WithMiddleware(middleware ...Middleware) Option {
+	return func(es *EventSource) {
+		es.middleware = append(es.middleware, middleware...)
+	}
+}
+
+// chain wraps the handler in the middleware, the first middleware outermost
+func chain(handler CommandHandler, middleware []Middleware) CommandHandler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	return handler
+}
+
+// ValidateCommands rejects commands which fail the validate struct tags on their fields, e.g.
+// `validate:"required"`, with a CommandValidationError.  Commands which aren't structs are
+// passed through.
+func ValidateCommands(validate *validator.Validate) Middleware {
+	return func(next CommandHandler) CommandHandler {
+		return func(ctx context.Context, c Command, a Aggregate) error {
+			if reflect.Indirect(reflect.ValueOf(c)).Kind() == reflect.Struct {
+				if err := validate.Struct(c); err != nil {
+					return &CommandValidationError{
+						Command: fmt.Sprintf("%T", c),
+						Err:     err,
+					}
+				}
+			}
+			return next(ctx, c, a)
+		}
+	}
+}
+
+// Authorizer decides whether the command may be processed, e.g. for the UserID in the Metadata
+// carried by the context, returning an error to reject it
+type Authorizer func(ctx context.Context, c Command) error
+
+// Authorize rejects the commands the authorizer returns an error for, with an UnauthorizedError
+func Authorize(authorizer Authorizer) Middleware {
+	return func(next CommandHandler) CommandHandler {
+		return func(ctx context.Context, c Command, a Aggregate) error {
+			if err := authorizer(ctx, c); err != nil {
+				return &UnauthorizedError{
+					UserID:  MetadataFrom(ctx).UserID,
+					Command: fmt.Sprintf("%T", c),
+					Err:     err,
+				}
+			}
+			return next(ctx, c, a)
+		}
+	}
+}
+
+// LogCommands logs each command and any failure with its correlation and cause, so the logs
+// of a request can be traced through the commands it leads to
+func LogCommands() Middleware {
+	return func(next CommandHandler) CommandHandler {
+		return func(ctx context.Context, c Command, a Aggregate) error {
+			m := MetadataFrom(ctx)
+			log.Printf("Processing %T for aggregate %s, correlation: %s, cause: %s", c, c.AggregateID(), m.CorrelationID, m.CausationID)
+			err := next(ctx, c, a)
+			if err != nil {
+				log.Printf("Error processing %T for aggregate %s, correlation: %s, details: %s", c, c.AggregateID(), m.CorrelationID, err)
+			}
+			return err
+		}
+	}
+}
+
+// TimeCommands reports how long each command took to process, including retries, to observe,
+// e.g. to record a metric.  The durations are logged when observe is nil.
+func TimeCommands(observe func(c Command, d time.Duration, err error)) Middleware {
+	if observe == nil {
+		observe = func(c Command, d time.Duration, err error) {
+			log.Printf("Processed %T for aggregate %s in %s", c, c.AggregateID(), d)
+		}
+	}
+	return func(next CommandHandler) CommandHandler {
+		return func(ctx context.Context, c Command, a Aggregate) error {
+			start := time.Now()
+			err := next(ctx, c, a)
+			observe(c, time.Since(start), err)
+			return err
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package eventsource
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/go-test/deep"
+)
+
+func TestEventSource_ProcessCommand_Middleware(t *testing.T) {
+	var calls []string
+	trace := func(name string) Middleware {
+		return func(next CommandHandler) CommandHandler {
+			return func(ctx context.Context, c Command, a Aggregate) error {
+				if MetadataFrom(ctx).CorrelationID == "" {
+					t.Errorf("Expected %s to be given the command's metadata", name)
+				}
+				calls = append(calls, name+" before")
+				err := next(ctx, c, a)
+				calls = append(calls, name+" after")
+				return err
+			}
+		}
+	}
+
+	store := &contendedStore{conflicts: 1}
+	policy := RetryPolicy{MaxAttempts: 2}
+	es := New(store, WithRetryPolicy(policy), WithMiddleware(trace("first")), WithMiddleware(trace("second")))
+	if err := es.ProcessCommand(context.Background(), &incrementCommand{ID: "counter"}, &counterAggregate{}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"first before", "second before", "second after", "first after"}
+	if diff := deep.Equal(calls, expected); diff != nil {
+		t.Errorf("Expected the middleware to run once, in order, around the retries: %s", diff)
+	}
+}
+
+func TestValidateCommands(t *testing.T) {
+	cases := []struct {
+		Label       string
+		Command     Command
+		ShouldError bool
+	}{
+		{
+			Label:   "processes valid commands",
+			Command: &incrementCommand{ID: "counter"},
+		},
+		{
+			Label:       "rejects commands failing their struct tags",
+			Command:     &incrementCommand{},
+			ShouldError: true,
+		},
+		{
+			Label:   "passes through commands which aren't structs",
+			Command: counterID("counter"),
+		},
+	}
+
+	for i, c := range cases {
+		store := &contendedStore{}
+		es := New(store, WithMiddleware(ValidateCommands(validator.New())))
+		err := es.ProcessCommand(context.Background(), c.Command, &counterAggregate{})
+		if c.ShouldError {
+			var validationErr *CommandValidationError
+			var fieldErrs validator.ValidationErrors
+			if !errors.As(err, &validationErr) || !errors.As(err, &fieldErrs) {
+				t.Errorf("Cases[%d] FAILED: %s.  Expected a CommandValidationError, got: %v", i, c.Label, err)
+			}
+			if len(store.events) != 0 {
+				t.Errorf("Cases[%d] FAILED: %s.  Expected no events to be saved, got %d", i, c.Label, len(store.events))
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Cases[%d] FAILED: %s.  Error: %s", i, c.Label, err)
+		}
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	onlyAdmin := func(ctx context.Context, c Command) error {
+		if MetadataFrom(ctx).UserID != "admin" {
+			return fmt.Errorf("only admin may increment the counter")
+		}
+		return nil
+	}
+
+	cases := []struct {
+		Label       string
+		UserID      string
+		ShouldError bool
+	}{
+		{
+			Label:  "processes commands the authorizer allows",
+			UserID: "admin",
+		},
+		{
+			Label:       "rejects commands the authorizer denies",
+			UserID:      "guest",
+			ShouldError: true,
+		},
+	}
+
+	for i, c := range cases {
+		store := &contendedStore{}
+		es := New(store, WithMiddleware(Authorize(onlyAdmin)))
+		ctx := WithMetadata(context.Background(), Metadata{UserID: c.UserID})
+		err := es.ProcessCommand(ctx, &incrementCommand{ID: "counter"}, &counterAggregate{})
+		if c.ShouldError {
+			unauthorized, ok := err.(*UnauthorizedError)
+			if !ok || unauthorized.UserID != c.UserID {
+				t.Errorf("Cases[%d] FAILED: %s.  Expected an UnauthorizedError for %s, got: %v", i, c.Label, c.UserID, err)
+			}
+			if len(store.events) != 0 {
+				t.Errorf("Cases[%d] FAILED: %s.  Expected no events to be saved, got %d", i, c.Label, len(store.events))
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Cases[%d] FAILED: %s.  Error: %s", i, c.Label, err)
+		}
+	}
+}
+
+func TestTimeCommands(t *testing.T) {
+	var observed []error
+	observe := func(c Command, d time.Duration, err error) {
+		if d < 0 {
+			t.Errorf("Expected a duration for %T, got %s", c, d)
+		}
+		observed = append(observed, err)
+	}
+
+	es := New(&contendedStore{}, WithMiddleware(TimeCommands(observe)))
+	if err := es.ProcessCommand(context.Background(), &incrementCommand{ID: "counter"}, &counterAggregate{}); err != nil {
+		t.Fatal(err)
+	}
+	failed := es.ProcessCommand(context.Background(), &incrementCommand{ID: "counter"}, &brokenAggregate{})
+
+	if diff := deep.Equal(observed, []error{nil, failed}); diff != nil {
+		t.Errorf("Expected every command and its result to be observed: %s", diff)
+	}
+}
+
+// counterID is a command which isn't a struct
+type counterID string
+
+func (c counterID) AggregateID() string {
+	return string(c)
+}
+
+// brokenAggregate fails every command
+type brokenAggregate struct {
+	counterAggregate
+}
+
+func (a *brokenAggregate) HandleCommand(command Command) ([]EventData, error) {
+	return nil, fmt.Errorf("No handler for command: %T", command)
+}
@@ -0,0 +1,55 @@
+package eventsource
+
+import (
+	"context"
+	"log"
+)
+
+type Projection interface {
+	HandleEvent(ctx context.Context, event Event) error
+}
+
+// CheckpointStore persists the last AggregateSequence a projection applied for each aggregate
+type CheckpointStore interface {
+	// LastSequence returns 0 when no events have been applied for the aggregate
+	LastSequence(ctx context.Context, aggregateID string) (int, error)
+	SaveSequence(ctx context.Context, aggregateID string, sequence int) error
+}
+
+// ProjectionRunner wraps a Projection so each aggregate's events are applied once and in order.
+// Events already applied are skipped, and events arriving before earlier events of their aggregate
+// are rejected with an EventGapError, so they can be redelivered once the earlier events are applied.
+type ProjectionRunner struct {
+	projection  Projection
+	checkpoints CheckpointStore
+}
+
+func NewProjectionRunner(projection Projection, checkpoints CheckpointStore) *ProjectionRunner {
+	return &ProjectionRunner{
+		projection:  projection,
+		checkpoints: checkpoints,
+	}
+}
+
+func (r *ProjectionRunner) HandleEvent(ctx context.Context, event Event) error {
+	last, err := r.checkpoints.LastSequence(ctx, event.AggregateID)
+	if err != nil {
+		return err
+	}
+
+	if event.AggregateSequence <= last {
+		log.Printf("Skipping %s for aggregate %s, sequence %d has already been applied through %d", event.EventType, event.AggregateID, event.AggregateSequence, last)
+		return nil
+	}
+	if event.AggregateSequence > last+1 {
+		return &EventGapError{AggregateID: event.AggregateID, Sequence: event.AggregateSequence, Last: last}
+	}
+
+	if err := r.projection.HandleEvent(ctx, event); err != nil {
+		return err
+	}
+
+	return r.checkpoints.SaveSequence(ctx, event.AggregateID, event.AggregateSequence)
+}
+
+var _ Projection = (*ProjectionRunner)(nil)
@@ -0,0 +1,146 @@
+package eventsource
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/go-test/deep"
+)
+
+func TestProjectionRunner_HandleEvent(t *testing.T) {
+	cases := []struct {
+		Label       string
+		Events      []Event
+		Expected    []int
+		ShouldError bool
+	}{
+		{
+			Label: "applies events in order",
+			Events: []Event{
+				{AggregateID: "a", AggregateSequence: 1},
+				{AggregateID: "a", AggregateSequence: 2},
+				{AggregateID: "b", AggregateSequence: 1},
+			},
+			Expected: []int{1, 2, 1},
+		},
+		{
+			Label: "skips redelivered events",
+			Events: []Event{
+				{AggregateID: "a", AggregateSequence: 1},
+				{AggregateID: "a", AggregateSequence: 1},
+			},
+			Expected: []int{1},
+		},
+		{
+			Label: "skips events older than the last one applied",
+			Events: []Event{
+				{AggregateID: "a", AggregateSequence: 1},
+				{AggregateID: "a", AggregateSequence: 2},
+				{AggregateID: "a", AggregateSequence: 1},
+			},
+			Expected: []int{1, 2},
+		},
+		{
+			Label: "rejects events arriving before earlier events of the aggregate",
+			Events: []Event{
+				{AggregateID: "a", AggregateSequence: 1},
+				{AggregateID: "a", AggregateSequence: 3},
+			},
+			Expected:    []int{1},
+			ShouldError: true,
+		},
+		{
+			Label: "does not checkpoint events the projection failed on",
+			Events: []Event{
+				{AggregateID: "fail", AggregateSequence: 1},
+			},
+			ShouldError: true,
+		},
+	}
+
+	for i, c := range cases {
+		projection := &recordingProjection{}
+		checkpoints := &mapCheckpointStore{sequences: map[string]int{}}
+		runner := NewProjectionRunner(projection, checkpoints)
+
+		var err error
+		for _, e := range c.Events {
+			if err = runner.HandleEvent(context.Background(), e); err != nil {
+				break
+			}
+		}
+
+		if c.ShouldError {
+			if err == nil {
+				t.Errorf("Cases[%d] FAILED: %s.  Expected an error.", i, c.Label)
+			}
+			if checkpoints.sequences["fail"] != 0 {
+				t.Errorf("Cases[%d] FAILED: %s.  Expected no checkpoint, got %d", i, c.Label, checkpoints.sequences["fail"])
+			}
+			if diff := deep.Equal(projection.sequences, c.Expected); diff != nil {
+				t.Errorf("Cases[%d] FAILED: %s.  Error: %s", i, c.Label, diff)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Cases[%d] FAILED: %s.  Error: %s", i, c.Label, err)
+		}
+
+		if diff := deep.Equal(projection.sequences, c.Expected); diff != nil {
+			t.Errorf("Cases[%d] FAILED: %s.  Error: %s", i, c.Label, diff)
+		}
+	}
+}
+
+func TestProjectionRunner_HandleEvent_Redelivered(t *testing.T) {
+	projection := &recordingProjection{}
+	runner := NewProjectionRunner(projection, &mapCheckpointStore{sequences: map[string]int{}})
+	ctx := context.Background()
+
+	if err := runner.HandleEvent(ctx, Event{AggregateID: "a", AggregateSequence: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 3 arrives before 2, so it's rejected and applied when it's redelivered
+	err := runner.HandleEvent(ctx, Event{AggregateID: "a", AggregateSequence: 3})
+	var gap *EventGapError
+	if !errors.As(err, &gap) || gap.Sequence != 3 || gap.Last != 1 {
+		t.Fatalf("Expected an EventGapError for sequence 3, got: %v", err)
+	}
+	for _, sequence := range []int{2, 3} {
+		if err := runner.HandleEvent(ctx, Event{AggregateID: "a", AggregateSequence: sequence}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if diff := deep.Equal(projection.sequences, []int{1, 2, 3}); diff != nil {
+		t.Error(diff)
+	}
+}
+
+type recordingProjection struct {
+	sequences []int
+}
+
+func (p *recordingProjection) HandleEvent(_ context.Context, event Event) error {
+	if event.AggregateID == "fail" {
+		return fmt.Errorf("i am error.")
+	}
+	p.sequences = append(p.sequences, event.AggregateSequence)
+	return nil
+}
+
+type mapCheckpointStore struct {
+	sequences map[string]int
+}
+
+func (m *mapCheckpointStore) LastSequence(_ context.Context, aggregateID string) (int, error) {
+	return m.sequences[aggregateID], nil
+}
+
+func (m *mapCheckpointStore) SaveSequence(_ context.Context, aggregateID string, sequence int) error {
+	m.sequences[aggregateID] = sequence
+	return nil
+}
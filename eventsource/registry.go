@@ -1,6 +1,7 @@
 package eventsource
 
 import (
+	"encoding/json"
 	"fmt"
 	"reflect"
 	"strings"
@@ -8,6 +9,12 @@ import (
 
 var registry = make(map[string]reflect.Type)
 
+// Upcaster transforms the raw json of an event from one version of its schema to the next
+type Upcaster func(data json.RawMessage) (json.RawMessage, error)
+
+// upcasters holds the registered upcasters per event type, keyed by the version they upgrade from
+var upcasters = make(map[string]map[int]Upcaster)
+
 func RegisterEventType(source EventData) {
 	rawType, name := GetTypeName(source)
 	registry[name] = rawType
@@ -23,6 +30,52 @@ func GetEventOfType(name string) (EventData, error) {
 	return reflect.New(rawType).Interface().(EventData), nil
 }
 
+// RegisterUpcaster registers a transform of the event type's json from version `from` to `from + 1`
+func RegisterUpcaster(source EventData, from int, upcaster Upcaster) {
+	_, name := GetTypeName(source)
+	if upcasters[name] == nil {
+		upcasters[name] = make(map[int]Upcaster)
+	}
+	upcasters[name][from] = upcaster
+}
+
+// Upcast applies the chain of registered upcasters for the event type, starting at the given
+// version, and returns the upgraded json along with the version it now conforms to
+func Upcast(eventType string, data json.RawMessage, version int) (json.RawMessage, int, error) {
+	for {
+		upcaster, ok := upcasters[eventType][version]
+		if !ok {
+			return data, version, nil
+		}
+
+		upcasted, err := upcaster(data)
+		if err != nil {
+			return nil, version, fmt.Errorf("error upcasting %s from version %d: %s", eventType, version, err)
+		}
+		data = upcasted
+		version++
+	}
+}
+
+// LoadEventData creates event data of the named type from its stored json, upcasting it first
+func LoadEventData(eventType string, data json.RawMessage, version int) (EventData, error) {
+	eventData, err := GetEventOfType(eventType)
+	if err != nil {
+		return nil, err
+	}
+
+	data, version, err = Upcast(eventType, data, version)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := eventData.Load(data, version); err != nil {
+		return nil, err
+	}
+
+	return eventData, nil
+}
+
 // GetTypeName of given struct
 func GetTypeName(source interface{}) (reflect.Type, string) {
 	rawType := reflect.TypeOf(source)
@@ -0,0 +1,106 @@
+package eventsource
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/go-test/deep"
+)
+
+func init() {
+	RegisterEventType(&UpcastData{})
+	// v1 stored the name as "fullName", v2 renamed it to "first", and v3 to "firstName"
+	RegisterUpcaster(&UpcastData{}, 1, func(data json.RawMessage) (json.RawMessage, error) {
+		var v1 struct {
+			FullName string `json:"fullName"`
+		}
+		if err := json.Unmarshal(data, &v1); err != nil {
+			return nil, err
+		}
+		return json.Marshal(map[string]string{"first": v1.FullName})
+	})
+	RegisterUpcaster(&UpcastData{}, 2, func(data json.RawMessage) (json.RawMessage, error) {
+		var v2 struct {
+			First string `json:"first"`
+		}
+		if err := json.Unmarshal(data, &v2); err != nil {
+			return nil, err
+		}
+		return json.Marshal(&UpcastData{FirstName: v2.First})
+	})
+}
+
+func TestLoadEventData_Upcasts(t *testing.T) {
+	cases := []struct {
+		Label       string
+		Version     int
+		Data        string
+		Expected    EventData
+		ShouldError bool
+	}{
+		{
+			Label:    "applies the whole chain to version 1",
+			Version:  1,
+			Data:     `{"fullName": "Me!"}`,
+			Expected: &UpcastData{FirstName: "Me!"},
+		},
+		{
+			Label:    "applies the rest of the chain to version 2",
+			Version:  2,
+			Data:     `{"first": "Me!"}`,
+			Expected: &UpcastData{FirstName: "Me!"},
+		},
+		{
+			Label:    "does not upcast the latest version",
+			Version:  3,
+			Data:     `{"firstName": "Me!"}`,
+			Expected: &UpcastData{FirstName: "Me!"},
+		},
+		{
+			Label:       "returns errors from upcasters",
+			Version:     1,
+			Data:        `{"fullName": 1}`,
+			ShouldError: true,
+		},
+	}
+
+	for i, c := range cases {
+		got, err := LoadEventData("UpcastData", []byte(c.Data), c.Version)
+		if c.ShouldError {
+			if err == nil {
+				t.Errorf("Cases[%d] FAILED: %s.  Expected an error.", i, c.Label)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Cases[%d] FAILED: %s.  Error: %s", i, c.Label, err)
+			continue
+		}
+		if diff := deep.Equal(got, c.Expected); diff != nil {
+			t.Errorf("Cases[%d] FAILED: %s.  Error: %s", i, c.Label, diff)
+		}
+	}
+}
+
+func TestEvent_Load_Upcasts(t *testing.T) {
+	event := &Event{EventType: "UpcastData"}
+	data := `{"eventType":"UpcastData","eventVersion":1,"eventData":{"fullName":"Me!"}}`
+	if err := event.Load([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(event.Data, &UpcastData{FirstName: "Me!"}); diff != nil {
+		t.Error(diff)
+	}
+}
+
+type UpcastData struct {
+	FirstName string `json:"firstName"`
+}
+
+func (e *UpcastData) Version() int {
+	return 3
+}
+
+func (e *UpcastData) Load(data json.RawMessage, version int) error {
+	return json.Unmarshal(data, e)
+}
@@ -0,0 +1,75 @@
+package eventsource
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// RetryPolicy controls how ProcessCommand retries commands which fail with an AggregateLockError
+type RetryPolicy struct {
+	// MaxAttempts is the total number of times a command will be attempted, including the first
+	MaxAttempts int
+	// Backoff returns how long to wait after the given failed attempt
+	Backoff func(attempt int) time.Duration
+	// OnRetry is called after each failed attempt which will be retried, and can be used to report contention
+	OnRetry func(c Command, attempt int, err *AggregateLockError)
+}
+
+// DefaultRetryPolicy retries a command up to 3 times with exponential backoff, logging each retry
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	Backoff:     ExponentialBackoff(50 * time.Millisecond),
+	OnRetry: func(c Command, attempt int, err *AggregateLockError) {
+		log.Printf("Retrying %T for aggregate %s, attempt %d, after: %s", c, c.AggregateID(), attempt, err)
+	},
+}
+
+// NoRetryPolicy fails a command on the first AggregateLockError
+var NoRetryPolicy = RetryPolicy{
+	MaxAttempts: 1,
+}
+
+// ExponentialBackoff doubles the wait after each failed attempt, starting at base
+func ExponentialBackoff(base time.Duration) func(attempt int) time.Duration {
+	return func(attempt int) time.Duration {
+		return base * time.Duration(1<<uint(attempt-1))
+	}
+}
+
+func (p RetryPolicy) shouldRetry(attempt int, err error) (*AggregateLockError, bool) {
+	lockErr, ok := err.(*AggregateLockError)
+	if !ok || attempt >= p.MaxAttempts {
+		return nil, false
+	}
+	return lockErr, true
+}
+
+// wait backs off before the next attempt, returning the context's error if it's done first
+func (p RetryPolicy) wait(ctx context.Context, c Command, attempt int, err *AggregateLockError) error {
+	if p.OnRetry != nil {
+		p.OnRetry(c, attempt, err)
+	}
+	if p.Backoff == nil {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(p.Backoff(attempt))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Option configures an EventSource
+type Option func(es *EventSource)
+
+// WithRetryPolicy sets the policy used when a command fails with an AggregateLockError
+func WithRetryPolicy(p RetryPolicy) Option {
+	return func(es *EventSource) {
+		es.retryPolicy = p
+	}
+}
@@ -0,0 +1,164 @@
+package saga
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+
+	"forge.lmig.com/n1505471/pizza-shop/eventsource"
+)
+
+// ScheduleDeadline asks for an event to be delivered to the saga once the duration has
+// passed, unless a deadline with the same name is cancelled first
+type ScheduleDeadline struct {
+	Name  string
+	After time.Duration
+	Data  eventsource.EventData
+}
+
+// Deadline is a pending event for a saga, keyed by the saga and the deadline name so
+// scheduling a deadline again replaces the pending one
+type Deadline struct {
+	SagaID   string
+	SagaType string
+	Name     string
+	Due      time.Time
+	Event    eventsource.Event
+}
+
+// DeadlineStore persists the pending deadlines for every saga
+type DeadlineStore interface {
+	Schedule(ctx context.Context, deadline *Deadline) error
+	// Cancel removes the saga's pending deadline with the given name, if any
+	Cancel(ctx context.Context, sagaID string, name string) error
+	// CancelAll removes every pending deadline for the saga
+	CancelAll(ctx context.Context, sagaID string) error
+	// Due returns the deadlines due at or before the time given
+	Due(ctx context.Context, now time.Time) ([]*Deadline, error)
+	// Remove deletes a delivered deadline, unless it has been rescheduled since it was read
+	Remove(ctx context.Context, deadline *Deadline) error
+}
+
+// WithDeadlines lets sagas schedule deadlines, which are delivered by a Scheduler
+func WithDeadlines(store DeadlineStore) Option {
+	return func(m *SagaManager) {
+		m.deadlines = store
+	}
+}
+
+func (m *SagaManager) updateDeadlines(ctx context.Context, w *Wrapper, out *HandleEventResult, metadata eventsource.Metadata) error {
+	if len(out.CancelDeadlines) == 0 && len(out.Deadlines) == 0 {
+		return nil
+	}
+	if m.deadlines == nil {
+		return fmt.Errorf("%s saga %s uses deadlines, but no DeadlineStore was provided", w.Type, w.ID)
+	}
+
+	for _, name := range out.CancelDeadlines {
+		log.Printf("Cancelling deadline %s for saga %s", name, w.ID)
+		if err := m.deadlines.Cancel(ctx, w.ID, name); err != nil {
+			return err
+		}
+	}
+
+	for _, d := range out.Deadlines {
+		deadline := newDeadline(w, d, metadata, time.Now())
+		log.Printf("Scheduling deadline %s for saga %s at %s", d.Name, w.ID, deadline.Due)
+		if err := m.deadlines.Schedule(ctx, deadline); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func newDeadline(w *Wrapper, d *ScheduleDeadline, metadata eventsource.Metadata, now time.Time) *Deadline {
+	_, eventType := eventsource.GetTypeName(d.Data)
+	due := now.Add(d.After)
+	return &Deadline{
+		SagaID:   w.ID,
+		SagaType: w.Type,
+		Name:     d.Name,
+		Due:      due,
+		Event: eventsource.Event{
+			EventID:          uuid.New().String(),
+			AggregateID:      w.ID,
+			AggregateType:    w.Type,
+			EventType:        eventType,
+			EventTypeVersion: d.Data.Version(),
+			Timestamp:        due,
+			Metadata:         metadata,
+			Data:             d.Data,
+		},
+	}
+}
+
+// Scheduler delivers expired deadlines to their sagas through the SagaManager, which must
+// have the sagas registered
+type Scheduler struct {
+	manager   *SagaManager
+	deadlines DeadlineStore
+}
+
+func NewScheduler(manager *SagaManager, deadlines DeadlineStore) *Scheduler {
+	return &Scheduler{
+		manager:   manager,
+		deadlines: deadlines,
+	}
+}
+
+// RunDue delivers every deadline due at or before now, returning how many were delivered.
+// Failed deliveries are kept so they're retried on the next run.
+func (s *Scheduler) RunDue(ctx context.Context, now time.Time) (int, error) {
+	due, err := s.deadlines.Due(ctx, now)
+	if err != nil {
+		return 0, err
+	}
+
+	delivered := 0
+	var lastErr error
+	for _, d := range due {
+		if err := s.deliver(ctx, d); err != nil {
+			log.Printf("Error delivering deadline %s for saga %s, details: %s", d.Name, d.SagaID, err)
+			lastErr = err
+			continue
+		}
+		delivered++
+	}
+
+	return delivered, lastErr
+}
+
+// Run delivers due deadlines every interval until the context is done, for running the scheduler locally
+func (s *Scheduler) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if n, err := s.RunDue(ctx, now); err != nil || n > 0 {
+				log.Printf("Delivered %d deadlines, last error: %v", n, err)
+			}
+		}
+	}
+}
+
+func (s *Scheduler) deliver(ctx context.Context, d *Deadline) error {
+	factory, ok := s.manager.sagas.factories[d.SagaType]
+	if !ok {
+		return fmt.Errorf("No saga registered for type %s", d.SagaType)
+	}
+
+	log.Printf("Delivering deadline %s to saga %s", d.Name, d.SagaID)
+	if err := s.manager.ProcessEvent(ctx, d.Event, factory()); err != nil {
+		return err
+	}
+
+	return s.deadlines.Remove(ctx, d)
+}
@@ -0,0 +1,151 @@
+package saga_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"forge.lmig.com/n1505471/pizza-shop/eventsource"
+	"forge.lmig.com/n1505471/pizza-shop/eventsource/saga"
+	"forge.lmig.com/n1505471/pizza-shop/eventsource/saga/store/memory"
+)
+
+func TestScheduler_RunDue(t *testing.T) {
+	store := newMapStore()
+	deadlines := memory.NewDeadlineStore()
+	manager := saga.NewManager(store, saga.WithDeadlines(deadlines))
+	manager.Register(func() saga.SagaAPI { return &timerSaga{} })
+	scheduler := saga.NewScheduler(manager, deadlines)
+
+	for _, id := range []string{"waits", "answered"} {
+		if err := manager.ProcessEvent(context.Background(), eventsource.Event{EventType: "timerStarted", Data: &timerStarted{ID: id}}, &timerSaga{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := manager.ProcessEvent(context.Background(), eventsource.Event{EventType: "timerAnswered", Data: &timerAnswered{ID: "answered"}}, &timerSaga{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := scheduler.RunDue(context.Background(), time.Now()); err != nil || n != 0 {
+		t.Errorf("Expected no deadlines to be due yet, delivered %d, error: %v", n, err)
+	}
+
+	n, err := scheduler.RunDue(context.Background(), time.Now().Add(time.Hour))
+	if err != nil || n != 1 {
+		t.Errorf("Expected 1 deadline to be delivered, delivered %d, error: %v", n, err)
+	}
+
+	for id, expected := range map[string]bool{"waits": true, "answered": false} {
+		s := &timerSaga{}
+		w, err := store.Load(context.Background(), &saga.SagaAssociation{ID: id, AssociationType: "TimerID"}, s.Type())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Load(w.Data, w.Version); err != nil {
+			t.Fatal(err)
+		}
+		if s.TimedOut != expected {
+			t.Errorf("Saga %s: expected TimedOut to be %t", id, expected)
+		}
+	}
+
+	if n, _ := scheduler.RunDue(context.Background(), time.Now().Add(time.Hour)); n != 0 {
+		t.Errorf("Expected delivered deadlines to be removed, delivered %d again", n)
+	}
+}
+
+func TestSagaManager_ProcessEvent_RequiresDeadlineStore(t *testing.T) {
+	store := newMapStore()
+	manager := saga.NewManager(store)
+	err := manager.ProcessEvent(context.Background(), eventsource.Event{EventType: "timerStarted", Data: &timerStarted{ID: "waits"}}, &timerSaga{})
+	if err == nil {
+		t.Error("Expected an error scheduling a deadline without a DeadlineStore")
+	}
+}
+
+/*
+ * Set up
+ */
+
+type timerStarted struct {
+	ID string `json:"id"`
+}
+
+func (e *timerStarted) Version() int                                 { return 1 }
+func (e *timerStarted) Load(data json.RawMessage, version int) error { return json.Unmarshal(data, e) }
+
+type timerAnswered struct {
+	ID string `json:"id"`
+}
+
+func (e *timerAnswered) Version() int                                 { return 1 }
+func (e *timerAnswered) Load(data json.RawMessage, version int) error { return json.Unmarshal(data, e) }
+
+type timerStopped struct {
+	ID string `json:"id"`
+}
+
+func (e *timerStopped) Version() int                                 { return 1 }
+func (e *timerStopped) Load(data json.RawMessage, version int) error { return json.Unmarshal(data, e) }
+
+type timerExpired struct {
+	ID string `json:"id"`
+}
+
+func (e *timerExpired) Version() int                                 { return 1 }
+func (e *timerExpired) Load(data json.RawMessage, version int) error { return json.Unmarshal(data, e) }
+
+type timerSaga struct {
+	ID       string `json:"id"`
+	TimedOut bool   `json:"timedOut"`
+}
+
+func (s *timerSaga) Type() string       { return "TimerSaga" }
+func (s *timerSaga) Version() int       { return 1 }
+func (s *timerSaga) StartEvent() string { return "timerStarted" }
+
+func (s *timerSaga) EventTypes() []string {
+	return []string{"timerAnswered", "timerStopped"}
+}
+
+func (s *timerSaga) Load(data json.RawMessage, version int) error {
+	return json.Unmarshal(data, s)
+}
+
+func (s *timerSaga) AssociationID(event eventsource.Event) (*saga.SagaAssociation, error) {
+	switch d := event.Data.(type) {
+	case *timerStarted:
+		return &saga.SagaAssociation{ID: d.ID, AssociationType: "TimerID"}, nil
+	case *timerAnswered:
+		return &saga.SagaAssociation{ID: d.ID, AssociationType: "TimerID"}, nil
+	case *timerStopped:
+		return &saga.SagaAssociation{ID: d.ID, AssociationType: "TimerID"}, nil
+	case *timerExpired:
+		return &saga.SagaAssociation{ID: d.ID, AssociationType: "TimerID"}, nil
+	default:
+		return nil, fmt.Errorf("Unsupported event %T", d)
+	}
+}
+
+func (s *timerSaga) HandleEvent(_ context.Context, event eventsource.Event) (*saga.HandleEventResult, error) {
+	switch d := event.Data.(type) {
+	case *timerStarted:
+		s.ID = d.ID
+		return &saga.HandleEventResult{Deadlines: []*saga.ScheduleDeadline{{
+			Name:  "timeout",
+			After: time.Minute,
+			Data:  &timerExpired{ID: d.ID},
+		}}}, nil
+	case *timerAnswered:
+		return &saga.HandleEventResult{CancelDeadlines: []string{"timeout"}}, nil
+	case *timerStopped:
+		return &saga.HandleEventResult{Ended: true}, nil
+	case *timerExpired:
+		s.TimedOut = true
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("Unsupported event %T", d)
+	}
+}
@@ -0,0 +1,49 @@
+package saga
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Migration transforms the raw json of a saga's state from one version of its schema to the next
+type Migration func(data json.RawMessage) (json.RawMessage, error)
+
+// migrations holds the registered migrations per saga type, keyed by the version they upgrade from
+var migrations = make(map[string]map[int]Migration)
+
+// RegisterMigration registers a transform of the saga type's state from version `from` to `from + 1`
+func RegisterMigration(s SagaAPI, from int, migration Migration) {
+	sagaType := s.Type()
+	if migrations[sagaType] == nil {
+		migrations[sagaType] = make(map[int]Migration)
+	}
+	migrations[sagaType][from] = migration
+}
+
+// Migrate applies the chain of registered migrations for the saga type, starting at the given
+// version, and returns the upgraded json along with the version it now conforms to
+func Migrate(sagaType string, data json.RawMessage, version int) (json.RawMessage, int, error) {
+	for {
+		migration, ok := migrations[sagaType][version]
+		if !ok {
+			return data, version, nil
+		}
+
+		migrated, err := migration(data)
+		if err != nil {
+			return nil, version, fmt.Errorf("error migrating %s from version %d: %s", sagaType, version, err)
+		}
+		data = migrated
+		version++
+	}
+}
+
+// LoadSaga loads the saga from its stored json, migrating it to the latest version first
+func LoadSaga(s SagaAPI, data json.RawMessage, version int) error {
+	data, version, err := Migrate(s.Type(), data, version)
+	if err != nil {
+		return err
+	}
+
+	return s.Load(data, version)
+}
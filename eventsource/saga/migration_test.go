@@ -0,0 +1,168 @@
+package saga_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/go-test/deep"
+
+	"forge.lmig.com/n1505471/pizza-shop/eventsource"
+	"forge.lmig.com/n1505471/pizza-shop/eventsource/eventsourcetest"
+	"forge.lmig.com/n1505471/pizza-shop/eventsource/saga"
+)
+
+func init() {
+	// Version 1 of the AlarmSaga stored whether the alarm rang as "yes" or "no"
+	saga.RegisterMigration(&alarmSaga{}, 1, func(data json.RawMessage) (json.RawMessage, error) {
+		var v1 struct {
+			ID   string `json:"id"`
+			Rang string `json:"rang"`
+		}
+		if err := json.Unmarshal(data, &v1); err != nil {
+			return nil, err
+		}
+		return json.Marshal(&alarmSaga{ID: v1.ID, Rang: v1.Rang == "yes"})
+	})
+}
+
+func TestMigrate(t *testing.T) {
+	cases := eventsourcetest.SagaMigrationTestCases{
+		{
+			Label:           "migrates version 1 state to the latest version",
+			Saga:            &alarmSaga{},
+			Version:         1,
+			State:           `{"id":"alarm","rang":"yes"}`,
+			Expected:        `{"id":"alarm","rang":true}`,
+			ExpectedVersion: 2,
+		},
+		{
+			Label:           "leaves the latest version unchanged",
+			Saga:            &alarmSaga{},
+			Version:         2,
+			State:           `{"id":"alarm","rang":true}`,
+			Expected:        `{"id":"alarm","rang":true}`,
+			ExpectedVersion: 2,
+		},
+		{
+			Label:           "leaves sagas without migrations unchanged",
+			Saga:            &timerSaga{},
+			Version:         1,
+			State:           `{"id":"timer","timedOut":false}`,
+			Expected:        `{"id":"timer","timedOut":false}`,
+			ExpectedVersion: 1,
+		},
+		{
+			Label:       "returns an error when a migration fails",
+			Saga:        &alarmSaga{},
+			Version:     1,
+			State:       `{"rang":true}`,
+			ShouldError: true,
+		},
+	}
+
+	cases.Test(t)
+}
+
+func TestAlarmSaga_Load(t *testing.T) {
+	cases := eventsourcetest.SagaLoadTestCases{
+		{
+			Label:    "migrates version 1 state",
+			Version:  1,
+			Saga:     `{"id":"alarm","rang":"no"}`,
+			Expected: &alarmSaga{ID: "alarm", Rang: false},
+		},
+		{
+			Label:    "loads version 2 state",
+			Version:  2,
+			Saga:     `{"id":"alarm","rang":true}`,
+			Expected: &alarmSaga{ID: "alarm", Rang: true},
+		},
+	}
+
+	cases.Test(t)
+}
+
+func TestSagaManager_ProcessEvent_Migrates(t *testing.T) {
+	store := newMapStore()
+	store.sagas["alarm"] = &saga.Wrapper{ID: "alarm", Type: "AlarmSaga", Version: 1, Revision: 1, Data: []byte(`{"id":"alarm","rang":"no"}`)}
+	store.associations["alarm#AlarmID#AlarmSaga"] = "alarm"
+
+	manager := saga.NewManager(store)
+	if err := manager.ProcessEvent(context.Background(), eventsource.Event{EventType: "alarmRang", Data: &alarmRang{ID: "alarm"}}, &alarmSaga{}); err != nil {
+		t.Fatal(err)
+	}
+
+	w := store.sagas["alarm"]
+	if w.Version != 2 {
+		t.Errorf("Expected the saga to be saved at version 2, got %d", w.Version)
+	}
+	var got, expected interface{}
+	json.Unmarshal(w.Data, &got)
+	json.Unmarshal([]byte(`{"id":"alarm","rang":true}`), &expected)
+	if diff := deep.Equal(got, expected); diff != nil {
+		t.Error(diff)
+	}
+}
+
+/*
+ * Set up
+ */
+
+type alarmSet struct {
+	ID string `json:"id"`
+}
+
+func (e *alarmSet) Version() int                                 { return 1 }
+func (e *alarmSet) Load(data json.RawMessage, version int) error { return json.Unmarshal(data, e) }
+
+type alarmRang struct {
+	ID string `json:"id"`
+}
+
+func (e *alarmRang) Version() int                                 { return 1 }
+func (e *alarmRang) Load(data json.RawMessage, version int) error { return json.Unmarshal(data, e) }
+
+type alarmSaga struct {
+	ID   string `json:"id"`
+	Rang bool   `json:"rang"`
+}
+
+func (s *alarmSaga) Type() string         { return "AlarmSaga" }
+func (s *alarmSaga) Version() int         { return 2 }
+func (s *alarmSaga) StartEvent() string   { return "alarmSet" }
+func (s *alarmSaga) EventTypes() []string { return []string{"alarmRang"} }
+
+func (s *alarmSaga) Load(data json.RawMessage, version int) error {
+	switch version {
+	case 2:
+		return json.Unmarshal(data, s)
+	default:
+		return fmt.Errorf("Unsupported AlarmSaga version %d", version)
+	}
+}
+
+func (s *alarmSaga) AssociationID(event eventsource.Event) (*saga.SagaAssociation, error) {
+	switch d := event.Data.(type) {
+	case *alarmSet:
+		return &saga.SagaAssociation{ID: d.ID, AssociationType: "AlarmID"}, nil
+	case *alarmRang:
+		return &saga.SagaAssociation{ID: d.ID, AssociationType: "AlarmID"}, nil
+	default:
+		return nil, fmt.Errorf("Unsupported event %T", d)
+	}
+}
+
+func (s *alarmSaga) HandleEvent(_ context.Context, event eventsource.Event) (*saga.HandleEventResult, error) {
+	switch d := event.Data.(type) {
+	case *alarmSet:
+		s.ID = d.ID
+		return nil, nil
+	case *alarmRang:
+		s.Rang = true
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("Unsupported event %T", d)
+	}
+}
@@ -0,0 +1,206 @@
+package saga
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+
+	"forge.lmig.com/n1505471/pizza-shop/eventsource"
+)
+
+// OutboxMessage is an intent emitted by a saga, e.g. a call to an external service, waiting to
+// be carried out.  Messages are saved together with the saga state which emitted them, so a
+// failed save can't leave a side effect behind.
+type OutboxMessage struct {
+	ID       string
+	SagaID   string
+	SagaType string
+	// Intent holds the intent data, which must be registered with eventsource.RegisterEventType
+	Intent    eventsource.Event
+	CreatedAt time.Time
+}
+
+// OutboxStore reads the messages saved to the outbox by a Storer
+type OutboxStore interface {
+	// Pending returns the messages which aren't claimed by another dispatcher
+	Pending(ctx context.Context, now time.Time) ([]*OutboxMessage, error)
+	// Claim reserves the message until the time given, returning false if it is already claimed
+	Claim(ctx context.Context, message *OutboxMessage, now time.Time, until time.Time) (bool, error)
+	// Remove deletes a message once it has been carried out
+	Remove(ctx context.Context, message *OutboxMessage) error
+}
+
+func newOutboxMessage(w *Wrapper, intent eventsource.EventData, metadata eventsource.Metadata, now time.Time) *OutboxMessage {
+	_, intentType := eventsource.GetTypeName(intent)
+	return &OutboxMessage{
+		ID:       uuid.New().String(),
+		SagaID:   w.ID,
+		SagaType: w.Type,
+		Intent: eventsource.Event{
+			EventID:          uuid.New().String(),
+			AggregateID:      w.ID,
+			AggregateType:    w.Type,
+			EventType:        intentType,
+			EventTypeVersion: intent.Version(),
+			Timestamp:        now,
+			Metadata:         metadata,
+			Data:             intent,
+		},
+		CreatedAt: now,
+	}
+}
+
+// IntentHandler carries out an intent, returning an event recording the result, which is
+// delivered back to the saga, or nil if the saga doesn't need to hear about it.  The context
+// carries the Metadata for any commands the handler issues, caused by the intent.
+type IntentHandler func(ctx context.Context, intent eventsource.Event) (eventsource.EventData, error)
+
+// OutboxDispatcher carries out the intents in the outbox and delivers their results to the
+// sagas through the SagaManager, which must have the sagas registered.  A message may be
+// carried out more than once if the dispatcher fails before removing it, so handlers should
+// be safe to repeat where the external service allows.
+type OutboxDispatcher struct {
+	manager  *SagaManager
+	outbox   OutboxStore
+	handlers map[string]IntentHandler
+
+	// MaxAttempts is the number of times a message is attempted per run, and its result delivered,
+	// failed messages are left in the outbox for the next run
+	MaxAttempts int
+	// Backoff returns how long to wait after the given failed attempt
+	Backoff func(attempt int) time.Duration
+	// Lease is how long a message is claimed for while it's carried out
+	Lease time.Duration
+}
+
+func NewOutboxDispatcher(manager *SagaManager, outbox OutboxStore) *OutboxDispatcher {
+	return &OutboxDispatcher{
+		manager:     manager,
+		outbox:      outbox,
+		handlers:    make(map[string]IntentHandler),
+		MaxAttempts: 3,
+		Backoff:     eventsource.ExponentialBackoff(100 * time.Millisecond),
+		Lease:       time.Minute,
+	}
+}
+
+// Handle registers the handler for the intent's type
+func (d *OutboxDispatcher) Handle(intent eventsource.EventData, handler IntentHandler) {
+	_, intentType := eventsource.GetTypeName(intent)
+	d.handlers[intentType] = handler
+}
+
+// RunPending carries out every pending message, returning how many were carried out
+func (d *OutboxDispatcher) RunPending(ctx context.Context, now time.Time) (int, error) {
+	pending, err := d.outbox.Pending(ctx, now)
+	if err != nil {
+		return 0, err
+	}
+
+	dispatched := 0
+	var lastErr error
+	for _, m := range pending {
+		claimed, err := d.outbox.Claim(ctx, m, now, now.Add(d.Lease))
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if !claimed {
+			continue
+		}
+
+		if err := d.dispatch(ctx, m); err != nil {
+			log.Printf("Error dispatching %s for saga %s, details: %s", m.Intent.EventType, m.SagaID, err)
+			lastErr = err
+			continue
+		}
+		dispatched++
+	}
+
+	return dispatched, lastErr
+}
+
+func (d *OutboxDispatcher) dispatch(ctx context.Context, m *OutboxMessage) error {
+	handler, ok := d.handlers[m.Intent.EventType]
+	if !ok {
+		return fmt.Errorf("No handler registered for intent %s", m.Intent.EventType)
+	}
+
+	intentCtx := eventsource.WithMetadata(ctx, eventsource.CausedBy(m.Intent))
+	var result eventsource.EventData
+	err := d.retry(ctx, func() error {
+		var err error
+		result, err = handler(intentCtx, m.Intent)
+		return err
+	}, func(attempt int, err error) {
+		log.Printf("Retrying %s for saga %s, attempt %d, after: %s", m.Intent.EventType, m.SagaID, attempt, err)
+	})
+	if err != nil {
+		return err
+	}
+
+	// Once the intent is carried out only its delivery is retried, so the external service isn't
+	// called again because the saga couldn't be saved
+	if result != nil {
+		err := d.retry(ctx, func() error {
+			return d.deliver(ctx, m, result)
+		}, func(attempt int, err error) {
+			log.Printf("Retrying delivery of %s to saga %s, attempt %d, after: %s", m.Intent.EventType, m.SagaID, attempt, err)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return d.outbox.Remove(ctx, m)
+}
+
+// retry calls f up to MaxAttempts times until it succeeds, backing off between attempts, and
+// returns the context's error if it's done while waiting
+func (d *OutboxDispatcher) retry(ctx context.Context, f func() error, onRetry func(attempt int, err error)) error {
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil || attempt >= d.MaxAttempts {
+			return err
+		}
+		onRetry(attempt, err)
+		if d.Backoff == nil {
+			continue
+		}
+
+		timer := time.NewTimer(d.Backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// deliver records the result of the intent with the saga which emitted it.  The result's
+// EventID is derived from the message, so a redelivered result is ignored by the saga.
+func (d *OutboxDispatcher) deliver(ctx context.Context, m *OutboxMessage, result eventsource.EventData) error {
+	factory, ok := d.manager.sagas.factories[m.SagaType]
+	if !ok {
+		return fmt.Errorf("No saga registered for type %s", m.SagaType)
+	}
+
+	_, eventType := eventsource.GetTypeName(result)
+	event := eventsource.Event{
+		EventID:          m.ID,
+		AggregateID:      m.SagaID,
+		AggregateType:    m.SagaType,
+		EventType:        eventType,
+		EventTypeVersion: result.Version(),
+		Timestamp:        time.Now(),
+		Metadata:         eventsource.CausedBy(m.Intent),
+		Data:             result,
+	}
+
+	log.Printf("Delivering the result of %s to saga %s", m.Intent.EventType, m.SagaID)
+	return d.manager.ProcessEvent(ctx, event, factory())
+}
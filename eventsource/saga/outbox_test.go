@@ -0,0 +1,231 @@
+package saga_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"forge.lmig.com/n1505471/pizza-shop/eventsource"
+	"forge.lmig.com/n1505471/pizza-shop/eventsource/saga"
+	"forge.lmig.com/n1505471/pizza-shop/eventsource/saga/store/memory"
+)
+
+func TestOutboxDispatcher_RunPending(t *testing.T) {
+	cases := []struct {
+		Label             string
+		Failures          int
+		SaveFailures      int
+		ClaimedElsewhere  bool
+		ExpectedAttempts  int
+		ExpectedCollected bool
+		ExpectedPending   int
+		ShouldError       bool
+	}{
+		{
+			Label:             "carries out intents and delivers their results to the saga",
+			ExpectedAttempts:  1,
+			ExpectedCollected: true,
+		},
+		{
+			Label:             "retries failed intents within a run",
+			Failures:          1,
+			ExpectedAttempts:  2,
+			ExpectedCollected: true,
+		},
+		{
+			Label:            "keeps intents which fail every attempt for the next run",
+			Failures:         2,
+			ExpectedAttempts: 2,
+			ExpectedPending:  1,
+			ShouldError:      true,
+		},
+		{
+			Label:             "retries delivering the result without carrying out the intent again",
+			SaveFailures:      1,
+			ExpectedAttempts:  1,
+			ExpectedCollected: true,
+		},
+		{
+			Label:            "keeps intents whose result can't be delivered for the next run",
+			SaveFailures:     2,
+			ExpectedAttempts: 1,
+			ExpectedPending:  1,
+			ShouldError:      true,
+		},
+		{
+			Label:            "skips intents claimed by another dispatcher",
+			ClaimedElsewhere: true,
+			ExpectedPending:  1,
+		},
+	}
+
+	for i, c := range cases {
+		store := &failingOutboxStore{SagaStore: memory.NewSagaStore()}
+		manager := saga.NewManager(store)
+		manager.Register(func() saga.SagaAPI { return &courierSaga{} })
+		dispatcher := saga.NewOutboxDispatcher(manager, store)
+		dispatcher.MaxAttempts = 2
+		dispatcher.Backoff = nil
+
+		attempts := 0
+		dispatcher.Handle(&collectParcel{}, func(_ context.Context, intent eventsource.Event) (eventsource.EventData, error) {
+			attempts++
+			if attempts <= c.Failures {
+				return nil, fmt.Errorf("Courier unavailable")
+			}
+			return &parcelCollected{ID: intent.Data.(*collectParcel).ID}, nil
+		})
+
+		if err := manager.ProcessEvent(context.Background(), eventsource.Event{EventType: "parcelSent", Data: &parcelSent{ID: "parcel"}}, &courierSaga{}); err != nil {
+			t.Fatal(err)
+		}
+
+		store.failures = c.SaveFailures
+		now := time.Now()
+		if c.ClaimedElsewhere {
+			pending, _ := store.Pending(context.Background(), now)
+			for _, m := range pending {
+				store.Claim(context.Background(), m, now, now.Add(time.Minute))
+			}
+		}
+
+		_, err := dispatcher.RunPending(context.Background(), now)
+		if c.ShouldError != (err != nil) {
+			t.Errorf("Cases[%d] FAILED: %s.  Error: %v", i, c.Label, err)
+		}
+
+		if attempts != c.ExpectedAttempts {
+			t.Errorf("Cases[%d] FAILED: %s.  Expected the intent to be carried out %d times, got %d", i, c.Label, c.ExpectedAttempts, attempts)
+		}
+
+		_, loadErr := store.Load(context.Background(), &saga.SagaAssociation{ID: "parcel", AssociationType: "ParcelID"}, "CourierSaga")
+		if collected := loadErr != nil; collected != c.ExpectedCollected {
+			t.Errorf("Cases[%d] FAILED: %s.  Expected the saga to have ended: %t", i, c.Label, c.ExpectedCollected)
+		}
+
+		pending, _ := store.Pending(context.Background(), now.Add(time.Hour))
+		if len(pending) != c.ExpectedPending {
+			t.Errorf("Cases[%d] FAILED: %s.  Expected %d pending intents, got %d", i, c.Label, c.ExpectedPending, len(pending))
+		}
+	}
+}
+
+func TestOutboxDispatcher_RunPending_Cancelled(t *testing.T) {
+	store := memory.NewSagaStore()
+	manager := saga.NewManager(store)
+	manager.Register(func() saga.SagaAPI { return &courierSaga{} })
+	dispatcher := saga.NewOutboxDispatcher(manager, store)
+	dispatcher.Backoff = func(int) time.Duration { return time.Hour }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	dispatcher.Handle(&collectParcel{}, func(_ context.Context, _ eventsource.Event) (eventsource.EventData, error) {
+		cancel()
+		return nil, fmt.Errorf("Courier unavailable")
+	})
+
+	if err := manager.ProcessEvent(context.Background(), eventsource.Event{EventType: "parcelSent", Data: &parcelSent{ID: "parcel"}}, &courierSaga{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The backoff is cut short when the context is done
+	if _, err := dispatcher.RunPending(ctx, time.Now()); err != context.Canceled {
+		t.Errorf("Expected the run to stop when the context is cancelled, got: %v", err)
+	}
+}
+
+func TestSagaManager_ProcessEvent_DropsIntentsOnError(t *testing.T) {
+	store := memory.NewSagaStore()
+	manager := saga.NewManager(store)
+
+	err := manager.ProcessEvent(context.Background(), eventsource.Event{EventType: "parcelSent", Data: &parcelSent{ID: "parcel", Lost: true}}, &courierSaga{})
+	if err == nil {
+		t.Error("Expected an error handling the event")
+	}
+	if pending, _ := store.Pending(context.Background(), time.Now()); len(pending) != 0 {
+		t.Errorf("Expected no intents to be queued when the event fails, got %d", len(pending))
+	}
+}
+
+/*
+ * Set up
+ */
+
+// failingOutboxStore fails the next failures saves, as if the saga table were unavailable
+type failingOutboxStore struct {
+	*memory.SagaStore
+	failures int
+}
+
+func (s *failingOutboxStore) Save(ctx context.Context, w *saga.Wrapper) error {
+	if s.failures > 0 {
+		s.failures--
+		return fmt.Errorf("Saga table unavailable")
+	}
+	return s.SagaStore.Save(ctx, w)
+}
+
+type parcelSent struct {
+	ID   string `json:"id"`
+	Lost bool   `json:"lost"`
+}
+
+func (e *parcelSent) Version() int                                 { return 1 }
+func (e *parcelSent) Load(data json.RawMessage, version int) error { return json.Unmarshal(data, e) }
+
+type collectParcel struct {
+	ID string `json:"id"`
+}
+
+func (e *collectParcel) Version() int                                 { return 1 }
+func (e *collectParcel) Load(data json.RawMessage, version int) error { return json.Unmarshal(data, e) }
+
+type parcelCollected struct {
+	ID string `json:"id"`
+}
+
+func (e *parcelCollected) Version() int { return 1 }
+func (e *parcelCollected) Load(data json.RawMessage, version int) error {
+	return json.Unmarshal(data, e)
+}
+
+// courierSaga asks for a parcel to be collected once it's sent, ending once it is
+type courierSaga struct {
+	ID        string `json:"id"`
+	Collected bool   `json:"collected"`
+}
+
+func (s *courierSaga) Type() string                                 { return "CourierSaga" }
+func (s *courierSaga) Version() int                                 { return 1 }
+func (s *courierSaga) StartEvent() string                           { return "parcelSent" }
+func (s *courierSaga) EventTypes() []string                         { return nil }
+func (s *courierSaga) Load(data json.RawMessage, version int) error { return json.Unmarshal(data, s) }
+
+func (s *courierSaga) AssociationID(event eventsource.Event) (*saga.SagaAssociation, error) {
+	switch d := event.Data.(type) {
+	case *parcelSent:
+		return &saga.SagaAssociation{ID: d.ID, AssociationType: "ParcelID"}, nil
+	case *parcelCollected:
+		return &saga.SagaAssociation{ID: d.ID, AssociationType: "ParcelID"}, nil
+	default:
+		return nil, fmt.Errorf("Unsupported event %T", d)
+	}
+}
+
+func (s *courierSaga) HandleEvent(_ context.Context, event eventsource.Event) (*saga.HandleEventResult, error) {
+	switch d := event.Data.(type) {
+	case *parcelSent:
+		s.ID = d.ID
+		result := &saga.HandleEventResult{Intents: []eventsource.EventData{&collectParcel{ID: d.ID}}}
+		if d.Lost {
+			return result, fmt.Errorf("Parcel %s was lost", d.ID)
+		}
+		return result, nil
+	case *parcelCollected:
+		s.Collected = true
+		return &saga.HandleEventResult{Ended: true}, nil
+	default:
+		return nil, fmt.Errorf("Unsupported event %T", d)
+	}
+}
@@ -0,0 +1,96 @@
+package saga
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"forge.lmig.com/n1505471/pizza-shop/eventsource"
+)
+
+// registry holds a factory for every saga type the SagaManager routes events to
+type registry struct {
+	factories map[string]func() SagaAPI
+	// routes lists the saga types interested in each event type, in registration order
+	routes map[string][]string
+}
+
+func newRegistry() *registry {
+	return &registry{
+		factories: make(map[string]func() SagaAPI),
+		routes:    make(map[string][]string),
+	}
+}
+
+func (r *registry) add(factory func() SagaAPI) {
+	s := factory()
+	_, replaced := r.factories[s.Type()]
+	r.factories[s.Type()] = factory
+	if replaced {
+		return
+	}
+
+	eventTypes := append([]string{s.StartEvent()}, s.EventTypes()...)
+	seen := make(map[string]bool)
+	for _, eventType := range eventTypes {
+		if seen[eventType] {
+			continue
+		}
+		seen[eventType] = true
+		r.routes[eventType] = append(r.routes[eventType], s.Type())
+	}
+}
+
+// Register provides a new, empty saga for each event of the types the saga declares.
+// Registering a saga type again replaces its factory.
+func (m *SagaManager) Register(factory func() SagaAPI) {
+	m.sagas.add(factory)
+}
+
+// Dispatch processes the event with every registered saga interested in it.  Each saga is
+// processed independently, so one failing doesn't stop the others seeing the event.
+func (m *SagaManager) Dispatch(ctx context.Context, event eventsource.Event) error {
+	sagaTypes := m.sagas.routes[event.EventType]
+	if len(sagaTypes) == 0 {
+		log.Printf("No sagas registered for %s, ignoring it", event.EventType)
+		return nil
+	}
+
+	errs := SagaErrors{}
+	for _, sagaType := range sagaTypes {
+		if err := m.ProcessEvent(ctx, event, m.sagas.factories[sagaType]()); err != nil {
+			log.Printf("Error processing %s with %s, details: %s", event.EventType, sagaType, err)
+			errs[sagaType] = err
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// SagaErrors are the errors from the sagas which failed to process an event, keyed by saga type
+type SagaErrors map[string]error
+
+func (e SagaErrors) Error() string {
+	messages := make([]string, 0, len(e))
+	for sagaType, err := range e {
+		messages = append(messages, fmt.Sprintf("%s: %s", sagaType, err))
+	}
+	sort.Strings(messages)
+	return fmt.Sprintf("%d sagas failed, details: %s", len(e), strings.Join(messages, "; "))
+}
+
+// As lets errors.As find the error of any of the sagas
+func (e SagaErrors) As(target interface{}) bool {
+	for _, err := range e {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
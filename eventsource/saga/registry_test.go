@@ -0,0 +1,77 @@
+package saga_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"forge.lmig.com/n1505471/pizza-shop/eventsource"
+	"forge.lmig.com/n1505471/pizza-shop/eventsource/saga"
+	"forge.lmig.com/n1505471/pizza-shop/eventsource/saga/store/memory"
+)
+
+func TestSagaManager_Dispatch(t *testing.T) {
+	cases := []struct {
+		Label          string
+		Event          eventsource.Event
+		ExpectedTimers int
+		ExpectedErrors []string
+	}{
+		{
+			Label:          "routes events to every interested saga, isolating failures",
+			Event:          eventsource.Event{EventType: "timerStarted", Data: &timerStarted{ID: "timer"}},
+			ExpectedTimers: 1,
+			ExpectedErrors: []string{"FailingSaga"},
+		},
+		{
+			Label: "ignores events no saga is interested in",
+			Event: eventsource.Event{EventType: "timerReset", Data: &timerStarted{ID: "timer"}},
+		},
+	}
+
+	for i, c := range cases {
+		store := newMapStore()
+		manager := saga.NewManager(store, saga.WithDeadlines(memory.NewDeadlineStore()))
+		manager.Register(func() saga.SagaAPI { return &failingSaga{} })
+		manager.Register(func() saga.SagaAPI { return &timerSaga{} })
+
+		err := manager.Dispatch(context.Background(), c.Event)
+		if len(c.ExpectedErrors) == 0 && err != nil {
+			t.Errorf("Cases[%d] FAILED: %s.  Error: %s", i, c.Label, err)
+		}
+		if len(c.ExpectedErrors) > 0 {
+			errs, ok := err.(saga.SagaErrors)
+			if !ok {
+				t.Errorf("Cases[%d] FAILED: %s.  Expected SagaErrors, got: %v", i, c.Label, err)
+				continue
+			}
+			for _, sagaType := range c.ExpectedErrors {
+				if _, ok := errs[sagaType]; !ok {
+					t.Errorf("Cases[%d] FAILED: %s.  Expected an error from %s, got: %s", i, c.Label, sagaType, err)
+				}
+			}
+		}
+
+		if len(store.sagas) != c.ExpectedTimers {
+			t.Errorf("Cases[%d] FAILED: %s.  Expected %d timer sagas to be started, got %d", i, c.Label, c.ExpectedTimers, len(store.sagas))
+		}
+	}
+}
+
+// failingSaga starts on the same event as the timerSaga, but can't handle it
+type failingSaga struct{}
+
+func (s *failingSaga) Type() string                                 { return "FailingSaga" }
+func (s *failingSaga) Version() int                                 { return 1 }
+func (s *failingSaga) StartEvent() string                           { return "timerStarted" }
+func (s *failingSaga) EventTypes() []string                         { return nil }
+func (s *failingSaga) Load(data json.RawMessage, version int) error { return nil }
+
+func (s *failingSaga) AssociationID(event eventsource.Event) (*saga.SagaAssociation, error) {
+	return nil, fmt.Errorf("Unsupported event %T", event.Data)
+}
+
+func (s *failingSaga) HandleEvent(_ context.Context, event eventsource.Event) (*saga.HandleEventResult, error) {
+	return nil, fmt.Errorf("Unsupported event %T", event.Data)
+}
@@ -0,0 +1,67 @@
+package saga
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"forge.lmig.com/n1505471/pizza-shop/eventsource"
+)
+
+// RetryPolicy controls how ProcessEvent retries events which fail with a SagaConcurrencyError
+type RetryPolicy struct {
+	// MaxAttempts is the total number of times an event will be attempted, including the first
+	MaxAttempts int
+	// Backoff returns how long to wait after the given failed attempt
+	Backoff func(attempt int) time.Duration
+	// OnRetry is called after each failed attempt which will be retried, and can be used to report contention
+	OnRetry func(event eventsource.Event, attempt int, err *SagaConcurrencyError)
+}
+
+// DefaultRetryPolicy retries an event up to 3 times with exponential backoff, logging each retry
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	Backoff:     eventsource.ExponentialBackoff(50 * time.Millisecond),
+	OnRetry: func(event eventsource.Event, attempt int, err *SagaConcurrencyError) {
+		log.Printf("Retrying %s for saga %s, attempt %d, after: %s", event.EventType, err.SagaID, attempt, err)
+	},
+}
+
+// NoRetryPolicy fails an event on the first SagaConcurrencyError
+var NoRetryPolicy = RetryPolicy{
+	MaxAttempts: 1,
+}
+
+func (p RetryPolicy) shouldRetry(attempt int, err error) (*SagaConcurrencyError, bool) {
+	concurrencyErr, ok := err.(*SagaConcurrencyError)
+	if !ok || attempt >= p.MaxAttempts {
+		return nil, false
+	}
+	return concurrencyErr, true
+}
+
+// wait backs off before the next attempt, returning the context's error if it's done first
+func (p RetryPolicy) wait(ctx context.Context, event eventsource.Event, attempt int, err *SagaConcurrencyError) error {
+	if p.OnRetry != nil {
+		p.OnRetry(event, attempt, err)
+	}
+	if p.Backoff == nil {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(p.Backoff(attempt))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// WithRetryPolicy sets the policy used when saving a saga fails with a SagaConcurrencyError
+func WithRetryPolicy(p RetryPolicy) Option {
+	return func(m *SagaManager) {
+		m.retryPolicy = p
+	}
+}
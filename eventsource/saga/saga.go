@@ -1,9 +1,12 @@
 package saga
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
+	"reflect"
+	"time"
 
 	"github.com/google/uuid"
 
@@ -11,16 +14,79 @@ import (
 )
 
 type SagaManager struct {
-	store Storer
+	store         Storer
+	deadlines     DeadlineStore
+	retryPolicy   RetryPolicy
+	ignoreUnknown bool
+	onPending     func(event eventsource.Event, err *SagaAssociationPendingError)
+	sagas         *registry
 }
 
-func NewManager(store Storer) *SagaManager {
-	return &SagaManager{
-		store: store,
+// Option configures optional SagaManager behaviour
+type Option func(m *SagaManager)
+
+func NewManager(store Storer, opts ...Option) *SagaManager {
+	m := &SagaManager{
+		store:       store,
+		retryPolicy: DefaultRetryPolicy,
+		sagas:       newRegistry(),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// IgnoreUnknownSagas acknowledges events which don't belong to any saga, rather than failing,
+// e.g. events for orders started before the saga was deployed.  Events for a saga's
+// LateAssociations still fail, since their association may not be saved yet.  Events for sagas
+// which have ended are ignored either way.
+func IgnoreUnknownSagas() Option {
+	return func(m *SagaManager) {
+		m.ignoreUnknown = true
+	}
+}
+
+// OnPendingAssociation is called for each event which arrives before the late association it
+// belongs to is saved, e.g. to record a metric.  See LateAssociations.
+func OnPendingAssociation(f func(event eventsource.Event, err *SagaAssociationPendingError)) Option {
+	return func(m *SagaManager) {
+		m.onPending = f
 	}
 }
 
-func (m *SagaManager) ProcessEvent(event eventsource.Event, d SagaAPI) error {
+// ProcessEvent handles the event, retrying according to the RetryPolicy if another
+// process saved the saga first.  Each attempt resets the saga to the state it was given in,
+// then reloads it and reapplies the event.  Retries stop once the context is done.
+func (m *SagaManager) ProcessEvent(ctx context.Context, event eventsource.Event, d SagaAPI) error {
+	reset := resetter(d)
+	for attempt := 1; ; attempt++ {
+		err := m.processEvent(ctx, event, d)
+		concurrencyErr, ok := m.retryPolicy.shouldRetry(attempt, err)
+		if !ok {
+			return err
+		}
+		if err := m.retryPolicy.wait(ctx, event, attempt, concurrencyErr); err != nil {
+			return err
+		}
+
+		// Discard the state the failed attempt left on the saga
+		reset()
+	}
+}
+
+// resetter returns a func which restores the saga to its state now, keeping the dependencies it
+// was constructed with.  The saga must be a pointer to a struct, and the copy is shallow.
+func resetter(d SagaAPI) func() {
+	v := reflect.ValueOf(d).Elem()
+	initial := reflect.New(v.Type()).Elem()
+	initial.Set(v)
+	return func() {
+		v.Set(initial)
+	}
+}
+
+func (m *SagaManager) processEvent(ctx context.Context, event eventsource.Event, d SagaAPI) error {
 	associationID, err := d.AssociationID(event)
 	if err != nil {
 		return err
@@ -31,17 +97,46 @@ func (m *SagaManager) ProcessEvent(event eventsource.Event, d SagaAPI) error {
 		Type: d.Type(),
 	}
 	if d.StartEvent() == event.EventType {
+		duplicate, err := m.startedBy(ctx, event, associationID, d.Type())
+		if err != nil {
+			return err
+		}
+		if duplicate {
+			log.Printf("Ignoring %s %s, which already started a %s saga", event.EventType, event.EventID, d.Type())
+			return nil
+		}
 		w.ID = uuid.New().String()
-		if err := m.store.AddAssociationID(associationID, w); err != nil {
+		w.Associations = []*SagaAssociation{associationID}
+		if err := m.store.AddAssociationID(ctx, associationID, w); err != nil {
 			return err
 		}
 	} else {
-		w, err = m.store.Load(associationID, d.Type())
+		w, err = m.store.Load(ctx, associationID, d.Type())
 		if err != nil {
+			if _, ok := err.(*SagaEndedError); ok {
+				log.Printf("Ignoring %s, details: %s", event.EventType, err)
+				return nil
+			}
+			if _, ok := err.(*SagaAssociationNotFoundError); ok && isLate(d, associationID) {
+				return m.pending(event, associationID, d.Type())
+			}
+			if m.ignoreUnknown && isNotFound(err) {
+				log.Printf("Ignoring %s, no %s saga found, details: %s", event.EventType, d.Type(), err)
+				return nil
+			}
 			return err
 		}
+		if w.Ended {
+			// The saga was saved as ended, but its clean up may have been interrupted
+			log.Printf("Ignoring %s for %s saga %s, which has already ended", event.EventType, w.Type, w.ID)
+			return m.end(ctx, w)
+		}
+		if w.hasProcessed(event.EventID) {
+			log.Printf("Ignoring %s %s for %s saga %s, which has already been processed", event.EventType, event.EventID, w.Type, w.ID)
+			return nil
+		}
 		log.Printf("Sent to Saga Load: %+s", w.Data)
-		if err := d.Load(w.Data, w.Version); err != nil {
+		if err := LoadSaga(d, w.Data, w.Version); err != nil {
 			return err
 		}
 	}
@@ -50,7 +145,9 @@ func (m *SagaManager) ProcessEvent(event eventsource.Event, d SagaAPI) error {
 	// defer handling errors, since we'll want to make sure saga state and any associations
 	// have the chance to be saved.
 	log.Printf("Before Saga state: %+v", d)
-	out, handleEventErr := d.HandleEvent(event)
+	// The saga's commands, intents and deadlines are caused by the event
+	metadata := eventsource.CausedBy(event)
+	out, handleEventErr := d.HandleEvent(eventsource.WithMetadata(ctx, metadata), event)
 
 	// Save SagaWrapper
 	b, err := json.Marshal(d)
@@ -59,41 +156,164 @@ func (m *SagaManager) ProcessEvent(event eventsource.Event, d SagaAPI) error {
 	}
 	w.Version = d.Version()
 	w.Data = b
+	if handleEventErr == nil && event.EventID != "" {
+		w.ProcessedEvents = append(w.ProcessedEvents, event.EventID)
+	}
+	if out != nil {
+		w.Associations = append(w.Associations, out.AssociationIDs...)
+		w.Ended = handleEventErr == nil && out.Ended
+	}
+	// Intents are only queued once the event is handled, since a failed event is handled again
+	w.Outbox = nil
+	if out != nil && handleEventErr == nil {
+		for _, intent := range out.Intents {
+			w.Outbox = append(w.Outbox, newOutboxMessage(w, intent, metadata, time.Now()))
+		}
+	}
 	log.Printf("Wrapper Saga state: %+s", string(w.Data))
-	if err := m.store.Save(w); err != nil {
+	if err := m.store.Save(ctx, w); err != nil {
 		return err
 	}
 
 	if out != nil {
 		for _, id := range out.AssociationIDs {
 			log.Printf("Adding associationId: %+v", id)
-			if err := m.store.AddAssociationID(id, w); err != nil {
+			if err := m.store.AddAssociationID(ctx, id, w); err != nil {
 				return err
 			}
 		}
+		if err := m.updateDeadlines(ctx, w, out, metadata); err != nil {
+			return err
+		}
 	}
 
 	if handleEventErr != nil {
-		return err
+		return handleEventErr
+	}
+
+	if w.Ended {
+		return m.end(ctx, w)
 	}
 
 	return nil
 }
 
+// startedBy checks whether a saga has already been started by the event, so a redelivered
+// start event doesn't start a second saga.  The association is added before the saga is first
+// saved, so an association without a saga is from a start which failed to save.  A saga which
+// has ended was started by the event, since it was the saga's only start event.
+func (m *SagaManager) startedBy(ctx context.Context, event eventsource.Event, associationID *SagaAssociation, sagaType string) (bool, error) {
+	if event.EventID == "" {
+		return false, nil
+	}
+
+	w, err := m.store.Load(ctx, associationID, sagaType)
+	if err != nil {
+		if _, ok := err.(*SagaEndedError); ok {
+			return true, nil
+		}
+		if isNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return w.hasProcessed(event.EventID), nil
+}
+
+// isLate reports whether the association is one the saga adds from the results of its intents
+func isLate(d SagaAPI, association *SagaAssociation) bool {
+	late, ok := d.(LateAssociations)
+	if !ok {
+		return false
+	}
+	for _, t := range late.LateAssociationTypes() {
+		if t == association.AssociationType {
+			return true
+		}
+	}
+	return false
+}
+
+// pending fails an event which arrived before its late association was saved, so it's redelivered
+func (m *SagaManager) pending(event eventsource.Event, association *SagaAssociation, sagaType string) error {
+	err := &SagaAssociationPendingError{
+		AssociationID:   association.ID,
+		AssociationType: association.AssociationType,
+		SagaType:        sagaType,
+	}
+	log.Printf("Failing %s %s for redelivery, details: %s", event.EventType, event.EventID, err)
+	if m.onPending != nil {
+		m.onPending(event, err)
+	}
+	return err
+}
+
+func isNotFound(err error) bool {
+	switch err.(type) {
+	case *SagaAssociationNotFoundError, *SagaNotFoundError:
+		return true
+	default:
+		return false
+	}
+}
+
+// end cleans up after a saga completes.  The saga is saved as ended first, so if the clean up
+// is interrupted, events which still find the saga are ignored and end it again.  Each step is
+// safe to repeat.
+func (m *SagaManager) end(ctx context.Context, w *Wrapper) error {
+	log.Printf("%s saga %s has ended, removing its deadlines and associations", w.Type, w.ID)
+
+	if m.deadlines != nil {
+		if err := m.deadlines.CancelAll(ctx, w.ID); err != nil {
+			return err
+		}
+	}
+
+	for _, a := range w.Associations {
+		if err := m.store.RemoveAssociationID(ctx, a, w); err != nil {
+			return err
+		}
+	}
+
+	return m.store.Archive(ctx, w)
+}
+
 type Storer interface {
-	Load(association *SagaAssociation, sagaType string) (*Wrapper, error)
-	AddAssociationID(association *SagaAssociation, saga *Wrapper) error
-	Save(saga *Wrapper) error
+	Load(ctx context.Context, association *SagaAssociation, sagaType string) (*Wrapper, error)
+	AddAssociationID(ctx context.Context, association *SagaAssociation, saga *Wrapper) error
+	// RemoveAssociationID stops the association's events reaching the ended saga.  Loading the
+	// association afterwards returns a SagaEndedError, at least for a while, so a late event for
+	// an ended saga isn't mistaken for one arriving before its association is saved.
+	RemoveAssociationID(ctx context.Context, association *SagaAssociation, saga *Wrapper) error
+	// Save stores the saga if it is still at the wrapper's Revision, incrementing the Revision,
+	// and returns a SagaConcurrencyError if another process saved it first.  The wrapper's Outbox
+	// messages are saved in the same write, so neither is saved without the other.
+	Save(ctx context.Context, saga *Wrapper) error
+	// Archive moves an ended saga out of the active sagas, keeping its final state
+	Archive(ctx context.Context, saga *Wrapper) error
 }
 
 type SagaAPI interface {
 	Type() string
 	Version() int
 	StartEvent() string
+	// EventTypes are the events the saga handles, besides its StartEvent, used to route events to it
+	EventTypes() []string
 	Load(data json.RawMessage, version int) error
 
 	AssociationID(event eventsource.Event) (*SagaAssociation, error)
-	HandleEvent(event eventsource.Event) (*HandleEventResult, error)
+	// HandleEvent is given a context carrying the Metadata for the commands the saga issues
+	HandleEvent(ctx context.Context, event eventsource.Event) (*HandleEventResult, error)
+}
+
+// LateAssociations is implemented by sagas which associate themselves with IDs returned by their
+// intents, e.g. a callback ID from an external system.  An event for one of those IDs can arrive
+// before the intent's result is saved, so when the association is missing the event fails with a
+// SagaAssociationPendingError, even with IgnoreUnknownSagas, rather than being dropped.  Events
+// for the associations of a saga which has ended are ignored.
+type LateAssociations interface {
+	LateAssociationTypes() []string
 }
 
 type Wrapper struct {
@@ -101,10 +321,40 @@ type Wrapper struct {
 	Version int
 	Type    string
 	Data    json.RawMessage
+	// Revision counts the saves of the saga, zero until it is first saved
+	Revision int
+	// Associations are every association added for the saga, removed once it ends
+	Associations []*SagaAssociation
+	Ended        bool
+	// ProcessedEvents are the IDs of the events the saga has handled, so redelivered events are ignored
+	ProcessedEvents []string
+	// Outbox holds the messages emitted by the event being saved, which aren't loaded with the saga
+	Outbox []*OutboxMessage
+}
+
+func (w *Wrapper) hasProcessed(eventID string) bool {
+	if eventID == "" {
+		return false
+	}
+	for _, id := range w.ProcessedEvents {
+		if id == eventID {
+			return true
+		}
+	}
+	return false
 }
 
 type HandleEventResult struct {
 	AssociationIDs []*SagaAssociation
+	// Ended marks the saga as complete, so it no longer receives events
+	Ended bool
+	// Deadlines are delivered back to the saga as events, unless cancelled first
+	Deadlines []*ScheduleDeadline
+	// CancelDeadlines names the pending deadlines which are no longer needed
+	CancelDeadlines []string
+	// Intents are side effects, e.g. calls to external services, saved to the outbox with the
+	// saga and carried out by an OutboxDispatcher
+	Intents []eventsource.EventData
 }
 
 type SagaAssociation struct {
@@ -121,6 +371,28 @@ func (e *SagaAssociationNotFoundError) Error() string {
 	return fmt.Sprintf("No %s saga found for AssociationID %s", e.SagaType, e.AssociationID)
 }
 
+// SagaAssociationPendingError is returned for an event whose late association hasn't been saved
+// yet, so the event should be redelivered
+type SagaAssociationPendingError struct {
+	AssociationID   string
+	AssociationType string
+	SagaType        string
+}
+
+func (e *SagaAssociationPendingError) Error() string {
+	return fmt.Sprintf("SagaAssociationPendingError: No %s saga found for %s %s yet", e.SagaType, e.AssociationType, e.AssociationID)
+}
+
+// SagaEndedError is returned when loading an association of a saga which has ended
+type SagaEndedError struct {
+	SagaID   string
+	SagaType string
+}
+
+func (e *SagaEndedError) Error() string {
+	return fmt.Sprintf("SagaEndedError: %s saga %s has ended", e.SagaType, e.SagaID)
+}
+
 type SagaNotFoundError struct {
 	SagaID string
 }
@@ -128,3 +400,14 @@ type SagaNotFoundError struct {
 func (e *SagaNotFoundError) Error() string {
 	return fmt.Sprintf("No saga found for SagaID %s", e.SagaID)
 }
+
+// SagaConcurrencyError is returned when saving a saga which another process has saved
+// since it was loaded
+type SagaConcurrencyError struct {
+	SagaID   string
+	Revision int
+}
+
+func (e *SagaConcurrencyError) Error() string {
+	return fmt.Sprintf("SagaConcurrencyError: Saga with id %s has been saved since revision %d", e.SagaID, e.Revision)
+}
@@ -0,0 +1,435 @@
+package saga_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"forge.lmig.com/n1505471/pizza-shop/eventsource"
+	"forge.lmig.com/n1505471/pizza-shop/eventsource/saga"
+	"forge.lmig.com/n1505471/pizza-shop/eventsource/saga/store/memory"
+	"github.com/go-test/deep"
+)
+
+// SETUP
+func TestMain(m *testing.M) {
+	log.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+func TestSagaManager_ProcessEvent_Ends(t *testing.T) {
+	store := newMapStore()
+	deadlines := memory.NewDeadlineStore()
+	manager := saga.NewManager(store, saga.WithDeadlines(deadlines))
+
+	events := []eventsource.Event{
+		{EventType: "timerStarted", Data: &timerStarted{ID: "timer"}},
+		{EventType: "timerStopped", Data: &timerStopped{ID: "timer"}},
+	}
+	for _, e := range events {
+		if err := manager.ProcessEvent(context.Background(), e, &timerSaga{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(store.associations) != 0 {
+		t.Errorf("Expected the associations to be removed, got %+v", store.associations)
+	}
+	if len(store.sagas) != 0 || len(store.archived) != 1 {
+		t.Errorf("Expected the saga to be archived, got %d active and %d archived", len(store.sagas), len(store.archived))
+	}
+	for _, w := range store.archived {
+		if !w.Ended {
+			t.Errorf("Expected the archived saga to be marked as ended, got %+v", w)
+		}
+	}
+	if due, _ := deadlines.Due(context.Background(), time.Now().Add(time.Hour)); len(due) != 0 {
+		t.Errorf("Expected the saga's deadlines to be cancelled, got %d", len(due))
+	}
+
+	// Events which still find an ended saga are ignored, and finish its interrupted clean up
+	store.sagas["ended"] = &saga.Wrapper{
+		ID:           "ended",
+		Type:         "TimerSaga",
+		Data:         []byte(`{}`),
+		Associations: []*saga.SagaAssociation{{ID: "ended", AssociationType: "TimerID"}},
+		Ended:        true,
+	}
+	store.associations["ended#TimerID#TimerSaga"] = "ended"
+	deadlines.Schedule(context.Background(), &saga.Deadline{SagaID: "ended", Name: "expire", Due: time.Now()})
+	if err := manager.ProcessEvent(context.Background(), eventsource.Event{EventType: "timerExpired", Data: &timerExpired{ID: "ended"}}, &timerSaga{}); err != nil {
+		t.Errorf("Expected events for an ended saga to be ignored, got: %s", err)
+	}
+	if archived, ok := store.archived["ended"]; !ok || string(archived.Data) != `{}` {
+		t.Errorf("Expected the ended saga to be archived without being updated, got %+v", archived)
+	}
+	if len(store.associations) != 0 {
+		t.Errorf("Expected the ended saga's associations to be removed, got %+v", store.associations)
+	}
+	if due, _ := deadlines.Due(context.Background(), time.Now().Add(time.Hour)); len(due) != 0 {
+		t.Errorf("Expected the ended saga's deadlines to be cancelled, got %d", len(due))
+	}
+}
+
+func TestSagaManager_ProcessEvent_Retries(t *testing.T) {
+	cases := []struct {
+		Label            string
+		Policy           saga.RetryPolicy
+		Conflicts        int
+		ExpectedRevision int
+		ShouldError      bool
+	}{
+		{
+			Label:            "saves without conflicts",
+			Policy:           saga.RetryPolicy{MaxAttempts: 3},
+			ExpectedRevision: 2,
+		},
+		{
+			Label:            "reloads the saga and reapplies the event after a conflict",
+			Policy:           saga.RetryPolicy{MaxAttempts: 3},
+			Conflicts:        2,
+			ExpectedRevision: 4,
+		},
+		{
+			Label:       "gives up after MaxAttempts",
+			Policy:      saga.RetryPolicy{MaxAttempts: 2},
+			Conflicts:   2,
+			ShouldError: true,
+		},
+		{
+			Label:       "does not retry with the NoRetryPolicy",
+			Policy:      saga.NoRetryPolicy,
+			Conflicts:   1,
+			ShouldError: true,
+		},
+	}
+
+	for i, c := range cases {
+		store := &conflictingStore{mapStore: newMapStore()}
+		manager := saga.NewManager(store, saga.WithRetryPolicy(c.Policy), saga.WithDeadlines(memory.NewDeadlineStore()))
+		if err := manager.ProcessEvent(context.Background(), eventsource.Event{EventType: "timerStarted", Data: &timerStarted{ID: "timer"}}, &timerSaga{}); err != nil {
+			t.Fatal(err)
+		}
+
+		store.conflicts = c.Conflicts
+		err := manager.ProcessEvent(context.Background(), eventsource.Event{EventType: "timerExpired", Data: &timerExpired{ID: "timer"}}, &timerSaga{})
+		if c.ShouldError {
+			if _, ok := err.(*saga.SagaConcurrencyError); !ok {
+				t.Errorf("Cases[%d] FAILED: %s.  Expected a SagaConcurrencyError, got: %v", i, c.Label, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Cases[%d] FAILED: %s.  Error: %s", i, c.Label, err)
+			continue
+		}
+
+		for _, w := range store.sagas {
+			if w.Revision != c.ExpectedRevision {
+				t.Errorf("Cases[%d] FAILED: %s.  Expected revision %d, got %d", i, c.Label, c.ExpectedRevision, w.Revision)
+			}
+			s := &timerSaga{}
+			if err := s.Load(w.Data, w.Version); err != nil || !s.TimedOut {
+				t.Errorf("Cases[%d] FAILED: %s.  Expected the event to be applied, got %s", i, c.Label, w.Data)
+			}
+		}
+	}
+}
+
+func TestSagaManager_ProcessEvent_RetriesFromCleanState(t *testing.T) {
+	store := &failingSaveStore{mapStore: newMapStore(), failures: 1}
+	manager := saga.NewManager(store, saga.WithRetryPolicy(saga.RetryPolicy{MaxAttempts: 2}), saga.WithDeadlines(memory.NewDeadlineStore()))
+
+	// The start event is retried after the conflict, on the saga as it was given
+	if err := manager.ProcessEvent(context.Background(), eventsource.Event{EventType: "timerStarted", Data: &timerStarted{ID: "timer"}}, &countingSaga{}); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := store.Load(context.Background(), &saga.SagaAssociation{ID: "timer", AssociationType: "TimerID"}, "TimerSaga")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(w.Data) != `{"id":"timer","timedOut":false,"handled":1}` {
+		t.Errorf("Expected the event to be applied once to the saved saga, got %s", w.Data)
+	}
+}
+
+func TestSagaManager_ProcessEvent_Cancelled(t *testing.T) {
+	store := &conflictingStore{mapStore: newMapStore()}
+	policy := saga.RetryPolicy{
+		MaxAttempts: 3,
+		Backoff:     func(int) time.Duration { return time.Hour },
+	}
+	manager := saga.NewManager(store, saga.WithRetryPolicy(policy), saga.WithDeadlines(memory.NewDeadlineStore()))
+	if err := manager.ProcessEvent(context.Background(), eventsource.Event{EventType: "timerStarted", Data: &timerStarted{ID: "timer"}}, &timerSaga{}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	store.conflicts = 1
+	err := manager.ProcessEvent(ctx, eventsource.Event{EventType: "timerExpired", Data: &timerExpired{ID: "timer"}}, &timerSaga{})
+	if err != context.Canceled {
+		t.Errorf("Expected the retry to stop with the context, got: %v", err)
+	}
+}
+
+func TestSagaManager_ProcessEvent_Deduplicates(t *testing.T) {
+	store := newMapStore()
+	manager := saga.NewManager(store, saga.WithDeadlines(memory.NewDeadlineStore()))
+
+	events := []eventsource.Event{
+		{EventID: "started", EventType: "timerStarted", Data: &timerStarted{ID: "timer"}},
+		{EventID: "started", EventType: "timerStarted", Data: &timerStarted{ID: "timer"}},
+		{EventID: "expired", EventType: "timerExpired", Data: &timerExpired{ID: "timer"}},
+		{EventID: "expired", EventType: "timerExpired", Data: &timerExpired{ID: "timer"}},
+	}
+	for _, e := range events {
+		if err := manager.ProcessEvent(context.Background(), e, &timerSaga{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(store.sagas) != 1 {
+		t.Fatalf("Expected a redelivered start event not to start another saga, got %d sagas", len(store.sagas))
+	}
+	for _, w := range store.sagas {
+		if w.Revision != 2 {
+			t.Errorf("Expected redelivered events not to be handled, got revision %d", w.Revision)
+		}
+		if diff := deep.Equal(w.ProcessedEvents, []string{"started", "expired"}); diff != nil {
+			t.Error(diff)
+		}
+	}
+}
+
+func TestSagaManager_ProcessEvent_StartAfterFailedSave(t *testing.T) {
+	store := &failingSaveStore{mapStore: newMapStore(), failures: 1}
+	manager := saga.NewManager(store, saga.WithRetryPolicy(saga.NoRetryPolicy), saga.WithDeadlines(memory.NewDeadlineStore()))
+
+	// The association is added, but the saga isn't saved
+	started := eventsource.Event{EventID: "started", EventType: "timerStarted", Data: &timerStarted{ID: "timer"}}
+	if err := manager.ProcessEvent(context.Background(), started, &timerSaga{}); err == nil {
+		t.Fatal("Expected the first save to fail")
+	}
+
+	// The redelivered start event starts the saga, so later events find it
+	if err := manager.ProcessEvent(context.Background(), started, &timerSaga{}); err != nil {
+		t.Fatalf("Expected the redelivered start event to start the saga, got: %s", err)
+	}
+	if err := manager.ProcessEvent(context.Background(), eventsource.Event{EventID: "expired", EventType: "timerExpired", Data: &timerExpired{ID: "timer"}}, &timerSaga{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.sagas) != 1 {
+		t.Fatalf("Expected one saga, got %d", len(store.sagas))
+	}
+	for _, w := range store.sagas {
+		if diff := deep.Equal(w.ProcessedEvents, []string{"started", "expired"}); diff != nil {
+			t.Error(diff)
+		}
+	}
+}
+
+func TestSagaManager_ProcessEvent_UnknownSagas(t *testing.T) {
+	cases := []struct {
+		Label       string
+		Options     []saga.Option
+		ShouldError bool
+	}{
+		{
+			Label:       "fails events without a saga by default",
+			ShouldError: true,
+		},
+		{
+			Label:   "ignores events without a saga with IgnoreUnknownSagas",
+			Options: []saga.Option{saga.IgnoreUnknownSagas()},
+		},
+	}
+
+	for i, c := range cases {
+		manager := saga.NewManager(newMapStore(), c.Options...)
+		err := manager.ProcessEvent(context.Background(), eventsource.Event{EventType: "timerExpired", Data: &timerExpired{ID: "unknown"}}, &timerSaga{})
+		if c.ShouldError {
+			if _, ok := err.(*saga.SagaAssociationNotFoundError); !ok {
+				t.Errorf("Cases[%d] FAILED: %s.  Expected a SagaAssociationNotFoundError, got: %v", i, c.Label, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Cases[%d] FAILED: %s.  Error: %s", i, c.Label, err)
+		}
+	}
+}
+
+func TestSagaManager_ProcessEvent_PendingAssociations(t *testing.T) {
+	var recorded []*saga.SagaAssociationPendingError
+	manager := saga.NewManager(newMapStore(), saga.IgnoreUnknownSagas(), saga.OnPendingAssociation(func(_ eventsource.Event, err *saga.SagaAssociationPendingError) {
+		recorded = append(recorded, err)
+	}))
+
+	// Late associations may not have been saved yet, so their events fail rather than being ignored
+	err := manager.ProcessEvent(context.Background(), eventsource.Event{EventType: "timerExpired", Data: &timerExpired{ID: "unknown"}}, &lateTimerSaga{})
+	expected := &saga.SagaAssociationPendingError{AssociationID: "unknown", AssociationType: "TimerID", SagaType: "TimerSaga"}
+	if diff := deep.Equal(err, expected); diff != nil {
+		t.Errorf("Expected a SagaAssociationPendingError: %s", diff)
+	}
+	if diff := deep.Equal(recorded, []*saga.SagaAssociationPendingError{expected}); diff != nil {
+		t.Errorf("Expected the pending association to be recorded: %s", diff)
+	}
+
+	var pending *saga.SagaAssociationPendingError
+	if !errors.As(saga.SagaErrors{"TimerSaga": err}, &pending) {
+		t.Errorf("Expected errors.As to find the pending association in SagaErrors")
+	}
+}
+
+func TestSagaManager_ProcessEvent_IgnoresEndedSagas(t *testing.T) {
+	var recorded []*saga.SagaAssociationPendingError
+	manager := saga.NewManager(newMapStore(), saga.WithDeadlines(memory.NewDeadlineStore()), saga.OnPendingAssociation(func(_ eventsource.Event, err *saga.SagaAssociationPendingError) {
+		recorded = append(recorded, err)
+	}))
+
+	events := []eventsource.Event{
+		{EventType: "timerStarted", Data: &timerStarted{ID: "timer"}},
+		{EventType: "timerStopped", Data: &timerStopped{ID: "timer"}},
+	}
+	for _, e := range events {
+		if err := manager.ProcessEvent(context.Background(), e, &lateTimerSaga{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The ended saga's associations are gone, but a late event for them isn't waiting on one
+	err := manager.ProcessEvent(context.Background(), eventsource.Event{EventType: "timerExpired", Data: &timerExpired{ID: "timer"}}, &lateTimerSaga{})
+	if err != nil {
+		t.Errorf("Expected events for an ended saga to be ignored, got: %s", err)
+	}
+	if len(recorded) != 0 {
+		t.Errorf("Expected no pending associations to be recorded, got %+v", recorded)
+	}
+}
+
+/*
+ * Set up
+ */
+
+// lateTimerSaga is associated with its timers by the result of an intent, rather than its start event
+type lateTimerSaga struct {
+	timerSaga
+}
+
+func (s *lateTimerSaga) LateAssociationTypes() []string {
+	return []string{"TimerID"}
+}
+
+// mapStore is a minimal saga.Storer keeping sagas and associations in maps
+type mapStore struct {
+	sagas        map[string]*saga.Wrapper
+	associations map[string]string
+	ended        map[string]string
+	archived     map[string]*saga.Wrapper
+}
+
+func newMapStore() *mapStore {
+	return &mapStore{
+		sagas:        map[string]*saga.Wrapper{},
+		associations: map[string]string{},
+		ended:        map[string]string{},
+		archived:     map[string]*saga.Wrapper{},
+	}
+}
+
+func (m *mapStore) Load(_ context.Context, association *saga.SagaAssociation, sagaType string) (*saga.Wrapper, error) {
+	key := association.ID + "#" + association.AssociationType + "#" + sagaType
+	id, ok := m.associations[key]
+	if ended, isEnded := m.ended[key]; !ok && isEnded {
+		return nil, &saga.SagaEndedError{SagaID: ended, SagaType: sagaType}
+	}
+	if !ok {
+		return nil, &saga.SagaAssociationNotFoundError{AssociationID: association.ID, SagaType: sagaType}
+	}
+	saved, ok := m.sagas[id]
+	if !ok {
+		return nil, &saga.SagaNotFoundError{SagaID: id}
+	}
+	w := *saved
+	return &w, nil
+}
+
+func (m *mapStore) AddAssociationID(_ context.Context, association *saga.SagaAssociation, w *saga.Wrapper) error {
+	m.associations[association.ID+"#"+association.AssociationType+"#"+w.Type] = w.ID
+	return nil
+}
+
+func (m *mapStore) Save(_ context.Context, w *saga.Wrapper) error {
+	revision := 0
+	if existing, ok := m.sagas[w.ID]; ok {
+		revision = existing.Revision
+	}
+	if revision != w.Revision {
+		return &saga.SagaConcurrencyError{SagaID: w.ID, Revision: w.Revision}
+	}
+	w.Revision++
+	saved := *w
+	m.sagas[w.ID] = &saved
+	return nil
+}
+
+func (m *mapStore) RemoveAssociationID(_ context.Context, association *saga.SagaAssociation, w *saga.Wrapper) error {
+	key := association.ID + "#" + association.AssociationType + "#" + w.Type
+	delete(m.associations, key)
+	m.ended[key] = w.ID
+	return nil
+}
+
+func (m *mapStore) Archive(_ context.Context, w *saga.Wrapper) error {
+	m.archived[w.ID] = m.sagas[w.ID]
+	delete(m.sagas, w.ID)
+	return nil
+}
+
+// conflictingStore saves the saga from "another process" before each of the next conflicts saves
+type conflictingStore struct {
+	*mapStore
+	conflicts int
+}
+
+func (s *conflictingStore) Save(ctx context.Context, w *saga.Wrapper) error {
+	if s.conflicts > 0 {
+		s.conflicts--
+		concurrent := *s.sagas[w.ID]
+		if err := s.mapStore.Save(ctx, &concurrent); err != nil {
+			return err
+		}
+	}
+	return s.mapStore.Save(ctx, w)
+}
+
+// failingSaveStore fails the next failures saves, as if another process saved the saga first
+type failingSaveStore struct {
+	*mapStore
+	failures int
+}
+
+func (s *failingSaveStore) Save(ctx context.Context, w *saga.Wrapper) error {
+	if s.failures > 0 {
+		s.failures--
+		return &saga.SagaConcurrencyError{SagaID: w.ID, Revision: w.Revision}
+	}
+	return s.mapStore.Save(ctx, w)
+}
+
+// countingSaga counts the events applied to it
+type countingSaga struct {
+	timerSaga
+	Handled int `json:"handled"`
+}
+
+func (s *countingSaga) HandleEvent(ctx context.Context, event eventsource.Event) (*saga.HandleEventResult, error) {
+	s.Handled++
+	return s.timerSaga.HandleEvent(ctx, event)
+}
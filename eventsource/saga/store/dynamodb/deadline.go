@@ -0,0 +1,215 @@
+package dynamodb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"forge.lmig.com/n1505471/pizza-shop/eventsource"
+	"forge.lmig.com/n1505471/pizza-shop/eventsource/saga"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// DeadlineStore keeps the pending saga deadlines, keyed by sagaId#name and indexed by sagaId.
+// Delivered and cancelled deadlines are deleted, so the table stays small enough to scan for due ones.
+type DeadlineStore struct {
+	svc       *dynamodb.DynamoDB
+	tableName *string
+}
+
+func NewDeadlineStore(svc *dynamodb.DynamoDB, t string) *DeadlineStore {
+	return &DeadlineStore{
+		svc:       svc,
+		tableName: aws.String(t),
+	}
+}
+
+// deadlineDto is the DynamoDB representation of a deadline.  Due is stored in Unix nanoseconds
+// so it compares numerically, and the event as json so it loads through the event registry.
+type deadlineDto struct {
+	CompositeKey string `dynamodbav:"compositeKey"`
+	SagaID       string `dynamodbav:"sagaId"`
+	SagaType     string `dynamodbav:"sagaType"`
+	Name         string `dynamodbav:"name"`
+	Due          int64  `dynamodbav:"due"`
+	Event        string `dynamodbav:"event"`
+}
+
+func (s *DeadlineStore) Schedule(ctx context.Context, deadline *saga.Deadline) error {
+	event, err := json.Marshal(deadline.Event)
+	if err != nil {
+		return err
+	}
+
+	av, err := dynamodbattribute.MarshalMap(&deadlineDto{
+		CompositeKey: deadlineKey(deadline.SagaID, deadline.Name),
+		SagaID:       deadline.SagaID,
+		SagaType:     deadline.SagaType,
+		Name:         deadline.Name,
+		Due:          deadline.Due.UnixNano(),
+		Event:        string(event),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.svc.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: s.tableName,
+		Item:      av,
+	})
+	return err
+}
+
+func (s *DeadlineStore) Cancel(ctx context.Context, sagaID string, name string) error {
+	_, err := s.svc.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		TableName: s.tableName,
+		Key: map[string]*dynamodb.AttributeValue{
+			"compositeKey": {S: aws.String(deadlineKey(sagaID, name))},
+		},
+	})
+	return err
+}
+
+// sagaIndex is the GSI on sagaId, used to find every deadline for a saga
+const sagaIndex = "sagaId-index"
+
+func (s *DeadlineStore) CancelAll(ctx context.Context, sagaID string) error {
+	var keys []string
+	err := s.svc.QueryPagesWithContext(ctx, &dynamodb.QueryInput{
+		TableName:              s.tableName,
+		IndexName:              aws.String(sagaIndex),
+		KeyConditionExpression: aws.String("sagaId = :sagaId"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":sagaId": {S: aws.String(sagaID)},
+		},
+		ProjectionExpression: aws.String("compositeKey"),
+	}, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			keys = append(keys, itemKey(item, "compositeKey"))
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		_, err := s.svc.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+			TableName: s.tableName,
+			Key: map[string]*dynamodb.AttributeValue{
+				"compositeKey": {S: aws.String(key)},
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *DeadlineStore) Due(ctx context.Context, now time.Time) ([]*saga.Deadline, error) {
+	due, err := dynamodbattribute.Marshal(now.UnixNano())
+	if err != nil {
+		return nil, err
+	}
+
+	var deadlines []*saga.Deadline
+	err = s.svc.ScanPagesWithContext(ctx, &dynamodb.ScanInput{
+		TableName:        s.tableName,
+		FilterExpression: aws.String("#due <= :due"),
+		ExpressionAttributeNames: map[string]*string{
+			"#due": aws.String("due"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":due": due,
+		},
+		ConsistentRead: aws.Bool(true),
+	}, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			d, err := toDeadline(item)
+			if err != nil {
+				// Skip the deadline rather than fail the scan, so it doesn't hold up the others
+				log.Printf("Skipping deadline %s, which can't be loaded, details: %s", itemKey(item, "compositeKey"), err)
+				continue
+			}
+			deadlines = append(deadlines, d)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return deadlines, nil
+}
+
+func (s *DeadlineStore) Remove(ctx context.Context, deadline *saga.Deadline) error {
+	due, err := dynamodbattribute.Marshal(deadline.Due.UnixNano())
+	if err != nil {
+		return err
+	}
+
+	_, err = s.svc.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		TableName: s.tableName,
+		Key: map[string]*dynamodb.AttributeValue{
+			"compositeKey": {S: aws.String(deadlineKey(deadline.SagaID, deadline.Name))},
+		},
+		ConditionExpression: aws.String("#due = :due"),
+		ExpressionAttributeNames: map[string]*string{
+			"#due": aws.String("due"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":due": due,
+		},
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case dynamodb.ErrCodeConditionalCheckFailedException:
+				// The deadline was cancelled or rescheduled while it was being delivered
+				return nil
+			}
+		}
+	}
+	return err
+}
+
+func toDeadline(item map[string]*dynamodb.AttributeValue) (*saga.Deadline, error) {
+	dto := &deadlineDto{}
+	if err := dynamodbattribute.UnmarshalMap(item, dto); err != nil {
+		return nil, err
+	}
+
+	event := eventsource.Event{}
+	if err := event.Load([]byte(dto.Event)); err != nil {
+		return nil, fmt.Errorf("Error loading event for deadline %s, details: %s", dto.CompositeKey, err)
+	}
+
+	return &saga.Deadline{
+		SagaID:   dto.SagaID,
+		SagaType: dto.SagaType,
+		Name:     dto.Name,
+		Due:      time.Unix(0, dto.Due),
+		Event:    event,
+	}, nil
+}
+
+func deadlineKey(sagaID string, name string) string {
+	return fmt.Sprintf("%s#%s", sagaID, name)
+}
+
+// itemKey reads the item's string key, for logging an item which can't be loaded
+func itemKey(item map[string]*dynamodb.AttributeValue, name string) string {
+	if v, ok := item[name]; ok && v != nil {
+		return aws.StringValue(v.S)
+	}
+	return ""
+}
+
+var _ saga.DeadlineStore = (*DeadlineStore)(nil)
@@ -1,9 +1,12 @@
 package dynamodb
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
+	"strconv"
+	"time"
 
 	"forge.lmig.com/n1505471/pizza-shop/eventsource/saga"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
@@ -17,29 +20,55 @@ type SagaStore struct {
 	svc               *dynamodb.DynamoDB
 	associationsTable *string
 	sagaTable         *string
+	archiveTable      *string
+	outboxTable       *string
 }
 
+// endedAssociationTTL is how long the association of an ended saga is kept, so late events for it
+// are ignored rather than waiting on an association which won't be saved
+const endedAssociationTTL = 7 * 24 * time.Hour
+
 type sagaAssociation struct {
 	CompositeKey string `dynamodbav:"compositeKey"`
 	SagaId       string `dynamodbav:"sagaId"`
+	Ended        bool   `dynamodbav:"ended,omitempty"`
+	ExpiresAt    int64  `dynamodbav:"expiresAt,omitempty"`
 }
 
 type sagaDto struct {
-	ID      string      `dynamodbav:"sagaId"`
-	Version int         `dynamodbav:"version"`
-	Data    interface{} `dynamodbav:"data"`
+	ID              string            `dynamodbav:"sagaId"`
+	Version         int               `dynamodbav:"version"`
+	Data            interface{}       `dynamodbav:"data"`
+	Revision        int               `dynamodbav:"revision"`
+	Associations    []*associationDto `dynamodbav:"associations"`
+	Ended           bool              `dynamodbav:"ended"`
+	ProcessedEvents []string          `dynamodbav:"processedEvents,omitempty"`
+}
+
+type associationDto struct {
+	ID              string `dynamodbav:"id"`
+	AssociationType string `dynamodbav:"associationType"`
 }
 
-func New(svc *dynamodb.DynamoDB, associationsTable string, sagaTable string) *SagaStore {
+// archivedSagaDto is an ended saga in the archive table
+type archivedSagaDto struct {
+	*sagaDto
+	Type    string    `dynamodbav:"sagaType"`
+	EndedAt time.Time `dynamodbav:"endedAt"`
+}
+
+func New(svc *dynamodb.DynamoDB, associationsTable string, sagaTable string, archiveTable string, outboxTable string) *SagaStore {
 	return &SagaStore{
 		svc:               svc,
 		associationsTable: aws.String(associationsTable),
 		sagaTable:         aws.String(sagaTable),
+		archiveTable:      aws.String(archiveTable),
+		outboxTable:       aws.String(outboxTable),
 	}
 }
 
-func (s *SagaStore) Load(association *saga.SagaAssociation, sagaType string) (*saga.Wrapper, error) {
-	sagaId, err := s.retrieveSagaID(association, sagaType)
+func (s *SagaStore) Load(ctx context.Context, association *saga.SagaAssociation, sagaType string) (*saga.Wrapper, error) {
+	sagaId, err := s.retrieveSagaID(ctx, association, sagaType)
 	if err != nil {
 		return nil, err
 	}
@@ -53,18 +82,17 @@ func (s *SagaStore) Load(association *saga.SagaAssociation, sagaType string) (*s
 		TableName: s.sagaTable,
 	}
 
-	result, err := s.svc.GetItem(input)
+	result, err := s.svc.GetItemWithContext(ctx, input)
 	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case dynamodb.ErrCodeResourceNotFoundException:
-				return nil, &saga.SagaNotFoundError{
-					SagaID: sagaId,
-				}
-			}
-		}
 		return nil, err
 	}
+	// GetItem returns an empty item, rather than an error, when there's no saga.  The saga may
+	// have been archived by another process since the association was read.
+	if len(result.Item) == 0 {
+		return nil, &saga.SagaNotFoundError{
+			SagaID: sagaId,
+		}
+	}
 
 	log.Printf("Loaded from DynamoDB: %+v", result)
 
@@ -79,17 +107,29 @@ func (s *SagaStore) Load(association *saga.SagaAssociation, sagaType string) (*s
 		return nil, err
 	}
 
+	associations := make([]*saga.SagaAssociation, len(out.Associations))
+	for i, a := range out.Associations {
+		associations[i] = &saga.SagaAssociation{
+			ID:              a.ID,
+			AssociationType: a.AssociationType,
+		}
+	}
+
 	return &saga.Wrapper{
-		ID:      out.ID,
-		Version: out.Version,
-		Type:    sagaType,
-		Data:    encoded,
+		ID:              out.ID,
+		Version:         out.Version,
+		Type:            sagaType,
+		Data:            encoded,
+		Revision:        out.Revision,
+		Associations:    associations,
+		Ended:           out.Ended,
+		ProcessedEvents: out.ProcessedEvents,
 	}, nil
 }
 
-func (s *SagaStore) AddAssociationID(association *saga.SagaAssociation, wrapper *saga.Wrapper) error {
+func (s *SagaStore) AddAssociationID(ctx context.Context, association *saga.SagaAssociation, wrapper *saga.Wrapper) error {
 
-	compositeKey := fmt.Sprintf("%s#%s#%s", association.ID, association.AssociationType, wrapper.Type)
+	compositeKey := associationKey(association, wrapper.Type)
 	av, err := dynamodbattribute.MarshalMap(&sagaAssociation{
 		CompositeKey: compositeKey,
 		SagaId:       wrapper.ID,
@@ -97,7 +137,7 @@ func (s *SagaStore) AddAssociationID(association *saga.SagaAssociation, wrapper
 	if err != nil {
 		return err
 	}
-	_, err = s.svc.PutItem(&dynamodb.PutItemInput{
+	_, err = s.svc.PutItemWithContext(ctx, &dynamodb.PutItemInput{
 		TableName: s.associationsTable,
 		Item:      av,
 	})
@@ -108,33 +148,190 @@ func (s *SagaStore) AddAssociationID(association *saga.SagaAssociation, wrapper
 	return nil
 }
 
-func (s *SagaStore) Save(wrapper *saga.Wrapper) error {
-	var out interface{}
-	if err := json.Unmarshal(wrapper.Data, &out); err != nil {
+// RemoveAssociationID marks the association as ended, rather than deleting it, so loading it
+// returns a SagaEndedError until the table's TTL expires it
+func (s *SagaStore) RemoveAssociationID(ctx context.Context, association *saga.SagaAssociation, wrapper *saga.Wrapper) error {
+	av, err := dynamodbattribute.MarshalMap(&sagaAssociation{
+		CompositeKey: associationKey(association, wrapper.Type),
+		SagaId:       wrapper.ID,
+		Ended:        true,
+		ExpiresAt:    time.Now().Add(endedAssociationTTL).Unix(),
+	})
+	if err != nil {
 		return err
 	}
+	_, err = s.svc.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: s.associationsTable,
+		Item:      av,
+	})
+	return err
+}
 
-	av, err := dynamodbattribute.MarshalMap(&sagaDto{
-		ID:      wrapper.ID,
-		Version: wrapper.Version,
-		Data:    out,
+// Save puts the saga at the next revision, on the condition the stored saga is still at
+// the wrapper's revision.  Sagas saved before revisions were added have no revision attribute,
+// which is treated as revision 0.  Outbox messages are put in the same transaction.
+func (s *SagaStore) Save(ctx context.Context, wrapper *saga.Wrapper) error {
+	dto, err := toSagaDto(wrapper)
+	if err != nil {
+		return err
+	}
+	dto.Revision = wrapper.Revision + 1
+
+	av, err := dynamodbattribute.MarshalMap(dto)
+	if err != nil {
+		return err
+	}
+
+	put := &dynamodb.Put{
+		TableName:           s.sagaTable,
+		Item:                av,
+		ConditionExpression: aws.String("attribute_not_exists(revision)"),
+	}
+	if wrapper.Revision > 0 {
+		put.ConditionExpression = aws.String("revision = :revision")
+		put.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
+			":revision": {N: aws.String(strconv.Itoa(wrapper.Revision))},
+		}
+	}
+
+	if len(wrapper.Outbox) == 0 {
+		err = s.put(ctx, put)
+	} else {
+		err = s.putWithOutbox(ctx, put, wrapper.Outbox)
+	}
+	if err != nil {
+		if isConditionalCheckFailed(err) {
+			return &saga.SagaConcurrencyError{
+				SagaID:   wrapper.ID,
+				Revision: wrapper.Revision,
+			}
+		}
+		return err
+	}
+
+	wrapper.Revision = dto.Revision
+	return nil
+}
+
+func (s *SagaStore) put(ctx context.Context, put *dynamodb.Put) error {
+	_, err := s.svc.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName:                 put.TableName,
+		Item:                      put.Item,
+		ConditionExpression:       put.ConditionExpression,
+		ExpressionAttributeValues: put.ExpressionAttributeValues,
 	})
+	return err
+}
+
+// putWithOutbox puts the saga and its outbox messages together, so the messages are only
+// saved if the saga's condition holds
+func (s *SagaStore) putWithOutbox(ctx context.Context, put *dynamodb.Put, outbox []*saga.OutboxMessage) error {
+	items := []*dynamodb.TransactWriteItem{{Put: put}}
+	for _, m := range outbox {
+		av, err := toOutboxItem(m)
+		if err != nil {
+			return err
+		}
+		items = append(items, &dynamodb.TransactWriteItem{
+			Put: &dynamodb.Put{
+				TableName: s.outboxTable,
+				Item:      av,
+			},
+		})
+	}
+
+	_, err := s.svc.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: items,
+	})
+	return err
+}
+
+// isConditionalCheckFailed reports whether the write failed on its condition.  A cancelled
+// transaction gives a reason per item, the saga being the first.
+func isConditionalCheckFailed(err error) bool {
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case dynamodb.ErrCodeConditionalCheckFailedException:
+			return true
+		case dynamodb.ErrCodeTransactionCanceledException:
+			if terr, ok := err.(*dynamodb.TransactionCanceledException); ok && len(terr.CancellationReasons) > 0 {
+				return aws.StringValue(terr.CancellationReasons[0].Code) == "ConditionalCheckFailed"
+			}
+		}
+	}
+	return false
+}
+
+// Archive copies the ended saga to the archive table and removes it from the saga table together
+func (s *SagaStore) Archive(ctx context.Context, wrapper *saga.Wrapper) error {
+	dto, err := toSagaDto(wrapper)
 	if err != nil {
 		return err
 	}
-	_, err = s.svc.PutItem(&dynamodb.PutItemInput{
-		TableName: s.sagaTable,
-		Item:      av,
+
+	av, err := dynamodbattribute.MarshalMap(&archivedSagaDto{
+		sagaDto: dto,
+		Type:    wrapper.Type,
+		EndedAt: time.Now(),
 	})
 	if err != nil {
 		return err
 	}
 
-	return nil
+	_, err = s.svc.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{
+			{
+				Put: &dynamodb.Put{
+					TableName: s.archiveTable,
+					Item:      av,
+				},
+			},
+			{
+				Delete: &dynamodb.Delete{
+					TableName: s.sagaTable,
+					Key: map[string]*dynamodb.AttributeValue{
+						"sagaId": {
+							S: aws.String(wrapper.ID),
+						},
+					},
+				},
+			},
+		},
+	})
+	return err
 }
 
-func (s *SagaStore) retrieveSagaID(association *saga.SagaAssociation, sagaType string) (string, error) {
-	compositeKey := fmt.Sprintf("%s#%s#%s", association.ID, association.AssociationType, sagaType)
+func toSagaDto(wrapper *saga.Wrapper) (*sagaDto, error) {
+	var out interface{}
+	if err := json.Unmarshal(wrapper.Data, &out); err != nil {
+		return nil, err
+	}
+
+	associations := make([]*associationDto, len(wrapper.Associations))
+	for i, a := range wrapper.Associations {
+		associations[i] = &associationDto{
+			ID:              a.ID,
+			AssociationType: a.AssociationType,
+		}
+	}
+
+	return &sagaDto{
+		ID:              wrapper.ID,
+		Version:         wrapper.Version,
+		Data:            out,
+		Revision:        wrapper.Revision,
+		Associations:    associations,
+		Ended:           wrapper.Ended,
+		ProcessedEvents: wrapper.ProcessedEvents,
+	}, nil
+}
+
+func associationKey(association *saga.SagaAssociation, sagaType string) string {
+	return fmt.Sprintf("%s#%s#%s", association.ID, association.AssociationType, sagaType)
+}
+
+func (s *SagaStore) retrieveSagaID(ctx context.Context, association *saga.SagaAssociation, sagaType string) (string, error) {
+	compositeKey := associationKey(association, sagaType)
 
 	input := &dynamodb.GetItemInput{
 		Key: map[string]*dynamodb.AttributeValue{
@@ -145,26 +342,31 @@ func (s *SagaStore) retrieveSagaID(association *saga.SagaAssociation, sagaType s
 		TableName: s.associationsTable,
 	}
 
-	result, err := s.svc.GetItem(input)
+	result, err := s.svc.GetItemWithContext(ctx, input)
 	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case dynamodb.ErrCodeResourceNotFoundException:
-				return "", &saga.SagaAssociationNotFoundError{
-					AssociationID: association.ID,
-					SagaType:      sagaType,
-				}
-			}
-		}
 		return "", err
 	}
+	// GetItem returns an empty item, rather than an error, when there's no association
+	if len(result.Item) == 0 {
+		return "", &saga.SagaAssociationNotFoundError{
+			AssociationID: association.ID,
+			SagaType:      sagaType,
+		}
+	}
 
 	a := &sagaAssociation{}
 	if err := dynamodbattribute.UnmarshalMap(result.Item, a); err != nil {
 		return "", err
 	}
+	if a.Ended {
+		return "", &saga.SagaEndedError{
+			SagaID:   a.SagaId,
+			SagaType: sagaType,
+		}
+	}
 
 	return a.SagaId, nil
 }
 
 var _ saga.Storer = (*SagaStore)(nil)
+var _ saga.OutboxStore = (*SagaStore)(nil)
@@ -0,0 +1,143 @@
+package dynamodb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"forge.lmig.com/n1505471/pizza-shop/eventsource"
+	"forge.lmig.com/n1505471/pizza-shop/eventsource/saga"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// outboxDto is the DynamoDB representation of an outbox message, keyed by messageId.  Times are
+// stored in Unix nanoseconds so they compare numerically, and the intent as json so it loads
+// through the event registry.  Carried out messages are deleted, so the table stays small
+// enough to scan for pending ones.
+type outboxDto struct {
+	ID           string `dynamodbav:"messageId"`
+	SagaID       string `dynamodbav:"sagaId"`
+	SagaType     string `dynamodbav:"sagaType"`
+	Intent       string `dynamodbav:"intent"`
+	CreatedAt    int64  `dynamodbav:"createdAt"`
+	ClaimedUntil int64  `dynamodbav:"claimedUntil"`
+}
+
+func toOutboxItem(m *saga.OutboxMessage) (map[string]*dynamodb.AttributeValue, error) {
+	intent, err := json.Marshal(m.Intent)
+	if err != nil {
+		return nil, err
+	}
+
+	return dynamodbattribute.MarshalMap(&outboxDto{
+		ID:        m.ID,
+		SagaID:    m.SagaID,
+		SagaType:  m.SagaType,
+		Intent:    string(intent),
+		CreatedAt: m.CreatedAt.UnixNano(),
+	})
+}
+
+func (s *SagaStore) Pending(ctx context.Context, now time.Time) ([]*saga.OutboxMessage, error) {
+	claimedUntil, err := dynamodbattribute.Marshal(now.UnixNano())
+	if err != nil {
+		return nil, err
+	}
+
+	var messages []*saga.OutboxMessage
+	err = s.svc.ScanPagesWithContext(ctx, &dynamodb.ScanInput{
+		TableName:        s.outboxTable,
+		FilterExpression: aws.String("claimedUntil <= :claimedUntil"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":claimedUntil": claimedUntil,
+		},
+		ConsistentRead: aws.Bool(true),
+	}, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			m, err := toOutboxMessage(item)
+			if err != nil {
+				log.Printf("Skipping outbox message %s, which can't be loaded, details: %s", itemKey(item, "messageId"), err)
+				continue
+			}
+			messages = append(messages, m)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+// Claim sets the message's claimedUntil, on the condition it's still in the table and no other
+// dispatcher's claim is current
+func (s *SagaStore) Claim(ctx context.Context, message *saga.OutboxMessage, now time.Time, until time.Time) (bool, error) {
+	claimedUntil, err := dynamodbattribute.Marshal(until.UnixNano())
+	if err != nil {
+		return false, err
+	}
+	current, err := dynamodbattribute.Marshal(now.UnixNano())
+	if err != nil {
+		return false, err
+	}
+
+	_, err = s.svc.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName: s.outboxTable,
+		Key: map[string]*dynamodb.AttributeValue{
+			"messageId": {S: aws.String(message.ID)},
+		},
+		UpdateExpression:    aws.String("SET claimedUntil = :claimedUntil"),
+		ConditionExpression: aws.String("attribute_exists(messageId) AND claimedUntil <= :now"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":claimedUntil": claimedUntil,
+			":now":          current,
+		},
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case dynamodb.ErrCodeConditionalCheckFailedException:
+				return false, nil
+			}
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (s *SagaStore) Remove(ctx context.Context, message *saga.OutboxMessage) error {
+	_, err := s.svc.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		TableName: s.outboxTable,
+		Key: map[string]*dynamodb.AttributeValue{
+			"messageId": {S: aws.String(message.ID)},
+		},
+	})
+	return err
+}
+
+func toOutboxMessage(item map[string]*dynamodb.AttributeValue) (*saga.OutboxMessage, error) {
+	dto := &outboxDto{}
+	if err := dynamodbattribute.UnmarshalMap(item, dto); err != nil {
+		return nil, err
+	}
+
+	intent := eventsource.Event{}
+	if err := intent.Load([]byte(dto.Intent)); err != nil {
+		return nil, fmt.Errorf("Error loading intent for outbox message %s, details: %s", dto.ID, err)
+	}
+
+	return &saga.OutboxMessage{
+		ID:        dto.ID,
+		SagaID:    dto.SagaID,
+		SagaType:  dto.SagaType,
+		Intent:    intent,
+		CreatedAt: time.Unix(0, dto.CreatedAt),
+	}, nil
+}
@@ -0,0 +1,85 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"forge.lmig.com/n1505471/pizza-shop/eventsource/saga"
+)
+
+// DeadlineStore keeps the pending saga deadlines in memory, for tests and running the scheduler locally
+type DeadlineStore struct {
+	mu        sync.RWMutex
+	deadlines map[string]saga.Deadline
+}
+
+func NewDeadlineStore() *DeadlineStore {
+	return &DeadlineStore{
+		deadlines: make(map[string]saga.Deadline),
+	}
+}
+
+func (s *DeadlineStore) Schedule(_ context.Context, deadline *saga.Deadline) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deadlines[deadlineKey(deadline.SagaID, deadline.Name)] = *deadline
+	return nil
+}
+
+func (s *DeadlineStore) Cancel(_ context.Context, sagaID string, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.deadlines, deadlineKey(sagaID, name))
+	return nil
+}
+
+func (s *DeadlineStore) CancelAll(_ context.Context, sagaID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, d := range s.deadlines {
+		if d.SagaID == sagaID {
+			delete(s.deadlines, key)
+		}
+	}
+	return nil
+}
+
+func (s *DeadlineStore) Due(_ context.Context, now time.Time) ([]*saga.Deadline, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	due := []*saga.Deadline{}
+	for _, d := range s.deadlines {
+		if !d.Due.After(now) {
+			d := d
+			due = append(due, &d)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].Due.Before(due[j].Due)
+	})
+
+	return due, nil
+}
+
+func (s *DeadlineStore) Remove(_ context.Context, deadline *saga.Deadline) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := deadlineKey(deadline.SagaID, deadline.Name)
+	if existing, ok := s.deadlines[key]; ok && existing.Due.Equal(deadline.Due) {
+		delete(s.deadlines, key)
+	}
+	return nil
+}
+
+func deadlineKey(sagaID string, name string) string {
+	return sagaID + "#" + name
+}
+
+var _ saga.DeadlineStore = (*DeadlineStore)(nil)
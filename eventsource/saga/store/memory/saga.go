@@ -0,0 +1,188 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"forge.lmig.com/n1505471/pizza-shop/eventsource/saga"
+)
+
+// SagaStore keeps sagas, their associations and their outbox in memory, for tests and running
+// sagas locally.  Associations are keyed by id#associationType#sagaType, as in the DynamoDB SagaStore.
+type SagaStore struct {
+	mu           sync.RWMutex
+	sagas        map[string]saga.Wrapper
+	associations map[string]string
+	// ended holds the removed associations of ended sagas
+	ended    map[string]string
+	archived map[string]saga.Wrapper
+	outbox   map[string]outboxEntry
+}
+
+// outboxEntry is a message in the outbox, with the time it's claimed until by a dispatcher
+type outboxEntry struct {
+	message      saga.OutboxMessage
+	claimedUntil time.Time
+}
+
+func NewSagaStore() *SagaStore {
+	return &SagaStore{
+		sagas:        make(map[string]saga.Wrapper),
+		associations: make(map[string]string),
+		ended:        make(map[string]string),
+		archived:     make(map[string]saga.Wrapper),
+		outbox:       make(map[string]outboxEntry),
+	}
+}
+
+func (s *SagaStore) Load(_ context.Context, association *saga.SagaAssociation, sagaType string) (*saga.Wrapper, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	key := associationKey(association, sagaType)
+	sagaID, ok := s.associations[key]
+	if !ok {
+		if sagaID, ended := s.ended[key]; ended {
+			return nil, &saga.SagaEndedError{
+				SagaID:   sagaID,
+				SagaType: sagaType,
+			}
+		}
+		return nil, &saga.SagaAssociationNotFoundError{
+			AssociationID: association.ID,
+			SagaType:      sagaType,
+		}
+	}
+
+	w, ok := s.sagas[sagaID]
+	if !ok {
+		return nil, &saga.SagaNotFoundError{
+			SagaID: sagaID,
+		}
+	}
+
+	return copyWrapper(w), nil
+}
+
+func (s *SagaStore) AddAssociationID(_ context.Context, association *saga.SagaAssociation, wrapper *saga.Wrapper) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := associationKey(association, wrapper.Type)
+	s.associations[key] = wrapper.ID
+	delete(s.ended, key)
+	return nil
+}
+
+// RemoveAssociationID keeps the association as ended, so loading it returns a SagaEndedError
+func (s *SagaStore) RemoveAssociationID(_ context.Context, association *saga.SagaAssociation, wrapper *saga.Wrapper) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := associationKey(association, wrapper.Type)
+	delete(s.associations, key)
+	s.ended[key] = wrapper.ID
+	return nil
+}
+
+func (s *SagaStore) Save(_ context.Context, wrapper *saga.Wrapper) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existing := s.sagas[wrapper.ID]; existing.Revision != wrapper.Revision {
+		return &saga.SagaConcurrencyError{
+			SagaID:   wrapper.ID,
+			Revision: wrapper.Revision,
+		}
+	}
+
+	wrapper.Revision++
+	s.sagas[wrapper.ID] = *copyWrapper(*wrapper)
+	for _, m := range wrapper.Outbox {
+		s.outbox[m.ID] = outboxEntry{message: *m}
+	}
+	return nil
+}
+
+func (s *SagaStore) Archive(_ context.Context, wrapper *saga.Wrapper) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.archived[wrapper.ID] = *copyWrapper(*wrapper)
+	delete(s.sagas, wrapper.ID)
+	return nil
+}
+
+// Archived returns the final state of an ended saga
+func (s *SagaStore) Archived(sagaID string) (*saga.Wrapper, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	w, ok := s.archived[sagaID]
+	if !ok {
+		return nil, &saga.SagaNotFoundError{
+			SagaID: sagaID,
+		}
+	}
+	return copyWrapper(w), nil
+}
+
+func (s *SagaStore) Pending(_ context.Context, now time.Time) ([]*saga.OutboxMessage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	pending := []*saga.OutboxMessage{}
+	for _, e := range s.outbox {
+		if !e.claimedUntil.After(now) {
+			m := e.message
+			pending = append(pending, &m)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].CreatedAt.Before(pending[j].CreatedAt)
+	})
+
+	return pending, nil
+}
+
+func (s *SagaStore) Claim(_ context.Context, message *saga.OutboxMessage, now time.Time, until time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.outbox[message.ID]
+	if !ok || e.claimedUntil.After(now) {
+		return false, nil
+	}
+
+	e.claimedUntil = until
+	s.outbox[message.ID] = e
+	return true, nil
+}
+
+func (s *SagaStore) Remove(_ context.Context, message *saga.OutboxMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.outbox, message.ID)
+	return nil
+}
+
+// copyWrapper copies the slices of the wrapper too, so callers can't change the stored saga.
+// The outbox is stored separately, so it isn't copied.
+func copyWrapper(w saga.Wrapper) *saga.Wrapper {
+	w.Outbox = nil
+	w.Data = append([]byte(nil), w.Data...)
+	w.Associations = append([]*saga.SagaAssociation(nil), w.Associations...)
+	w.ProcessedEvents = append([]string(nil), w.ProcessedEvents...)
+	return &w
+}
+
+func associationKey(association *saga.SagaAssociation, sagaType string) string {
+	return fmt.Sprintf("%s#%s#%s", association.ID, association.AssociationType, sagaType)
+}
+
+var _ saga.Storer = (*SagaStore)(nil)
+var _ saga.OutboxStore = (*SagaStore)(nil)
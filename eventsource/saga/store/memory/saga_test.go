@@ -0,0 +1,171 @@
+package memory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+
+	"forge.lmig.com/n1505471/pizza-shop/eventsource/saga"
+)
+
+var orderAssociation = &saga.SagaAssociation{ID: "orderId", AssociationType: "OrderID"}
+
+func TestSagaStore_Load(t *testing.T) {
+	store := NewSagaStore()
+	w := &saga.Wrapper{ID: "sagaId", Type: "OrderFulfillmentSaga", Version: 1, Data: []byte(`{"orderId":"orderId"}`)}
+	if err := store.Save(context.Background(), w); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddAssociationID(context.Background(), orderAssociation, w); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddAssociationID(context.Background(), &saga.SagaAssociation{ID: "missing", AssociationType: "OrderID"}, &saga.Wrapper{ID: "missing", Type: "OrderFulfillmentSaga"}); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		Label       string
+		Association *saga.SagaAssociation
+		SagaType    string
+		Expected    *saga.Wrapper
+		ExpectedErr error
+	}{
+		{
+			Label:       "loads the saga for an association",
+			Association: orderAssociation,
+			SagaType:    "OrderFulfillmentSaga",
+			Expected:    &saga.Wrapper{ID: "sagaId", Type: "OrderFulfillmentSaga", Version: 1, Revision: 1, Data: []byte(`{"orderId":"orderId"}`)},
+		},
+		{
+			Label:       "keys associations by saga type",
+			Association: orderAssociation,
+			SagaType:    "OtherSaga",
+			ExpectedErr: &saga.SagaAssociationNotFoundError{AssociationID: "orderId", SagaType: "OtherSaga"},
+		},
+		{
+			Label:       "keys associations by association type",
+			Association: &saga.SagaAssociation{ID: "orderId", AssociationType: "ApprovalID"},
+			SagaType:    "OrderFulfillmentSaga",
+			ExpectedErr: &saga.SagaAssociationNotFoundError{AssociationID: "orderId", SagaType: "OrderFulfillmentSaga"},
+		},
+		{
+			Label:       "returns a SagaNotFoundError for associations without a saga",
+			Association: &saga.SagaAssociation{ID: "missing", AssociationType: "OrderID"},
+			SagaType:    "OrderFulfillmentSaga",
+			ExpectedErr: &saga.SagaNotFoundError{SagaID: "missing"},
+		},
+	}
+
+	for i, c := range cases {
+		got, err := store.Load(context.Background(), c.Association, c.SagaType)
+		if diff := deep.Equal(err, c.ExpectedErr); diff != nil {
+			t.Errorf("Cases[%d] FAILED: %s.  Error: %s", i, c.Label, diff)
+			continue
+		}
+		if diff := deep.Equal(got, c.Expected); diff != nil {
+			t.Errorf("Cases[%d] FAILED: %s.  Error: %s", i, c.Label, diff)
+		}
+	}
+}
+
+func TestSagaStore_Save(t *testing.T) {
+	store := NewSagaStore()
+	w := &saga.Wrapper{ID: "sagaId", Type: "OrderFulfillmentSaga", Data: []byte(`{}`)}
+	if err := store.Save(context.Background(), w); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddAssociationID(context.Background(), orderAssociation, w); err != nil {
+		t.Fatal(err)
+	}
+
+	stale, err := store.Load(context.Background(), orderAssociation, "OrderFulfillmentSaga")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Save(context.Background(), w); err != nil {
+		t.Errorf("Expected to save the saga at its current revision, got: %s", err)
+	}
+
+	expected := &saga.SagaConcurrencyError{SagaID: "sagaId", Revision: 1}
+	if diff := deep.Equal(store.Save(context.Background(), stale), expected); diff != nil {
+		t.Errorf("Expected saving a stale saga to fail: %s", diff)
+	}
+}
+
+func TestSagaStore_Archive(t *testing.T) {
+	store := NewSagaStore()
+	w := &saga.Wrapper{ID: "sagaId", Type: "OrderFulfillmentSaga", Data: []byte(`{}`), Ended: true}
+	if err := store.Save(context.Background(), w); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddAssociationID(context.Background(), orderAssociation, w); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.RemoveAssociationID(context.Background(), orderAssociation, w); err != nil {
+		t.Fatal(err)
+	}
+	_, err := store.Load(context.Background(), orderAssociation, "OrderFulfillmentSaga")
+	if diff := deep.Equal(err, &saga.SagaEndedError{SagaID: "sagaId", SagaType: "OrderFulfillmentSaga"}); diff != nil {
+		t.Errorf("Expected removed associations to load as ended: %s", diff)
+	}
+
+	if _, err := store.Archived("sagaId"); err == nil {
+		t.Error("Expected the saga not to be archived before it's ended")
+	}
+	if err := store.Archive(context.Background(), w); err != nil {
+		t.Fatal(err)
+	}
+	archived, err := store.Archived("sagaId")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(archived, w); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestSagaStore_Outbox(t *testing.T) {
+	store := NewSagaStore()
+	now := time.Now()
+	message := &saga.OutboxMessage{ID: "messageId", SagaID: "sagaId", SagaType: "OrderFulfillmentSaga", CreatedAt: now}
+	w := &saga.Wrapper{ID: "sagaId", Type: "OrderFulfillmentSaga", Data: []byte(`{}`), Outbox: []*saga.OutboxMessage{message}}
+	if err := store.Save(context.Background(), w); err != nil {
+		t.Fatal(err)
+	}
+
+	stale := &saga.Wrapper{ID: "sagaId", Type: "OrderFulfillmentSaga", Data: []byte(`{}`), Outbox: []*saga.OutboxMessage{{ID: "staleId"}}}
+	if err := store.Save(context.Background(), stale); err == nil {
+		t.Error("Expected saving a stale saga to fail")
+	}
+
+	pending, err := store.Pending(context.Background(), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(pending, []*saga.OutboxMessage{message}); diff != nil {
+		t.Errorf("Expected only the message saved with the saga to be pending: %s", diff)
+	}
+
+	if claimed, _ := store.Claim(context.Background(), message, now, now.Add(time.Minute)); !claimed {
+		t.Error("Expected to claim a pending message")
+	}
+	if claimed, _ := store.Claim(context.Background(), message, now, now.Add(time.Minute)); claimed {
+		t.Error("Expected not to claim a message claimed by another dispatcher")
+	}
+	if pending, _ := store.Pending(context.Background(), now); len(pending) != 0 {
+		t.Errorf("Expected claimed messages not to be pending, got %d", len(pending))
+	}
+	if pending, _ := store.Pending(context.Background(), now.Add(time.Minute)); len(pending) != 1 {
+		t.Errorf("Expected messages to be pending again once their claim expires, got %d", len(pending))
+	}
+
+	if err := store.Remove(context.Background(), message); err != nil {
+		t.Fatal(err)
+	}
+	if claimed, _ := store.Claim(context.Background(), message, now.Add(time.Hour), now.Add(time.Hour)); claimed {
+		t.Error("Expected not to claim a removed message")
+	}
+}
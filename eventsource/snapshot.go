@@ -0,0 +1,107 @@
+package eventsource
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// Snapshot stores the serialized state of an aggregate as of a given sequence
+type Snapshot struct {
+	AggregateID       string          `json:"aggregateId"`
+	AggregateType     string          `json:"aggregateType"`
+	AggregateSequence int             `json:"aggregateSequence"`
+	Version           int             `json:"snapshotVersion"`
+	Timestamp         time.Time       `json:"snapshotTimestamp"`
+	Data              json.RawMessage `json:"snapshotData"`
+}
+
+// Snapshotter is implemented by aggregates which can serialize and restore their state
+type Snapshotter interface {
+	// SnapshotVersion is the current version of the snapshot format, snapshots taken
+	// with any other version are ignored and the aggregate is replayed from its events
+	SnapshotVersion() int
+	TakeSnapshot() (json.RawMessage, error)
+	RestoreSnapshot(data json.RawMessage, version int) error
+}
+
+// SnapshotStore persists the latest snapshot for each aggregate
+type SnapshotStore interface {
+	// LatestSnapshot returns nil when no snapshot exists for the aggregate
+	LatestSnapshot(ctx context.Context, aggregateID string) (*Snapshot, error)
+	SaveSnapshot(ctx context.Context, snapshot Snapshot) error
+}
+
+// SnapshotPolicy decides whether to take a snapshot after a command moved the aggregate
+// from the previous sequence to the current one
+type SnapshotPolicy func(previous int, current int) bool
+
+// SnapshotEvery takes a snapshot each time the aggregate passes a multiple of n events
+func SnapshotEvery(n int) SnapshotPolicy {
+	return func(previous int, current int) bool {
+		return n > 0 && previous/n != current/n
+	}
+}
+
+// WithSnapshots enables snapshotting for aggregates implementing Snapshotter
+func WithSnapshots(store SnapshotStore, policy SnapshotPolicy) Option {
+	return func(es *EventSource) {
+		es.snapshots = store
+		es.snapshotPolicy = policy
+	}
+}
+
+// restoreSnapshot loads the latest usable snapshot into the aggregate, returning
+// the sequence that event replay should continue after
+func (es *EventSource) restoreSnapshot(ctx context.Context, a Aggregate) (int, error) {
+	s, ok := a.(Snapshotter)
+	if !ok || es.snapshots == nil {
+		return 0, nil
+	}
+
+	snapshot, err := es.snapshots.LatestSnapshot(ctx, a.AggregateID())
+	if err != nil {
+		return 0, err
+	}
+	if snapshot == nil || snapshot.Version != s.SnapshotVersion() {
+		return 0, nil
+	}
+
+	if err := s.RestoreSnapshot(snapshot.Data, snapshot.Version); err != nil {
+		return 0, err
+	}
+	a.setSequence(snapshot.AggregateSequence)
+
+	return snapshot.AggregateSequence, nil
+}
+
+// takeSnapshot saves a snapshot if the policy calls for one. Snapshots are only an
+// optimization, so failures are logged rather than failing the command.
+func (es *EventSource) takeSnapshot(ctx context.Context, a Aggregate, previous int) {
+	s, ok := a.(Snapshotter)
+	if !ok || es.snapshots == nil || es.snapshotPolicy == nil {
+		return
+	}
+	if !es.snapshotPolicy(previous, a.getSequence()) {
+		return
+	}
+
+	data, err := s.TakeSnapshot()
+	if err != nil {
+		log.Printf("Error taking snapshot of aggregate %s: %s", a.AggregateID(), err)
+		return
+	}
+
+	err = es.snapshots.SaveSnapshot(ctx, Snapshot{
+		AggregateID:       a.AggregateID(),
+		AggregateType:     a.Type(),
+		AggregateSequence: a.getSequence(),
+		Version:           s.SnapshotVersion(),
+		Timestamp:         time.Now(),
+		Data:              data,
+	})
+	if err != nil {
+		log.Printf("Error saving snapshot of aggregate %s: %s", a.AggregateID(), err)
+	}
+}
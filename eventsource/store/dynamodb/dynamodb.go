@@ -1,36 +1,120 @@
 package dynamodb
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"strconv"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws/awserr"
-
 	"forge.lmig.com/n1505471/pizza-shop/eventsource"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 )
 
+// maxTransactionItems is the most items DynamoDB allows in a single TransactWriteItems call
+const maxTransactionItems = 25
+
+// asOfPageSize is how many events EventsForAggregateAsOf reads at a time when bounded by a timestamp
+const asOfPageSize = 25
+
 type EventStore struct {
 	svc       *dynamodb.DynamoDB
 	tableName *string
+	// streamTableName is the table the Sequencer appends the global stream to
+	streamTableName *string
+}
+
+// Option configures optional EventStore behaviour
+type Option func(e *EventStore)
+
+// WithStreamTable reads the global stream from the table a Sequencer appends it to.  Without it
+// the stream can't be read.
+func WithStreamTable(t string) Option {
+	return func(e *EventStore) {
+		e.streamTableName = aws.String(t)
+	}
 }
 
-func New(svc *dynamodb.DynamoDB, t string) *EventStore {
-	return &EventStore{
+func New(svc *dynamodb.DynamoDB, t string, opts ...Option) *EventStore {
+	e := &EventStore{
 		svc:       svc,
 		tableName: aws.String(t),
 	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
+}
+
+func (e *EventStore) SaveEvent(ctx context.Context, event eventsource.Event) error {
+	return e.SaveEvents(ctx, []eventsource.Event{event})
 }
 
-func (e *EventStore) SaveEvent(event eventsource.Event) error {
+// SaveEvents commits the events in a single transaction, so either all of them are saved or none
+// are.  A single event is put on its own, which costs half as much as a transaction.  Positions
+// in the global stream are assigned afterwards by the Sequencer, so saves for different
+// aggregates don't contend with each other.
+func (e *EventStore) SaveEvents(ctx context.Context, events []eventsource.Event) error {
+	switch len(events) {
+	case 0:
+		return nil
+	case 1:
+		return e.putEvent(ctx, events[0])
+	}
+	if len(events) > maxTransactionItems {
+		return fmt.Errorf("Cannot save %d events in one transaction, the maximum is %d", len(events), maxTransactionItems)
+	}
+
+	items := make([]*dynamodb.TransactWriteItem, len(events))
+	for i, event := range events {
+		av, err := dynamodbattribute.MarshalMap(event)
+		if err != nil {
+			return err
+		}
+		items[i] = &dynamodb.TransactWriteItem{
+			Put: &dynamodb.Put{
+				TableName:           e.tableName,
+				Item:                av,
+				ConditionExpression: aws.String("attribute_not_exists(aggregateSequence)"),
+			},
+		}
+	}
+
+	_, err := e.svc.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: items,
+	})
+	if err != nil {
+		if aerr, ok := err.(*dynamodb.TransactionCanceledException); ok {
+			// Reasons are returned in the same order as the items in the transaction
+			for i, reason := range aerr.CancellationReasons {
+				if aws.StringValue(reason.Code) == "ConditionalCheckFailed" && i < len(events) {
+					return &eventsource.AggregateLockError{
+						ID:       events[i].AggregateID,
+						Sequence: events[i].AggregateSequence,
+					}
+				}
+			}
+		}
+	}
+
+	return err
+}
+
+// putEvent puts the event, on the condition its sequence isn't taken for the aggregate
+func (e *EventStore) putEvent(ctx context.Context, event eventsource.Event) error {
 	av, err := dynamodbattribute.MarshalMap(event)
 	if err != nil {
 		return err
 	}
 
-	err = e.save(av)
+	_, err = e.svc.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName:           e.tableName,
+		Item:                av,
+		ConditionExpression: aws.String("attribute_not_exists(aggregateSequence)"),
+	})
 	if err != nil {
 		if aerr, ok := err.(awserr.Error); ok {
 			switch aerr.Code() {
@@ -46,39 +130,153 @@ func (e *EventStore) SaveEvent(event eventsource.Event) error {
 	return err
 }
 
-func (e *EventStore) EventsForAggregate(aggregateID string) ([]eventsource.Event, error) {
+func (e *EventStore) EventsForAggregate(ctx context.Context, aggregateID string) ([]eventsource.Event, error) {
+	return e.EventsForAggregateAfter(ctx, aggregateID, 0)
+}
+
+func (e *EventStore) EventsForAggregateAfter(ctx context.Context, aggregateID string, sequence int) ([]eventsource.Event, error) {
 	var events []eventsource.Event
-	av, err := dynamodbattribute.Marshal(aggregateID)
+	id, err := dynamodbattribute.Marshal(aggregateID)
+	if err != nil {
+		return events, err
+	}
+	seq, err := dynamodbattribute.Marshal(sequence)
 	if err != nil {
 		return events, err
 	}
-	results, err := e.query("aggregateId = :aggregateId", map[string]*dynamodb.AttributeValue{
-		":aggregateId": av,
+	results, err := e.query(ctx, "aggregateId = :aggregateId AND aggregateSequence > :sequence", map[string]*dynamodb.AttributeValue{
+		":aggregateId": id,
+		":sequence":    seq,
 	})
+	if err != nil {
+		return events, err
+	}
 	return unmarshalEventsFromDB(results)
 }
 
-func (e *EventStore) save(item map[string]*dynamodb.AttributeValue) error {
-	_, err := e.svc.PutItem(&dynamodb.PutItemInput{
-		TableName:           e.tableName,
-		Item:                item,
-		ConditionExpression: aws.String("attribute_not_exists(aggregateSequence)"),
-	})
+// EventsForAggregateAsOf bounds the query by the sequence, then reads a page at a time until it
+// passes the timestamp.  Timestamps are stored as RFC 3339 strings, which don't sort in time
+// order, so the query can't bound them itself.
+func (e *EventStore) EventsForAggregateAsOf(ctx context.Context, aggregateID string, asOf eventsource.AsOf) ([]eventsource.Event, error) {
+	var events []eventsource.Event
+	id, err := dynamodbattribute.Marshal(aggregateID)
+	if err != nil {
+		return events, err
+	}
+	input := &dynamodb.QueryInput{
+		TableName:              e.tableName,
+		KeyConditionExpression: aws.String("aggregateId = :aggregateId"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":aggregateId": id,
+		},
+	}
+	if asOf.Sequence > 0 {
+		seq, err := dynamodbattribute.Marshal(asOf.Sequence)
+		if err != nil {
+			return events, err
+		}
+		input.KeyConditionExpression = aws.String("aggregateId = :aggregateId AND aggregateSequence <= :sequence")
+		input.ExpressionAttributeValues[":sequence"] = seq
+	}
+	if !asOf.Timestamp.IsZero() {
+		input.Limit = aws.Int64(asOfPageSize)
+	}
 
-	return err
+	for {
+		result, err := e.svc.QueryWithContext(ctx, input)
+		if err != nil {
+			return events, err
+		}
+		page, err := unmarshalEventsFromDB(result.Items)
+		if err != nil {
+			return events, err
+		}
+		for _, event := range page {
+			if !asOf.Includes(event) {
+				return events, nil
+			}
+			events = append(events, event)
+		}
+		if result.LastEvaluatedKey == nil {
+			return events, nil
+		}
+		input.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+}
+
+func (e *EventStore) ReadAll(ctx context.Context, fromPosition int64, limit int) ([]eventsource.Event, error) {
+	return e.readStream(ctx, fromPosition, limit, "", nil)
 }
 
-func (e *EventStore) query(query string, attributeValues map[string]*dynamodb.AttributeValue) ([]map[string]*dynamodb.AttributeValue, error) {
-	result, err := e.svc.Query(&dynamodb.QueryInput{
+func (e *EventStore) ReadByType(ctx context.Context, eventType string, fromPosition int64, limit int) ([]eventsource.Event, error) {
+	return e.readStream(ctx, fromPosition, limit, "eventType = :match", &dynamodb.AttributeValue{S: aws.String(eventType)})
+}
+
+func (e *EventStore) ReadByAggregateType(ctx context.Context, aggregateType string, fromPosition int64, limit int) ([]eventsource.Event, error) {
+	return e.readStream(ctx, fromPosition, limit, "aggregateType = :match", &dynamodb.AttributeValue{S: aws.String(aggregateType)})
+}
+
+// readStream queries the stream table in position order, with consistent reads so no appended
+// event is missed.  DynamoDB filters after applying the limit, so filtered reads page on until
+// they have enough events or reach the end of the stream.
+func (e *EventStore) readStream(ctx context.Context, fromPosition int64, limit int, filter string, match *dynamodb.AttributeValue) ([]eventsource.Event, error) {
+	if e.streamTableName == nil {
+		return nil, fmt.Errorf("The global stream can't be read without a stream table, see WithStreamTable")
+	}
+
+	input := &dynamodb.QueryInput{
+		TableName:              e.streamTableName,
+		KeyConditionExpression: aws.String("streamId = :streamId AND #position > :position"),
+		ExpressionAttributeNames: map[string]*string{
+			"#position": aws.String("position"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":streamId": {S: aws.String(streamID)},
+			":position": {N: aws.String(strconv.FormatInt(fromPosition, 10))},
+		},
+		ConsistentRead: aws.Bool(true),
+		Limit:          aws.Int64(int64(limit)),
+	}
+	if filter != "" {
+		input.FilterExpression = aws.String(filter)
+		input.ExpressionAttributeValues[":match"] = match
+	}
+
+	var items []map[string]*dynamodb.AttributeValue
+	for {
+		result, err := e.svc.QueryWithContext(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, result.Items...)
+		if len(items) >= limit || result.LastEvaluatedKey == nil {
+			break
+		}
+		input.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+	if len(items) > limit {
+		items = items[:limit]
+	}
+
+	return unmarshalEventsFromDB(items)
+}
+
+// query reads every page of the results, since a query returns at most 1MB at a time
+func (e *EventStore) query(ctx context.Context, query string, attributeValues map[string]*dynamodb.AttributeValue) ([]map[string]*dynamodb.AttributeValue, error) {
+	var items []map[string]*dynamodb.AttributeValue
+	err := e.svc.QueryPagesWithContext(ctx, &dynamodb.QueryInput{
 		KeyConditionExpression:    aws.String(query),
 		ExpressionAttributeValues: attributeValues,
 		TableName:                 e.tableName,
+	}, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		items = append(items, page.Items...)
+		return true
 	})
 	if err != nil {
 		return nil, err
 	}
 
-	return result.Items, nil
+	return items, nil
 }
 
 // Event is the DynamoDB represenation of a domain event
@@ -90,20 +288,18 @@ type Event struct {
 	EventType         string                 `json:"eventType"`
 	EventTypeVersion  int                    `json:"eventVersion"`
 	Timestamp         time.Time              `json:"eventTimestamp"`
+	Position          int64                  `json:"position"`
+	Metadata          eventsource.Metadata   `json:"metadata"`
 	RawData           map[string]interface{} `json:"eventData"`
 }
 
 func (e *Event) toDomainEvent() (eventsource.Event, error) {
-	eventData, err := eventsource.GetEventOfType(e.EventType)
-	if err != nil {
-		return eventsource.Event{}, err
-	}
-
 	r, err := json.Marshal(e.RawData)
 	if err != nil {
 		return eventsource.Event{}, err
 	}
-	if err := eventData.Load(r, e.EventTypeVersion); err != nil {
+	eventData, err := eventsource.LoadEventData(e.EventType, r, e.EventTypeVersion)
+	if err != nil {
 		return eventsource.Event{}, err
 	}
 	event := eventsource.Event{
@@ -114,6 +310,8 @@ func (e *Event) toDomainEvent() (eventsource.Event, error) {
 		EventTypeVersion:  e.EventTypeVersion,
 		EventType:         e.EventType,
 		Timestamp:         e.Timestamp,
+		Position:          e.Position,
+		Metadata:          e.Metadata,
 		Data:              eventData,
 	}
 
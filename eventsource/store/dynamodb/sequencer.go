@@ -0,0 +1,223 @@
+package dynamodb
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"forge.lmig.com/n1505471/pizza-shop/eventsource"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// The stream table holds the global stream under streamID, sorted by position.  The last position
+// assigned is kept on the headID item, and an item under appendedPrefix marks each event appended.
+const (
+	streamID       = "$all"
+	headID         = "$head"
+	appendedPrefix = "$appended#"
+)
+
+// appendBatchSize is how many events are appended per transaction, each needs its stream entry
+// and its marker alongside the head
+const appendBatchSize = (maxTransactionItems - 1) / 2
+
+// appendAttempts is how many times a batch is tried when other sequencers take the next positions first
+const appendAttempts = 10
+
+// Sequencer appends saved events to the global stream, assigning each the next position.  It runs
+// from the events table's DynamoDB stream, after the events are saved, so commands never wait on
+// the stream's head.  Sequencers for different shards of the events table contend for the head
+// instead, and back off when another takes the next positions first.
+type Sequencer struct {
+	svc       *dynamodb.DynamoDB
+	tableName *string
+	backoff   func(attempt int) time.Duration
+}
+
+func NewSequencer(svc *dynamodb.DynamoDB, t string) *Sequencer {
+	return &Sequencer{
+		svc:       svc,
+		tableName: aws.String(t),
+		backoff:   eventsource.ExponentialBackoff(20 * time.Millisecond),
+	}
+}
+
+// Append adds the events table items to the stream in the order given, which must keep each
+// aggregate's events in sequence order, as a DynamoDB stream shard does.  Events already in the
+// stream are skipped, so a redelivered batch isn't appended twice.
+func (s *Sequencer) Append(ctx context.Context, items []map[string]*dynamodb.AttributeValue) error {
+	for start := 0; start < len(items); start += appendBatchSize {
+		end := start + appendBatchSize
+		if end > len(items) {
+			end = len(items)
+		}
+		if err := s.appendBatch(ctx, items[start:end]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Sequencer) appendBatch(ctx context.Context, items []map[string]*dynamodb.AttributeValue) error {
+	for attempt := 1; ; attempt++ {
+		head, err := s.head(ctx)
+		if err != nil {
+			return err
+		}
+
+		var headMoved bool
+		items, headMoved, err = s.appendAfter(ctx, head, items)
+		if err != nil || len(items) == 0 {
+			return err
+		}
+		if attempt >= appendAttempts {
+			return fmt.Errorf("Could not append %d events to the stream after %d attempts", len(items), attempt)
+		}
+		if !headMoved {
+			continue
+		}
+
+		// Jitter the wait, so sequencers which collided don't collide again
+		wait := s.backoff(attempt)
+		wait = wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+		log.Printf("Stream head moved from %d, retrying %d events in %s", head, len(items), wait)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// appendAfter appends the items at the positions following head, in one transaction.  If it's
+// cancelled, the items still to append are returned, without any found to be in the stream
+// already, and headMoved reports whether another sequencer took the positions first.
+func (s *Sequencer) appendAfter(ctx context.Context, head int64, items []map[string]*dynamodb.AttributeValue) ([]map[string]*dynamodb.AttributeValue, bool, error) {
+	next := head + int64(len(items))
+	update := &dynamodb.Update{
+		TableName:        s.tableName,
+		Key:              streamKey(headID, 0),
+		UpdateExpression: aws.String("SET #last = :next"),
+		ExpressionAttributeNames: map[string]*string{
+			"#last": aws.String("last"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":next": {N: aws.String(strconv.FormatInt(next, 10))},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(#last)"),
+	}
+	if head > 0 {
+		update.ConditionExpression = aws.String("#last = :head")
+		update.ExpressionAttributeValues[":head"] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(head, 10))}
+	}
+
+	transaction := []*dynamodb.TransactWriteItem{{Update: update}}
+	for i, item := range items {
+		marker, err := appendedMarker(item)
+		if err != nil {
+			return nil, false, err
+		}
+		entry := make(map[string]*dynamodb.AttributeValue, len(item)+2)
+		for k, v := range item {
+			entry[k] = v
+		}
+		for k, v := range streamKey(streamID, head+int64(i)+1) {
+			entry[k] = v
+		}
+		transaction = append(transaction,
+			&dynamodb.TransactWriteItem{Put: &dynamodb.Put{
+				TableName:           s.tableName,
+				Item:                marker,
+				ConditionExpression: aws.String("attribute_not_exists(streamId)"),
+			}},
+			&dynamodb.TransactWriteItem{Put: &dynamodb.Put{
+				TableName: s.tableName,
+				Item:      entry,
+			}},
+		)
+	}
+
+	_, err := s.svc.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: transaction,
+	})
+	if err == nil {
+		return nil, false, nil
+	}
+	aerr, ok := err.(*dynamodb.TransactionCanceledException)
+	if !ok {
+		return nil, false, err
+	}
+
+	// Reasons are returned in the same order as the items in the transaction, the head first,
+	// then each event's marker and entry
+	var remaining []map[string]*dynamodb.AttributeValue
+	for i, item := range items {
+		reasonIndex := 1 + 2*i
+		if reasonIndex < len(aerr.CancellationReasons) && aws.StringValue(aerr.CancellationReasons[reasonIndex].Code) == "ConditionalCheckFailed" {
+			continue
+		}
+		remaining = append(remaining, item)
+	}
+	var headMoved bool
+	if len(aerr.CancellationReasons) > 0 {
+		switch aws.StringValue(aerr.CancellationReasons[0].Code) {
+		case "ConditionalCheckFailed", "TransactionConflict":
+			headMoved = true
+		}
+	}
+	if !headMoved && len(remaining) == len(items) {
+		return nil, false, err
+	}
+
+	return remaining, headMoved, nil
+}
+
+func (s *Sequencer) head(ctx context.Context) (int64, error) {
+	result, err := s.svc.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName:      s.tableName,
+		Key:            streamKey(headID, 0),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	head := struct {
+		Last int64 `json:"last"`
+	}{}
+	if err := dynamodbattribute.UnmarshalMap(result.Item, &head); err != nil {
+		return 0, err
+	}
+
+	return head.Last, nil
+}
+
+// appendedMarker is the item marking the event appended, keyed by the event's primary key
+func appendedMarker(item map[string]*dynamodb.AttributeValue) (map[string]*dynamodb.AttributeValue, error) {
+	key := struct {
+		AggregateID       string `json:"aggregateId"`
+		AggregateSequence int    `json:"aggregateSequence"`
+	}{}
+	if err := dynamodbattribute.UnmarshalMap(item, &key); err != nil {
+		return nil, err
+	}
+	if key.AggregateID == "" {
+		return nil, fmt.Errorf("Cannot append an item without an aggregateId to the stream: %v", item)
+	}
+
+	return streamKey(fmt.Sprintf("%s%s#%d", appendedPrefix, key.AggregateID, key.AggregateSequence), 0), nil
+}
+
+func streamKey(id string, position int64) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"streamId": {S: aws.String(id)},
+		"position": {N: aws.String(strconv.FormatInt(position, 10))},
+	}
+}
@@ -0,0 +1,117 @@
+package dynamodb
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"forge.lmig.com/n1505471/pizza-shop/eventsource"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// SnapshotStore keeps the latest snapshot of each aggregate, keyed by aggregateId
+type SnapshotStore struct {
+	svc       *dynamodb.DynamoDB
+	tableName *string
+}
+
+func NewSnapshotStore(svc *dynamodb.DynamoDB, t string) *SnapshotStore {
+	return &SnapshotStore{
+		svc:       svc,
+		tableName: aws.String(t),
+	}
+}
+
+// snapshotDto is the DynamoDB representation of a snapshot, the data is stored as a map
+// rather than raw bytes so it remains readable in the table
+type snapshotDto struct {
+	AggregateID       string                 `json:"aggregateId"`
+	AggregateType     string                 `json:"aggregateType"`
+	AggregateSequence int                    `json:"aggregateSequence"`
+	Version           int                    `json:"snapshotVersion"`
+	Timestamp         time.Time              `json:"snapshotTimestamp"`
+	Data              map[string]interface{} `json:"snapshotData"`
+}
+
+func (s *SnapshotStore) LatestSnapshot(ctx context.Context, aggregateID string) (*eventsource.Snapshot, error) {
+	result, err := s.svc.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"aggregateId": {
+				S: aws.String(aggregateID),
+			},
+		},
+		TableName: s.tableName,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Item) == 0 {
+		return nil, nil
+	}
+
+	dto := &snapshotDto{}
+	if err := dynamodbattribute.UnmarshalMap(result.Item, dto); err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(dto.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &eventsource.Snapshot{
+		AggregateID:       dto.AggregateID,
+		AggregateType:     dto.AggregateType,
+		AggregateSequence: dto.AggregateSequence,
+		Version:           dto.Version,
+		Timestamp:         dto.Timestamp,
+		Data:              data,
+	}, nil
+}
+
+func (s *SnapshotStore) SaveSnapshot(ctx context.Context, snapshot eventsource.Snapshot) error {
+	var data map[string]interface{}
+	if err := json.Unmarshal(snapshot.Data, &data); err != nil {
+		return err
+	}
+
+	av, err := dynamodbattribute.MarshalMap(&snapshotDto{
+		AggregateID:       snapshot.AggregateID,
+		AggregateType:     snapshot.AggregateType,
+		AggregateSequence: snapshot.AggregateSequence,
+		Version:           snapshot.Version,
+		Timestamp:         snapshot.Timestamp,
+		Data:              data,
+	})
+	if err != nil {
+		return err
+	}
+	seq, err := dynamodbattribute.Marshal(snapshot.AggregateSequence)
+	if err != nil {
+		return err
+	}
+
+	// Never replace a snapshot with an older one
+	_, err = s.svc.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName:           s.tableName,
+		Item:                av,
+		ConditionExpression: aws.String("attribute_not_exists(aggregateId) OR aggregateSequence < :sequence"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":sequence": seq,
+		},
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case dynamodb.ErrCodeConditionalCheckFailedException:
+				return nil
+			}
+		}
+	}
+
+	return err
+}
+
+var _ eventsource.SnapshotStore = (*SnapshotStore)(nil)
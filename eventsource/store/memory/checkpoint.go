@@ -0,0 +1,72 @@
+package memory
+
+import (
+	"context"
+	"sync"
+
+	"forge.lmig.com/n1505471/pizza-shop/eventsource"
+)
+
+// CheckpointStore keeps projection checkpoints in memory
+type CheckpointStore struct {
+	mu        sync.RWMutex
+	sequences map[string]int
+}
+
+func NewCheckpointStore() *CheckpointStore {
+	return &CheckpointStore{
+		sequences: make(map[string]int),
+	}
+}
+
+func (c *CheckpointStore) LastSequence(_ context.Context, aggregateID string) (int, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.sequences[aggregateID], nil
+}
+
+func (c *CheckpointStore) SaveSequence(_ context.Context, aggregateID string, sequence int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if sequence > c.sequences[aggregateID] {
+		c.sequences[aggregateID] = sequence
+	}
+
+	return nil
+}
+
+var _ eventsource.CheckpointStore = (*CheckpointStore)(nil)
+
+// PositionStore keeps subscription positions in memory
+type PositionStore struct {
+	mu        sync.RWMutex
+	positions map[string]int64
+}
+
+func NewPositionStore() *PositionStore {
+	return &PositionStore{
+		positions: make(map[string]int64),
+	}
+}
+
+func (p *PositionStore) LastPosition(_ context.Context, subscription string) (int64, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.positions[subscription], nil
+}
+
+func (p *PositionStore) SavePosition(_ context.Context, subscription string, position int64) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if position > p.positions[subscription] {
+		p.positions[subscription] = position
+	}
+
+	return nil
+}
+
+var _ eventsource.PositionStore = (*PositionStore)(nil)
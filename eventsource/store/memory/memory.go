@@ -0,0 +1,145 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"forge.lmig.com/n1505471/pizza-shop/eventsource"
+)
+
+// EventStore keeps events in memory, for use in tests and local development
+type EventStore struct {
+	mu     sync.RWMutex
+	events map[string][]eventsource.Event
+	// all is the global stream, every event in the order it was saved
+	all []eventsource.Event
+}
+
+func New() *EventStore {
+	return &EventStore{
+		events: make(map[string][]eventsource.Event),
+	}
+}
+
+func (e *EventStore) SaveEvent(ctx context.Context, event eventsource.Event) error {
+	return e.SaveEvents(ctx, []eventsource.Event{event})
+}
+
+func (e *EventStore) SaveEvents(_ context.Context, events []eventsource.Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	// Mirror the attribute_not_exists(aggregateSequence) condition of the DynamoDB store,
+	// checking every event before saving any so the batch is all or nothing
+	pending := make(map[string]map[int]bool)
+	for _, event := range events {
+		if e.exists(event) || pending[event.AggregateID][event.AggregateSequence] {
+			return &eventsource.AggregateLockError{
+				ID:       event.AggregateID,
+				Sequence: event.AggregateSequence,
+			}
+		}
+		if pending[event.AggregateID] == nil {
+			pending[event.AggregateID] = make(map[int]bool)
+		}
+		pending[event.AggregateID][event.AggregateSequence] = true
+	}
+
+	for _, event := range events {
+		event.Position = int64(len(e.all)) + 1
+		e.all = append(e.all, event)
+		stored := append(e.events[event.AggregateID], event)
+		sort.SliceStable(stored, func(i, j int) bool {
+			return stored[i].AggregateSequence < stored[j].AggregateSequence
+		})
+		e.events[event.AggregateID] = stored
+	}
+
+	return nil
+}
+
+func (e *EventStore) EventsForAggregate(ctx context.Context, aggregateID string) ([]eventsource.Event, error) {
+	return e.EventsForAggregateAfter(ctx, aggregateID, 0)
+}
+
+func (e *EventStore) EventsForAggregateAfter(_ context.Context, aggregateID string, sequence int) ([]eventsource.Event, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	events := []eventsource.Event{}
+	for _, event := range e.events[aggregateID] {
+		if event.AggregateSequence > sequence {
+			events = append(events, event)
+		}
+	}
+
+	return events, nil
+}
+
+func (e *EventStore) EventsForAggregateAsOf(_ context.Context, aggregateID string, asOf eventsource.AsOf) ([]eventsource.Event, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	events := []eventsource.Event{}
+	for _, event := range e.events[aggregateID] {
+		if !asOf.Includes(event) {
+			break
+		}
+		events = append(events, event)
+	}
+
+	return events, nil
+}
+
+func (e *EventStore) ReadAll(_ context.Context, fromPosition int64, limit int) ([]eventsource.Event, error) {
+	return e.read(fromPosition, limit, func(event eventsource.Event) bool {
+		return true
+	})
+}
+
+func (e *EventStore) ReadByType(_ context.Context, eventType string, fromPosition int64, limit int) ([]eventsource.Event, error) {
+	return e.read(fromPosition, limit, func(event eventsource.Event) bool {
+		return event.EventType == eventType
+	})
+}
+
+func (e *EventStore) ReadByAggregateType(_ context.Context, aggregateType string, fromPosition int64, limit int) ([]eventsource.Event, error) {
+	return e.read(fromPosition, limit, func(event eventsource.Event) bool {
+		return event.AggregateType == aggregateType
+	})
+}
+
+// read returns up to limit matching events after the position.  Positions start at 1, so the
+// event at a position is at index position-1 of the global stream.
+func (e *EventStore) read(fromPosition int64, limit int, match func(event eventsource.Event) bool) ([]eventsource.Event, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	events := []eventsource.Event{}
+	if fromPosition < 0 {
+		fromPosition = 0
+	}
+	for i := fromPosition; i < int64(len(e.all)) && len(events) < limit; i++ {
+		if match(e.all[i]) {
+			events = append(events, e.all[i])
+		}
+	}
+
+	return events, nil
+}
+
+func (e *EventStore) exists(event eventsource.Event) bool {
+	for _, existing := range e.events[event.AggregateID] {
+		if existing.AggregateSequence == event.AggregateSequence {
+			return true
+		}
+	}
+	return false
+}
+
+var _ eventsource.EventStorer = (*EventStore)(nil)
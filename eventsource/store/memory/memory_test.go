@@ -0,0 +1,224 @@
+package memory
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-test/deep"
+
+	"forge.lmig.com/n1505471/pizza-shop/eventsource"
+)
+
+func TestEventStore_SaveEvent(t *testing.T) {
+	cases := []struct {
+		Label       string
+		Given       []eventsource.Event
+		Event       eventsource.Event
+		ShouldError bool
+	}{
+		{
+			Label: "saves the first event for an aggregate",
+			Event: eventsource.Event{AggregateID: "a", AggregateSequence: 1},
+		},
+		{
+			Label: "saves the next event for an aggregate",
+			Given: []eventsource.Event{
+				{AggregateID: "a", AggregateSequence: 1},
+			},
+			Event: eventsource.Event{AggregateID: "a", AggregateSequence: 2},
+		},
+		{
+			Label: "allows the same sequence on different aggregates",
+			Given: []eventsource.Event{
+				{AggregateID: "b", AggregateSequence: 1},
+			},
+			Event: eventsource.Event{AggregateID: "a", AggregateSequence: 1},
+		},
+		{
+			Label: "returns an AggregateLockError for an already used sequence",
+			Given: []eventsource.Event{
+				{AggregateID: "a", AggregateSequence: 1},
+			},
+			Event:       eventsource.Event{AggregateID: "a", AggregateSequence: 1},
+			ShouldError: true,
+		},
+	}
+
+	for i, c := range cases {
+		store := New()
+		for _, e := range c.Given {
+			if err := store.SaveEvent(context.Background(), e); err != nil {
+				t.Fatalf("Cases[%d] FAILED: %s.  Error saving given events: %s", i, c.Label, err)
+			}
+		}
+
+		err := store.SaveEvent(context.Background(), c.Event)
+		if c.ShouldError {
+			expected := &eventsource.AggregateLockError{
+				ID:       c.Event.AggregateID,
+				Sequence: c.Event.AggregateSequence,
+			}
+			if diff := deep.Equal(err, expected); diff != nil {
+				t.Errorf("Cases[%d] FAILED: %s.  Error: %s", i, c.Label, diff)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Cases[%d] FAILED: %s.  Error: %s", i, c.Label, err)
+		}
+	}
+}
+
+func TestEventStore_SaveEvents(t *testing.T) {
+	store := New()
+	if err := store.SaveEvent(context.Background(), eventsource.Event{AggregateID: "a", AggregateSequence: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	err := store.SaveEvents(context.Background(), []eventsource.Event{
+		{AggregateID: "a", AggregateSequence: 1},
+		{AggregateID: "a", AggregateSequence: 2},
+	})
+	if _, ok := err.(*eventsource.AggregateLockError); !ok {
+		t.Fatalf("Expected an AggregateLockError, got: %v", err)
+	}
+
+	events, _ := store.EventsForAggregate(context.Background(), "a")
+	if len(events) != 1 {
+		t.Errorf("Expected a failed batch to save nothing, got %d events", len(events))
+	}
+
+	err = store.SaveEvents(context.Background(), []eventsource.Event{
+		{AggregateID: "a", AggregateSequence: 3},
+		{AggregateID: "a", AggregateSequence: 3},
+	})
+	if _, ok := err.(*eventsource.AggregateLockError); !ok {
+		t.Errorf("Expected an AggregateLockError for duplicates within a batch, got: %v", err)
+	}
+
+	if err := store.SaveEvents(context.Background(), []eventsource.Event{
+		{AggregateID: "a", AggregateSequence: 3},
+		{AggregateID: "a", AggregateSequence: 4},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	events, _ = store.EventsForAggregate(context.Background(), "a")
+	if len(events) != 3 {
+		t.Errorf("Expected 3 events, got %d", len(events))
+	}
+
+	if err := store.SaveEvents(context.Background(), nil); err != nil {
+		t.Errorf("Expected saving no events to do nothing, got: %s", err)
+	}
+	if all, _ := store.ReadAll(context.Background(), 0, 10); len(all) != 3 {
+		t.Errorf("Expected saving no events to leave 3 events, got %d", len(all))
+	}
+}
+
+func TestEventStore_EventsForAggregate(t *testing.T) {
+	store := New()
+	given := []eventsource.Event{
+		{AggregateID: "a", AggregateSequence: 2},
+		{AggregateID: "b", AggregateSequence: 1},
+		{AggregateID: "a", AggregateSequence: 1},
+	}
+	for _, e := range given {
+		if err := store.SaveEvent(context.Background(), e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	events, err := store.EventsForAggregate(context.Background(), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []eventsource.Event{
+		{AggregateID: "a", AggregateSequence: 1, Position: 3},
+		{AggregateID: "a", AggregateSequence: 2, Position: 1},
+	}
+	if diff := deep.Equal(events, expected); diff != nil {
+		t.Error(diff)
+	}
+
+	events, err = store.EventsForAggregateAsOf(context.Background(), "a", eventsource.AsOf{Sequence: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(events, expected[:1]); diff != nil {
+		t.Error(diff)
+	}
+
+	events, err = store.EventsForAggregate(context.Background(), "unknown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 0 {
+		t.Errorf("Expected no events for an unknown aggregate, got %d", len(events))
+	}
+}
+
+func TestEventStore_ReadAll(t *testing.T) {
+	ctx := context.Background()
+	store := New()
+	if err := store.SaveEvents(ctx, []eventsource.Event{
+		{AggregateID: "a", AggregateType: "Order", AggregateSequence: 1, EventType: "OrderStarted"},
+		{AggregateID: "a", AggregateType: "Order", AggregateSequence: 2, EventType: "OrderSubmitted"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SaveEvent(ctx, eventsource.Event{AggregateID: "b", AggregateType: "Approval", AggregateSequence: 1, EventType: "ApprovalReceived"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SaveEvent(ctx, eventsource.Event{AggregateID: "c", AggregateType: "Order", AggregateSequence: 1, EventType: "OrderStarted"}); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		Label    string
+		Read     func() ([]eventsource.Event, error)
+		Expected []int64
+	}{
+		{
+			Label:    "reads every event in the order saved",
+			Read:     func() ([]eventsource.Event, error) { return store.ReadAll(ctx, 0, 10) },
+			Expected: []int64{1, 2, 3, 4},
+		},
+		{
+			Label:    "reads from after the position given, up to the limit",
+			Read:     func() ([]eventsource.Event, error) { return store.ReadAll(ctx, 1, 2) },
+			Expected: []int64{2, 3},
+		},
+		{
+			Label:    "reads nothing past the end of the stream",
+			Read:     func() ([]eventsource.Event, error) { return store.ReadAll(ctx, 4, 10) },
+			Expected: []int64{},
+		},
+		{
+			Label:    "reads events of a type",
+			Read:     func() ([]eventsource.Event, error) { return store.ReadByType(ctx, "OrderStarted", 0, 10) },
+			Expected: []int64{1, 4},
+		},
+		{
+			Label:    "reads events of an aggregate type",
+			Read:     func() ([]eventsource.Event, error) { return store.ReadByAggregateType(ctx, "Order", 1, 10) },
+			Expected: []int64{2, 4},
+		},
+	}
+
+	for i, c := range cases {
+		events, err := c.Read()
+		if err != nil {
+			t.Errorf("Cases[%d] FAILED: %s.  Error: %s", i, c.Label, err)
+			continue
+		}
+		positions := []int64{}
+		for _, e := range events {
+			positions = append(positions, e.Position)
+		}
+		if diff := deep.Equal(positions, c.Expected); diff != nil {
+			t.Errorf("Cases[%d] FAILED: %s.  Error: %s", i, c.Label, diff)
+		}
+	}
+}
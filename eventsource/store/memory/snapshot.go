@@ -0,0 +1,46 @@
+package memory
+
+import (
+	"context"
+	"sync"
+
+	"forge.lmig.com/n1505471/pizza-shop/eventsource"
+)
+
+// SnapshotStore keeps the latest snapshot of each aggregate in memory
+type SnapshotStore struct {
+	mu        sync.RWMutex
+	snapshots map[string]eventsource.Snapshot
+}
+
+func NewSnapshotStore() *SnapshotStore {
+	return &SnapshotStore{
+		snapshots: make(map[string]eventsource.Snapshot),
+	}
+}
+
+func (s *SnapshotStore) LatestSnapshot(_ context.Context, aggregateID string) (*eventsource.Snapshot, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	snapshot, ok := s.snapshots[aggregateID]
+	if !ok {
+		return nil, nil
+	}
+	return &snapshot, nil
+}
+
+func (s *SnapshotStore) SaveSnapshot(_ context.Context, snapshot eventsource.Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Never replace a snapshot with an older one
+	if existing, ok := s.snapshots[snapshot.AggregateID]; ok && existing.AggregateSequence >= snapshot.AggregateSequence {
+		return nil
+	}
+	s.snapshots[snapshot.AggregateID] = snapshot
+
+	return nil
+}
+
+var _ eventsource.SnapshotStore = (*SnapshotStore)(nil)
@@ -0,0 +1,165 @@
+package eventsource
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// PositionStore persists the last global Position each subscription handled
+type PositionStore interface {
+	// LastPosition returns 0 when the subscription hasn't handled any events
+	LastPosition(ctx context.Context, subscription string) (int64, error)
+	SavePosition(ctx context.Context, subscription string, position int64) error
+}
+
+// defaultBatchSize is how many events a Subscription reads at a time
+const defaultBatchSize = 100
+
+// SubscriptionOption configures optional Subscription behaviour
+type SubscriptionOption func(s *Subscription)
+
+// ForEventType subscribes to the events of one type, rather than the whole stream
+func ForEventType(eventType string) SubscriptionOption {
+	return func(s *Subscription) {
+		s.match = func(event Event) bool {
+			return event.EventType == eventType
+		}
+	}
+}
+
+// ForAggregateType subscribes to the events of one type of aggregate, rather than the whole stream
+func ForAggregateType(aggregateType string) SubscriptionOption {
+	return func(s *Subscription) {
+		s.match = func(event Event) bool {
+			return event.AggregateType == aggregateType
+		}
+	}
+}
+
+// WithBatchSize sets how many events are read from the store at a time
+func WithBatchSize(n int) SubscriptionOption {
+	return func(s *Subscription) {
+		s.batchSize = n
+	}
+}
+
+// Subscription feeds a Projection from the global stream, resuming after the last position it
+// saved.  It catches up with the events saved while it wasn't running, then polls for new ones.
+type Subscription struct {
+	name       string
+	store      EventStorer
+	projection Projection
+	positions  PositionStore
+	// match filters the events handled.  The whole stream is still read, so the subscription
+	// can tell a gap in the positions from events it doesn't handle.
+	match     func(event Event) bool
+	batchSize int
+}
+
+func NewSubscription(name string, store EventStorer, projection Projection, positions PositionStore, opts ...SubscriptionOption) *Subscription {
+	s := &Subscription{
+		name:       name,
+		store:      store,
+		projection: projection,
+		positions:  positions,
+		match: func(event Event) bool {
+			return true
+		},
+		batchSize: defaultBatchSize,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// CatchUp handles the events after the last saved position until it reaches the end of the
+// stream, returning how many were handled.  The position is saved after each event handled, so
+// a failed event is retried by the next call.  It stops short of a gap in the positions, which
+// is an event not yet readable, rather than skip it.
+func (s *Subscription) CatchUp(ctx context.Context) (int, error) {
+	saved, err := s.positions.LastPosition(ctx, s.name)
+	if err != nil {
+		return 0, err
+	}
+
+	// Events the subscription doesn't handle move the position on without saving it, it's
+	// saved with the next event handled or before returning
+	position := saved
+	savePosition := func() error {
+		if position == saved {
+			return nil
+		}
+		if err := s.positions.SavePosition(ctx, s.name, position); err != nil {
+			return err
+		}
+		saved = position
+		return nil
+	}
+
+	handled := 0
+	for {
+		events, err := s.store.ReadAll(ctx, position, s.batchSize)
+		if err != nil {
+			return handled, firstError(err, savePosition())
+		}
+
+		for _, event := range events {
+			if event.Position != position+1 {
+				log.Printf("Subscription %s waiting for position %d, read %d", s.name, position+1, event.Position)
+				return handled, savePosition()
+			}
+			if !s.match(event) {
+				position = event.Position
+				continue
+			}
+			if err := s.projection.HandleEvent(ctx, event); err != nil {
+				err = fmt.Errorf("Error handling %s at position %d, details: %s", event.EventType, event.Position, err)
+				return handled, firstError(err, savePosition())
+			}
+			position = event.Position
+			if err := savePosition(); err != nil {
+				return handled, err
+			}
+			handled++
+		}
+
+		if len(events) < s.batchSize {
+			return handled, savePosition()
+		}
+	}
+}
+
+// firstError returns the first of the errors which isn't nil
+func firstError(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run catches up, then polls for new events every interval until the context is done.  Errors
+// are logged and the failed event is retried on the next poll.
+func (s *Subscription) Run(ctx context.Context, interval time.Duration) {
+	if n, err := s.CatchUp(ctx); err != nil || n > 0 {
+		log.Printf("Subscription %s caught up with %d events, last error: %v", s.name, n, err)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if n, err := s.CatchUp(ctx); err != nil || n > 0 {
+				log.Printf("Subscription %s handled %d events, last error: %v", s.name, n, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package eventsource
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-test/deep"
+)
+
+func TestSubscription_CatchUp(t *testing.T) {
+	stream := []Event{
+		{AggregateID: "a", AggregateSequence: 1, EventType: "started", Position: 1},
+		{AggregateID: "b", AggregateSequence: 1, EventType: "started", Position: 2},
+		{AggregateID: "a", AggregateSequence: 2, EventType: "submitted", Position: 3},
+		{AggregateID: "fail", AggregateSequence: 1, EventType: "started", Position: 4},
+		{AggregateID: "b", AggregateSequence: 2, EventType: "submitted", Position: 5},
+	}
+
+	cases := []struct {
+		Label            string
+		Stream           []Event
+		Options          []SubscriptionOption
+		From             int64
+		Expected         []int
+		ExpectedPosition int64
+		ShouldError      bool
+	}{
+		{
+			Label:            "handles the whole stream in batches",
+			Stream:           stream[:3],
+			Options:          []SubscriptionOption{WithBatchSize(2)},
+			Expected:         []int{1, 1, 2},
+			ExpectedPosition: 3,
+		},
+		{
+			Label:            "resumes after the saved position",
+			Stream:           stream[:3],
+			From:             1,
+			Expected:         []int{1, 2},
+			ExpectedPosition: 3,
+		},
+		{
+			Label:            "stops at a failed event, keeping the position before it",
+			Stream:           stream,
+			From:             2,
+			Expected:         []int{2},
+			ExpectedPosition: 3,
+			ShouldError:      true,
+		},
+		{
+			Label:            "waits at a gap in the stream",
+			Stream:           []Event{stream[0], stream[2]},
+			Expected:         []int{1},
+			ExpectedPosition: 1,
+		},
+		{
+			Label:            "moves past other events when subscribed to an event type",
+			Stream:           stream,
+			Options:          []SubscriptionOption{ForEventType("submitted")},
+			Expected:         []int{2, 2},
+			ExpectedPosition: 5,
+		},
+		{
+			Label:            "waits at a gap in the stream when subscribed to an event type",
+			Stream:           []Event{stream[0], stream[2]},
+			Options:          []SubscriptionOption{ForEventType("submitted")},
+			Expected:         nil,
+			ExpectedPosition: 1,
+		},
+		{
+			Label:            "keeps the position of other events before a failed event",
+			Stream:           stream,
+			From:             2,
+			Options:          []SubscriptionOption{ForEventType("started")},
+			Expected:         nil,
+			ExpectedPosition: 3,
+			ShouldError:      true,
+		},
+	}
+
+	for i, c := range cases {
+		projection := &recordingProjection{}
+		positions := &mapPositionStore{positions: map[string]int64{"orders": c.From}}
+		s := NewSubscription("orders", &streamStore{events: c.Stream}, projection, positions, c.Options...)
+
+		_, err := s.CatchUp(context.Background())
+		if c.ShouldError != (err != nil) {
+			t.Errorf("Cases[%d] FAILED: %s.  Error: %v", i, c.Label, err)
+		}
+		if diff := deep.Equal(projection.sequences, c.Expected); diff != nil {
+			t.Errorf("Cases[%d] FAILED: %s.  Error: %s", i, c.Label, diff)
+		}
+		if positions.positions["orders"] != c.ExpectedPosition {
+			t.Errorf("Cases[%d] FAILED: %s.  Expected position %d, got %d", i, c.Label, c.ExpectedPosition, positions.positions["orders"])
+		}
+	}
+}
+
+// streamStore serves the global stream from a slice of events, ordered by position
+type streamStore struct {
+	EventStorer
+	events []Event
+}
+
+func (s *streamStore) ReadAll(_ context.Context, fromPosition int64, limit int) ([]Event, error) {
+	var events []Event
+	for _, e := range s.events {
+		if e.Position > fromPosition && len(events) < limit {
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+type mapPositionStore struct {
+	positions map[string]int64
+}
+
+func (m *mapPositionStore) LastPosition(_ context.Context, subscription string) (int64, error) {
+	return m.positions[subscription], nil
+}
+
+func (m *mapPositionStore) SavePosition(_ context.Context, subscription string, position int64) error {
+	m.positions[subscription] = position
+	return nil
+}
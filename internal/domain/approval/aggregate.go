@@ -13,6 +13,7 @@ type Aggregate struct {
 	eventsource.AggregateBase
 	ApprovalID string
 	Approved   bool
+	Rejected   bool
 }
 
 func (a *Aggregate) Init(aggregateID string) {
@@ -26,6 +27,8 @@ func (a *Aggregate) HandleCommand(command eventsource.Command) ([]eventsource.Ev
 		return a.handleRequestCommand(c)
 	case *ReceiveApproval:
 		return a.handleReceiveCommand(c)
+	case *RejectApproval:
+		return a.handleRejectCommand(c)
 	default:
 		return nil, fmt.Errorf("No handler for command: %T", c)
 	}
@@ -51,6 +54,9 @@ func (a *Aggregate) handleReceiveCommand(c *ReceiveApproval) ([]eventsource.Even
 	if a.Approved {
 		return nil, nil
 	}
+	if a.Rejected {
+		return nil, fmt.Errorf("Approval %d has already been rejected", c.ApprovalID)
+	}
 
 	event := &ApprovalReceived{
 		ApprovalID: c.ApprovalID,
@@ -58,11 +64,29 @@ func (a *Aggregate) handleReceiveCommand(c *ReceiveApproval) ([]eventsource.Even
 	return []eventsource.EventData{event}, nil
 }
 
+func (a *Aggregate) handleRejectCommand(c *RejectApproval) ([]eventsource.EventData, error) {
+
+	if a.Rejected {
+		return nil, nil
+	}
+	if a.Approved {
+		return nil, fmt.Errorf("Approval %d has already been received", c.ApprovalID)
+	}
+
+	event := &ApprovalRejected{
+		ApprovalID: c.ApprovalID,
+		Reason:     c.Reason,
+	}
+	return []eventsource.EventData{event}, nil
+}
+
 func (a *Aggregate) ApplyEvent(event eventsource.Event) error {
 
 	switch event.Data.(type) {
 	case *ApprovalReceived:
 		a.Approved = true
+	case *ApprovalRejected:
+		a.Rejected = true
 	}
 
 	return nil
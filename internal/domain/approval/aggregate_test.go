@@ -20,6 +20,11 @@ var receiveApproval = &command.ReceiveApproval{
 	ApprovalID: 101,
 }
 
+var rejectApproval = &command.RejectApproval{
+	ApprovalID: 101,
+	Reason:     "Out of dough",
+}
+
 var approvalRequestedEvent = &event.ApprovalRequested{
 	ApprovalID: 101,
 }
@@ -28,6 +33,11 @@ var approvalReceivedEvent = &event.ApprovalReceived{
 	ApprovalID: 101,
 }
 
+var approvalRejectedEvent = &event.ApprovalRejected{
+	ApprovalID: 101,
+	Reason:     "Out of dough",
+}
+
 func TestAggregate_HandleCommand(t *testing.T) {
 
 	cases := eventsourcetest.HandleCommandTestCases{
@@ -63,6 +73,38 @@ func TestAggregate_HandleCommand(t *testing.T) {
 				approvalReceivedEvent,
 			},
 		},
+		{
+			Label: "prevents approvals once rejected",
+			Given: []eventsource.EventData{
+				approvalRejectedEvent,
+			},
+			Command:     receiveApproval,
+			ShouldError: true,
+		},
+		{
+			Label: "ignores double rejections",
+			Given: []eventsource.EventData{
+				approvalRejectedEvent,
+			},
+			Command:  rejectApproval,
+			Expected: nil,
+		},
+		{
+			Label: "prevents rejections once approved",
+			Given: []eventsource.EventData{
+				approvalReceivedEvent,
+			},
+			Command:     rejectApproval,
+			ShouldError: true,
+		},
+		{
+			Label:   "correctly issues the ApprovalRejected event",
+			Given:   nil,
+			Command: rejectApproval,
+			Expected: []eventsource.EventData{
+				approvalRejectedEvent,
+			},
+		},
 	}
 
 	cases.Test(&Aggregate{}, t)
@@ -77,6 +119,12 @@ func TestAggregate_ApplyEvent(t *testing.T) {
 				Approved: true,
 			},
 		},
+		{
+			Event: approvalRejectedEvent,
+			Expected: &Aggregate{
+				Rejected: true,
+			},
+		},
 	}
 
 	for i, c := range cases {
@@ -4,7 +4,7 @@ import "strconv"
 
 // ReceiveApproval attempts to submit the order for fulfillment
 type ReceiveApproval struct {
-	ApprovalID int
+	ApprovalID int `validate:"required"`
 }
 
 func (c *ReceiveApproval) AggregateID() string {
@@ -0,0 +1,13 @@
+package command
+
+import "strconv"
+
+// RejectApproval records the vendor system declining the order
+type RejectApproval struct {
+	ApprovalID int `validate:"required"`
+	Reason     string
+}
+
+func (c *RejectApproval) AggregateID() string {
+	return strconv.Itoa(c.ApprovalID)
+}
@@ -4,7 +4,7 @@ import "strconv"
 
 // ReceiveApproval attempts to submit the order for fulfillment
 type RequestApproval struct {
-	ApprovalID int
+	ApprovalID int `validate:"required"`
 }
 
 func (c *RequestApproval) AggregateID() string {
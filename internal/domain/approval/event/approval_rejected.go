@@ -0,0 +1,32 @@
+package event
+
+import (
+	"encoding/json"
+
+	"forge.lmig.com/n1505471/pizza-shop/eventsource"
+)
+
+func init() {
+	eventsource.RegisterEventType(&ApprovalRejected{})
+}
+
+// ApprovalRejected fired when the vendor system declines the order
+type ApprovalRejected struct {
+	ApprovalID int    `json:"approvalId"`
+	Reason     string `json:"reason"`
+}
+
+func (e *ApprovalRejected) Version() int {
+	return 1
+}
+
+func (e *ApprovalRejected) Load(data json.RawMessage, version int) error {
+	switch version {
+	default:
+		err := json.Unmarshal(data, e)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package event
+
+import (
+	"testing"
+
+	"forge.lmig.com/n1505471/pizza-shop/eventsource/eventsourcetest"
+)
+
+func TestApprovalRejected_Load(t *testing.T) {
+	cases := eventsourcetest.EventLoadTestCases{
+		{
+			Label:   "correctly handles version 1 event",
+			Version: 1,
+			Event: `
+				{
+					"approvalId": 101,
+					"reason": "Out of dough"
+				}
+			`,
+			Expected: &ApprovalRejected{
+				ApprovalID: 101,
+				Reason:     "Out of dough",
+			},
+		},
+		{
+			Label:   "version 1 returns error with invalid json",
+			Version: 1,
+			Event: `
+				{
+					"approvalID":"84de2628-ac3b-4fcf-b2a1-05cf5b1b5743"
+				}
+			`,
+			Expected:    &ApprovalRejected{},
+			ShouldError: true,
+		},
+	}
+
+	cases.Test(t)
+}
@@ -0,0 +1,31 @@
+package event
+
+import (
+	"encoding/json"
+
+	"forge.lmig.com/n1505471/pizza-shop/eventsource"
+)
+
+func init() {
+	eventsource.RegisterEventType(&ApprovalTimedOut{})
+}
+
+// ApprovalTimedOut fired when the vendor system hasn't responded to an approval request in time
+type ApprovalTimedOut struct {
+	OrderID string `json:"orderId"`
+}
+
+func (e *ApprovalTimedOut) Version() int {
+	return 1
+}
+
+func (e *ApprovalTimedOut) Load(data json.RawMessage, version int) error {
+	switch version {
+	default:
+		err := json.Unmarshal(data, e)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package event
+
+import (
+	"testing"
+
+	"forge.lmig.com/n1505471/pizza-shop/eventsource/eventsourcetest"
+)
+
+func TestApprovalTimedOut_Load(t *testing.T) {
+	cases := eventsourcetest.EventLoadTestCases{
+		{
+			Label:   "correctly handles version 1 event",
+			Version: 1,
+			Event: `
+				{
+					"orderId": "testOrderId"
+				}
+			`,
+			Expected: &ApprovalTimedOut{
+				OrderID: "testOrderId",
+			},
+		},
+		{
+			Label:   "version 1 returns error with invalid json",
+			Version: 1,
+			Event: `
+				{
+					"orderId": 101
+				}
+			`,
+			Expected:    &ApprovalTimedOut{},
+			ShouldError: true,
+		},
+	}
+
+	cases.Test(t)
+}
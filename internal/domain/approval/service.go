@@ -2,6 +2,7 @@ package approval
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -21,8 +22,9 @@ type OrderApproval struct {
 }
 
 type ServiceAPI interface {
-	ReceiveApproval(int) error
-	SubmitOrderForApproval(*OrderApproval) (*OrderApproval, error)
+	ReceiveApproval(context.Context, int) error
+	RejectApproval(ctx context.Context, approvalID int, reason string) error
+	SubmitOrderForApproval(context.Context, *OrderApproval) (*OrderApproval, error)
 }
 
 type Service struct {
@@ -30,8 +32,8 @@ type Service struct {
 	url         string
 }
 
-func (s *Service) processCommand(c eventsource.Command) error {
-	return s.eventSource.ProcessCommand(c, &Aggregate{})
+func (s *Service) processCommand(ctx context.Context, c eventsource.Command) error {
+	return s.eventSource.ProcessCommand(ctx, c, &Aggregate{})
 }
 
 func NewService(eventSource eventsource.EventSourceAPI) *Service {
@@ -41,11 +43,15 @@ func NewService(eventSource eventsource.EventSourceAPI) *Service {
 	}
 }
 
-func (s *Service) ReceiveApproval(approvalID int) error {
-	return s.processCommand(&command.ReceiveApproval{ApprovalID: approvalID})
+func (s *Service) ReceiveApproval(ctx context.Context, approvalID int) error {
+	return s.processCommand(ctx, &command.ReceiveApproval{ApprovalID: approvalID})
 }
 
-func (s *Service) SubmitOrderForApproval(payload *OrderApproval) (*OrderApproval, error) {
+func (s *Service) RejectApproval(ctx context.Context, approvalID int, reason string) error {
+	return s.processCommand(ctx, &command.RejectApproval{ApprovalID: approvalID, Reason: reason})
+}
+
+func (s *Service) SubmitOrderForApproval(ctx context.Context, payload *OrderApproval) (*OrderApproval, error) {
 
 	body, err := json.Marshal(payload)
 	if err != nil {
@@ -70,7 +76,7 @@ func (s *Service) SubmitOrderForApproval(payload *OrderApproval) (*OrderApproval
 		return nil, err
 	}
 
-	if err := s.processCommand(&command.RequestApproval{ApprovalID: o.ApprovalID}); err != nil {
+	if err := s.processCommand(ctx, &command.RequestApproval{ApprovalID: o.ApprovalID}); err != nil {
 		return nil, err
 	}
 	log.Printf("Approval requested with payload: %+v, got tracking ID: %d", payload, o.ApprovalID)
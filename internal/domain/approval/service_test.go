@@ -1,6 +1,7 @@
 package approval
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -44,7 +45,49 @@ func TestService_ReceiveApproval(t *testing.T) {
 			shouldError: c.ShouldError,
 		})
 
-		err := s.ReceiveApproval(approvalID)
+		err := s.ReceiveApproval(context.Background(), approvalID)
+		if c.ShouldError && err == nil {
+			t.Errorf("Cases[%d] FAILED: %s, expected an error.", i, c.Label)
+			continue
+		}
+
+		if !c.ShouldError && err != nil {
+			t.Errorf("Cases[%d] FAILED: %s.  Error: %s", i, c.Label, err)
+		}
+	}
+}
+
+func TestService_RejectApproval(t *testing.T) {
+	cases := []struct {
+		Label       string
+		Check       Condition
+		ShouldError bool
+	}{
+		{
+			Label: "Should correctly issue the RejectApproval command",
+			Check: func(c eventsource.Command) error {
+				cmd, ok := c.(*command.RejectApproval)
+				if !ok {
+					return fmt.Errorf("Expected %T, got %T", &command.RejectApproval{}, c)
+				}
+				if cmd.ApprovalID != 101 {
+					return fmt.Errorf("Expected `%d` for ApprovalID, got `%d`", 101, cmd.ApprovalID)
+				}
+				if cmd.Reason != "Out of dough" {
+					return fmt.Errorf("Expected `%s` for Reason, got `%s`", "Out of dough", cmd.Reason)
+				}
+				return nil
+			},
+		},
+	}
+
+	for i, c := range cases {
+		s := NewService(&mockEventSource{
+			check:       c.Check,
+			shouldError: c.ShouldError,
+		})
+
+		err := s.RejectApproval(context.Background(), approvalID, "Out of dough")
 		if c.ShouldError && err == nil {
 			t.Errorf("Cases[%d] FAILED: %s, expected an error.", i, c.Label)
 			continue
@@ -115,7 +158,7 @@ func TestService_SubmitOrderForApproval(t *testing.T) {
 			url: ts.URL,
 		}
 
-		result, err := s.SubmitOrderForApproval(c.Payload)
+		result, err := s.SubmitOrderForApproval(context.Background(), c.Payload)
 		if c.ShouldError && err == nil {
 			t.Errorf("Cases[%d] FAILED: %s, expected an error.", i, c.Label)
 			continue
@@ -140,7 +183,7 @@ type mockEventSource struct {
 	shouldError bool
 }
 
-func (m *mockEventSource) ProcessCommand(got eventsource.Command, a eventsource.Aggregate) error {
+func (m *mockEventSource) ProcessCommand(_ context.Context, got eventsource.Command, a eventsource.Aggregate) error {
 
 	if m.shouldError {
 		return fmt.Errorf("Expecting an error here.")
@@ -14,6 +14,7 @@ type Aggregate struct {
 	eventsource.AggregateBase
 	DeliveryID string
 	Delivered  bool
+	Failed     bool
 }
 
 func (a *Aggregate) Init(aggregateID string) {
@@ -27,6 +28,8 @@ func (a *Aggregate) HandleCommand(command eventsource.Command) ([]eventsource.Ev
 		return a.handleRequestDelivery(c)
 	case *ConfirmDelivery:
 		return a.handleConfirmDelivery(c)
+	case *FailDelivery:
+		return a.handleFailDelivery(c)
 	default:
 		message := fmt.Sprintf("No handler for command: %+v", c)
 		return nil, errors.New(message)
@@ -52,6 +55,9 @@ func (a *Aggregate) handleConfirmDelivery(c *ConfirmDelivery) ([]eventsource.Eve
 	if a.Delivered {
 		return nil, nil
 	}
+	if a.Failed {
+		return nil, fmt.Errorf("Delivery %d has already failed", c.DeliveryID)
+	}
 
 	event := &DeliveryConfirmed{
 		DeliveryID: c.DeliveryID,
@@ -59,11 +65,28 @@ func (a *Aggregate) handleConfirmDelivery(c *ConfirmDelivery) ([]eventsource.Eve
 	return []eventsource.EventData{event}, nil
 }
 
+func (a *Aggregate) handleFailDelivery(c *FailDelivery) ([]eventsource.EventData, error) {
+	if a.Failed {
+		return nil, nil
+	}
+	if a.Delivered {
+		return nil, fmt.Errorf("Delivery %d has already been confirmed", c.DeliveryID)
+	}
+
+	event := &DeliveryFailed{
+		DeliveryID: c.DeliveryID,
+		Reason:     c.Reason,
+	}
+	return []eventsource.EventData{event}, nil
+}
+
 func (a *Aggregate) ApplyEvent(event eventsource.Event) error {
 
 	switch event.Data.(type) {
 	case *DeliveryConfirmed:
 		a.Delivered = true
+	case *DeliveryFailed:
+		a.Failed = true
 	}
 
 	return nil
@@ -20,6 +20,11 @@ var confirmDelivery = &command.ConfirmDelivery{
 	DeliveryID: 101,
 }
 
+var failDelivery = &command.FailDelivery{
+	DeliveryID: 101,
+	Reason:     "Driver could not find the address",
+}
+
 var deliveryRequestedEvent = &event.DeliveryConfirmed{
 	DeliveryID: 101,
 }
@@ -28,6 +33,11 @@ var deliveryConfirmedEvent = &event.DeliveryConfirmed{
 	DeliveryID: 101,
 }
 
+var deliveryFailedEvent = &event.DeliveryFailed{
+	DeliveryID: 101,
+	Reason:     "Driver could not find the address",
+}
+
 func TestAggregate_HandleCommand(t *testing.T) {
 
 	cases := eventsourcetest.HandleCommandTestCases{
@@ -63,6 +73,38 @@ func TestAggregate_HandleCommand(t *testing.T) {
 				deliveryConfirmedEvent,
 			},
 		},
+		{
+			Label: "prevents confirming failed deliveries",
+			Given: []eventsource.EventData{
+				deliveryFailedEvent,
+			},
+			Command:     confirmDelivery,
+			ShouldError: true,
+		},
+		{
+			Label: "ignores double failures",
+			Given: []eventsource.EventData{
+				deliveryFailedEvent,
+			},
+			Command:  failDelivery,
+			Expected: nil,
+		},
+		{
+			Label: "prevents failing confirmed deliveries",
+			Given: []eventsource.EventData{
+				deliveryConfirmedEvent,
+			},
+			Command:     failDelivery,
+			ShouldError: true,
+		},
+		{
+			Label:   "correctly issues the DeliveryFailed event",
+			Given:   nil,
+			Command: failDelivery,
+			Expected: []eventsource.EventData{
+				deliveryFailedEvent,
+			},
+		},
 	}
 
 	cases.Test(&Aggregate{}, t)
@@ -77,6 +119,12 @@ func TestAggregate_ApplyEvent(t *testing.T) {
 				Delivered: true,
 			},
 		},
+		{
+			Event: deliveryFailedEvent,
+			Expected: &Aggregate{
+				Failed: true,
+			},
+		},
 	}
 
 	for i, c := range cases {
@@ -4,7 +4,7 @@ import "strconv"
 
 // ReceiveApproval attempts to submit the order for fulfillment
 type ConfirmDelivery struct {
-	DeliveryID int
+	DeliveryID int `validate:"required"`
 }
 
 func (c *ConfirmDelivery) AggregateID() string {
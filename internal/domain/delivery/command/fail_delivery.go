@@ -0,0 +1,13 @@
+package command
+
+import "strconv"
+
+// FailDelivery records the delivery service giving up on the order
+type FailDelivery struct {
+	DeliveryID int `validate:"required"`
+	Reason     string
+}
+
+func (c *FailDelivery) AggregateID() string {
+	return strconv.Itoa(c.DeliveryID)
+}
@@ -4,7 +4,7 @@ import "strconv"
 
 // ReceiveApproval attempts to submit the order for fulfillment
 type RequestDelivery struct {
-	DeliveryID int
+	DeliveryID int `validate:"required"`
 }
 
 func (c *RequestDelivery) AggregateID() string {
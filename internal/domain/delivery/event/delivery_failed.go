@@ -0,0 +1,32 @@
+package event
+
+import (
+	"encoding/json"
+
+	"forge.lmig.com/n1505471/pizza-shop/eventsource"
+)
+
+func init() {
+	eventsource.RegisterEventType(&DeliveryFailed{})
+}
+
+// DeliveryFailed fired when the delivery service could not deliver the order
+type DeliveryFailed struct {
+	DeliveryID int    `json:"deliveryId"`
+	Reason     string `json:"reason"`
+}
+
+func (e *DeliveryFailed) Version() int {
+	return 1
+}
+
+func (e *DeliveryFailed) Load(data json.RawMessage, version int) error {
+	switch version {
+	default:
+		err := json.Unmarshal(data, e)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package event
+
+import (
+	"testing"
+
+	"forge.lmig.com/n1505471/pizza-shop/eventsource/eventsourcetest"
+)
+
+func TestDeliveryFailed_Load(t *testing.T) {
+	cases := eventsourcetest.EventLoadTestCases{
+		{
+			Label:   "correctly handles version 1 event",
+			Version: 1,
+			Event: `
+				{
+					"deliveryId": 101,
+					"reason": "Driver could not find the address"
+				}
+			`,
+			Expected: &DeliveryFailed{
+				DeliveryID: 101,
+				Reason:     "Driver could not find the address",
+			},
+		},
+		{
+			Label:   "version 1 returns error with invalid json",
+			Version: 1,
+			Event: `
+				{
+					"deliveryId":"84de2628-ac3b-4fcf-b2a1-05cf5b1b5743"
+				}
+			`,
+			Expected:    &DeliveryFailed{},
+			ShouldError: true,
+		},
+	}
+
+	cases.Test(t)
+}
@@ -2,6 +2,7 @@ package delivery
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -21,8 +22,9 @@ type OrderDelivery struct {
 }
 
 type ServiceAPI interface {
-	ReceiveDeliveryNotification(int) error
-	SubmitOrderForDelivery(*OrderDelivery) (*OrderDelivery, error)
+	ReceiveDeliveryNotification(context.Context, int) error
+	ReceiveDeliveryFailure(ctx context.Context, deliveryID int, reason string) error
+	SubmitOrderForDelivery(context.Context, *OrderDelivery) (*OrderDelivery, error)
 }
 
 type Service struct {
@@ -30,8 +32,8 @@ type Service struct {
 	url         string
 }
 
-func (s *Service) processCommand(c eventsource.Command) error {
-	return s.eventSource.ProcessCommand(c, &Aggregate{})
+func (s *Service) processCommand(ctx context.Context, c eventsource.Command) error {
+	return s.eventSource.ProcessCommand(ctx, c, &Aggregate{})
 }
 
 func NewService(eventSource eventsource.EventSourceAPI) *Service {
@@ -41,11 +43,15 @@ func NewService(eventSource eventsource.EventSourceAPI) *Service {
 	}
 }
 
-func (s *Service) ReceiveDeliveryNotification(deliveryID int) error {
-	return s.processCommand(&command.ConfirmDelivery{DeliveryID: deliveryID})
+func (s *Service) ReceiveDeliveryNotification(ctx context.Context, deliveryID int) error {
+	return s.processCommand(ctx, &command.ConfirmDelivery{DeliveryID: deliveryID})
 }
 
-func (s *Service) SubmitOrderForDelivery(payload *OrderDelivery) (*OrderDelivery, error) {
+func (s *Service) ReceiveDeliveryFailure(ctx context.Context, deliveryID int, reason string) error {
+	return s.processCommand(ctx, &command.FailDelivery{DeliveryID: deliveryID, Reason: reason})
+}
+
+func (s *Service) SubmitOrderForDelivery(ctx context.Context, payload *OrderDelivery) (*OrderDelivery, error) {
 
 	body, err := json.Marshal(payload)
 	if err != nil {
@@ -68,7 +74,7 @@ func (s *Service) SubmitOrderForDelivery(payload *OrderDelivery) (*OrderDelivery
 		return nil, err
 	}
 
-	if err := s.processCommand(&command.RequestDelivery{DeliveryID: o.DeliveryID}); err != nil {
+	if err := s.processCommand(ctx, &command.RequestDelivery{DeliveryID: o.DeliveryID}); err != nil {
 		return nil, err
 	}
 
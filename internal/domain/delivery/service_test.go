@@ -1,6 +1,7 @@
 package delivery
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -44,7 +45,49 @@ func TestService_ReceiveDeliveryNotification(t *testing.T) {
 			shouldError: c.ShouldError,
 		})
 
-		err := s.ReceiveDeliveryNotification(101)
+		err := s.ReceiveDeliveryNotification(context.Background(), 101)
+		if c.ShouldError && err == nil {
+			t.Errorf("Cases[%d] FAILED: %s, expected an error.", i, c.Label)
+			continue
+		}
+
+		if !c.ShouldError && err != nil {
+			t.Errorf("Cases[%d] FAILED: %s.  Error: %s", i, c.Label, err)
+		}
+	}
+}
+
+func TestService_ReceiveDeliveryFailure(t *testing.T) {
+	cases := []struct {
+		Label       string
+		Check       Condition
+		ShouldError bool
+	}{
+		{
+			Label: "Should correctly issue the FailDelivery command",
+			Check: func(c eventsource.Command) error {
+				cmd, ok := c.(*command.FailDelivery)
+				if !ok {
+					return fmt.Errorf("Expected %T, got %T", &command.FailDelivery{}, c)
+				}
+				if cmd.DeliveryID != 101 {
+					return fmt.Errorf("Expected `%d` for DeliveryID, got `%d`", 101, cmd.DeliveryID)
+				}
+				if cmd.Reason != "Driver could not find the address" {
+					return fmt.Errorf("Expected `%s` for Reason, got `%s`", "Driver could not find the address", cmd.Reason)
+				}
+				return nil
+			},
+		},
+	}
+
+	for i, c := range cases {
+		s := NewService(&mockEventSource{
+			check:       c.Check,
+			shouldError: c.ShouldError,
+		})
+
+		err := s.ReceiveDeliveryFailure(context.Background(), 101, "Driver could not find the address")
 		if c.ShouldError && err == nil {
 			t.Errorf("Cases[%d] FAILED: %s, expected an error.", i, c.Label)
 			continue
@@ -115,7 +158,7 @@ func TestService_SubmitOrderForApproval(t *testing.T) {
 			url: ts.URL,
 		}
 
-		result, err := s.SubmitOrderForDelivery(c.Payload)
+		result, err := s.SubmitOrderForDelivery(context.Background(), c.Payload)
 		if c.ShouldError && err == nil {
 			t.Errorf("Cases[%d] FAILED: %s, expected an error.", i, c.Label)
 			continue
@@ -140,7 +183,7 @@ type mockEventSource struct {
 	shouldError bool
 }
 
-func (m *mockEventSource) ProcessCommand(got eventsource.Command, a eventsource.Aggregate) error {
+func (m *mockEventSource) ProcessCommand(_ context.Context, got eventsource.Command, a eventsource.Aggregate) error {
 
 	if m.shouldError {
 		return fmt.Errorf("Expecting an error here.")
@@ -1,6 +1,7 @@
 package order
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 
@@ -36,6 +37,10 @@ func (a *Aggregate) HandleCommand(command eventsource.Command) ([]eventsource.Ev
 		return a.handleApproveOrder(c)
 	case *DeliverOrderCommand:
 		return a.handleDeliverOrder(c)
+	case *RejectOrderCommand:
+		return a.handleRejectOrder(c)
+	case *CancelOrderCommand:
+		return a.handleCancelOrder(c)
 	default:
 		message := fmt.Sprintf("No handler for command: %+v", c)
 		return nil, errors.New(message)
@@ -135,6 +140,45 @@ func (a *Aggregate) handleDeliverOrder(c *DeliverOrderCommand) ([]eventsource.Ev
 	}, nil
 }
 
+func (a *Aggregate) handleRejectOrder(c *RejectOrderCommand) ([]eventsource.EventData, error) {
+
+	if a.Sequence == 0 {
+		return nil, fmt.Errorf("No order found with id %s.", c.OrderID)
+	}
+
+	// Rejections may be redelivered, don't emit the event twice
+	if a.Status == Rejected {
+		return nil, nil
+	}
+
+	if a.Status != Submitted {
+		return nil, fmt.Errorf("Cannot reject order with status: %s.", a.Status)
+	}
+
+	return []eventsource.EventData{
+		&OrderRejected{OrderID: c.OrderID, Reason: c.Reason},
+	}, nil
+}
+
+func (a *Aggregate) handleCancelOrder(c *CancelOrderCommand) ([]eventsource.EventData, error) {
+
+	if a.Sequence == 0 {
+		return nil, fmt.Errorf("No order found with id %s.", c.OrderID)
+	}
+
+	if a.Status == Cancelled {
+		return nil, nil
+	}
+
+	if a.Status != Submitted && a.Status != Approved {
+		return nil, fmt.Errorf("Cannot cancel order with status: %s.", a.Status)
+	}
+
+	return []eventsource.EventData{
+		&OrderCancelled{OrderID: c.OrderID, Reason: c.Reason, RefundDue: a.Status == Approved},
+	}, nil
+}
+
 func (a *Aggregate) ApplyEvent(event eventsource.Event) error {
 
 	switch e := event.Data.(type) {
@@ -152,6 +196,10 @@ func (a *Aggregate) ApplyEvent(event eventsource.Event) error {
 		a.Status = Approved
 	case *OrderDelivered:
 		a.Status = Delivered
+	case *OrderRejected:
+		a.Status = Rejected
+	case *OrderCancelled:
+		a.Status = Cancelled
 	default:
 		return fmt.Errorf("Unsupported event %T received in ApplyEvent handler of the Order Aggregate: %+v", e, e)
 	}
@@ -159,6 +207,28 @@ func (a *Aggregate) ApplyEvent(event eventsource.Event) error {
 	return nil
 }
 
+// SnapshotVersion returns the current version of the snapshot format
+func (a *Aggregate) SnapshotVersion() int {
+	return 1
+}
+
+// TakeSnapshot serializes the state of the Aggregate
+func (a *Aggregate) TakeSnapshot() (json.RawMessage, error) {
+	return json.Marshal(a)
+}
+
+// RestoreSnapshot restores the state of the Aggregate from a snapshot
+func (a *Aggregate) RestoreSnapshot(data json.RawMessage, version int) error {
+	switch version {
+	default:
+		err := json.Unmarshal(data, a)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // AggregateID returns the AggregtateID
 func (a *Aggregate) AggregateID() string {
 	return a.OrderID
@@ -168,3 +238,5 @@ func (a *Aggregate) AggregateID() string {
 func (a *Aggregate) Type() string {
 	return "OrderAggregate"
 }
+
+var _ eventsource.Snapshotter = (*Aggregate)(nil)
@@ -38,6 +38,16 @@ var deliverOrderCommand = &command.DeliverOrderCommand{
 	OrderID: "testOrderId",
 }
 
+var rejectOrderCommand = &command.RejectOrderCommand{
+	OrderID: "testOrderId",
+	Reason:  "Out of dough",
+}
+
+var cancelOrderCommand = &command.CancelOrderCommand{
+	OrderID: "testOrderId",
+	Reason:  "Driver could not find the address",
+}
+
 var updateOrderCommandNoUpdates = &command.UpdateOrderCommand{
 	OrderID:     "testOrderId",
 	Description: optional.NewString("Here is a description"),
@@ -72,6 +82,16 @@ var orderDeliveredEvent = &event.OrderDelivered{
 	OrderID: "testOrderId",
 }
 
+var orderRejectedEvent = &event.OrderRejected{
+	OrderID: "testOrderId",
+	Reason:  "Out of dough",
+}
+
+var orderCancelledEvent = &event.OrderCancelled{
+	OrderID: "testOrderId",
+	Reason:  "Driver could not find the address",
+}
+
 func TestAggregate_HandleCommand(t *testing.T) {
 
 	cases := eventsourcetest.HandleCommandTestCases{
@@ -216,6 +236,97 @@ func TestAggregate_HandleCommand(t *testing.T) {
 				orderDeliveredEvent,
 			},
 		},
+		{
+			Label:       "prevents rejections for nonexistent orders",
+			Given:       nil,
+			Command:     rejectOrderCommand,
+			ShouldError: true,
+		},
+		{
+			Label: "prevents rejections for approved orders",
+			Given: []eventsource.EventData{
+				orderStartedEvent,
+				orderSubmittedEvent,
+				orderApprovedEvent,
+			},
+			Command:     rejectOrderCommand,
+			ShouldError: true,
+		},
+		{
+			Label: "ignores repeated rejections",
+			Given: []eventsource.EventData{
+				orderStartedEvent,
+				orderSubmittedEvent,
+				orderRejectedEvent,
+			},
+			Command:  rejectOrderCommand,
+			Expected: nil,
+		},
+		{
+			Label: "correctly processes RejectOrderCommand",
+			Given: []eventsource.EventData{
+				orderStartedEvent,
+				orderSubmittedEvent,
+			},
+			Command: rejectOrderCommand,
+			Expected: []eventsource.EventData{
+				orderRejectedEvent,
+			},
+		},
+		{
+			Label:       "prevents cancellations for nonexistent orders",
+			Given:       nil,
+			Command:     cancelOrderCommand,
+			ShouldError: true,
+		},
+		{
+			Label: "prevents cancellations for delivered orders",
+			Given: []eventsource.EventData{
+				orderStartedEvent,
+				orderSubmittedEvent,
+				orderApprovedEvent,
+				orderDeliveredEvent,
+			},
+			Command:     cancelOrderCommand,
+			ShouldError: true,
+		},
+		{
+			Label: "ignores repeated cancellations",
+			Given: []eventsource.EventData{
+				orderStartedEvent,
+				orderSubmittedEvent,
+				orderCancelledEvent,
+			},
+			Command:  cancelOrderCommand,
+			Expected: nil,
+		},
+		{
+			Label: "correctly processes CancelOrderCommand for submitted orders",
+			Given: []eventsource.EventData{
+				orderStartedEvent,
+				orderSubmittedEvent,
+			},
+			Command: cancelOrderCommand,
+			Expected: []eventsource.EventData{
+				orderCancelledEvent,
+			},
+		},
+		{
+			Label: "marks a refund as due when cancelling approved orders",
+			Given: []eventsource.EventData{
+				orderStartedEvent,
+				orderSubmittedEvent,
+				orderApprovedEvent,
+			},
+			Command: cancelOrderCommand,
+			Expected: []eventsource.EventData{
+				&event.OrderCancelled{
+					OrderID:   "testOrderId",
+					Reason:    "Driver could not find the address",
+					RefundDue: true,
+				},
+			},
+		},
 	}
 
 	cases.Test(&Aggregate{}, t)
@@ -254,6 +365,31 @@ func TestAggregate_ApplyEvent(t *testing.T) {
 				Status:      model.Started,
 			},
 		},
+		{
+			Given: []eventsource.EventData{
+				orderStartedEvent,
+				orderSubmittedEvent,
+			},
+			Event: orderRejectedEvent,
+			Expected: &Aggregate{
+				ServiceType: model.Pickup,
+				Description: "Here is a description",
+				Status:      model.Rejected,
+			},
+		},
+		{
+			Given: []eventsource.EventData{
+				orderStartedEvent,
+				orderSubmittedEvent,
+				orderApprovedEvent,
+			},
+			Event: orderCancelledEvent,
+			Expected: &Aggregate{
+				ServiceType: model.Pickup,
+				Description: "Here is a description",
+				Status:      model.Cancelled,
+			},
+		},
 	}
 
 	for i, c := range cases {
@@ -272,3 +408,30 @@ func TestAggregate_InitAndAggregateID(t *testing.T) {
 		t.Error(diff)
 	}
 }
+
+func TestAggregate_Snapshot(t *testing.T) {
+	a := &Aggregate{
+		OrderID:     "testOrderId",
+		ServiceType: model.Delivery,
+		Description: "Here is a description",
+		Status:      model.Submitted,
+	}
+
+	data, err := a.TakeSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := &Aggregate{}
+	if err := got.RestoreSnapshot(data, a.SnapshotVersion()); err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := deep.Equal(got, a); diff != nil {
+		t.Error(diff)
+	}
+
+	if err := got.RestoreSnapshot([]byte(`{`), a.SnapshotVersion()); err == nil {
+		t.Error("Expected an error restoring an invalid snapshot")
+	}
+}
@@ -2,7 +2,7 @@ package command
 
 // ApproveOrderCommand attempts to submit the order for fulfillment
 type ApproveOrderCommand struct {
-	OrderID string
+	OrderID string `validate:"required"`
 }
 
 func (c *ApproveOrderCommand) AggregateID() string {
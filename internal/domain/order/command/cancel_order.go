@@ -0,0 +1,11 @@
+package command
+
+// CancelOrderCommand cancels an order which could not be fulfilled
+type CancelOrderCommand struct {
+	OrderID string `validate:"required"`
+	Reason  string
+}
+
+func (c *CancelOrderCommand) AggregateID() string {
+	return c.OrderID
+}
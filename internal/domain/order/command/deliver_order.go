@@ -2,7 +2,7 @@ package command
 
 // ApproveOrderCommand attempts to submit the order for fulfillment
 type DeliverOrderCommand struct {
-	OrderID string
+	OrderID string `validate:"required"`
 }
 
 func (c *DeliverOrderCommand) AggregateID() string {
@@ -0,0 +1,11 @@
+package command
+
+// RejectOrderCommand rejects a submitted order which the vendor system would not approve
+type RejectOrderCommand struct {
+	OrderID string `validate:"required"`
+	Reason  string
+}
+
+func (c *RejectOrderCommand) AggregateID() string {
+	return c.OrderID
+}
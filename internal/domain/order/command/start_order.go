@@ -4,8 +4,8 @@ import "forge.lmig.com/n1505471/pizza-shop/internal/domain/order/model"
 
 // StartOrderCommand starts an order
 type StartOrderCommand struct {
-	OrderID     string
-	ServiceType model.ServiceType
+	OrderID     string            `validate:"required"`
+	ServiceType model.ServiceType `validate:"required"`
 	Description string
 }
 
@@ -2,7 +2,7 @@ package command
 
 // SubmitOrderCommand attempts to submit the order for fulfillment
 type SubmitOrderCommand struct {
-	OrderID string
+	OrderID string `validate:"required"`
 }
 
 func (c *SubmitOrderCommand) AggregateID() string {
@@ -7,7 +7,7 @@ import (
 
 // UpdateOrderCommand allows updating multiple fields at once
 type UpdateOrderCommand struct {
-	OrderID     string
+	OrderID     string `validate:"required"`
 	Description optional.String
 	ServiceType model.OptionalServiceType
 }
@@ -0,0 +1,34 @@
+package event
+
+import (
+	"encoding/json"
+
+	"forge.lmig.com/n1505471/pizza-shop/eventsource"
+)
+
+func init() {
+	eventsource.RegisterEventType(&OrderCancelled{})
+}
+
+// OrderCancelled fired when an order cannot be fulfilled.  RefundDue is set when the order
+// had already been approved, so the customer is owed a refund.
+type OrderCancelled struct {
+	OrderID   string `json:"orderId"`
+	Reason    string `json:"reason"`
+	RefundDue bool   `json:"refundDue"`
+}
+
+func (e *OrderCancelled) Version() int {
+	return 1
+}
+
+func (e *OrderCancelled) Load(data json.RawMessage, version int) error {
+	switch version {
+	default:
+		err := json.Unmarshal(data, e)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package event
+
+import (
+	"testing"
+
+	"forge.lmig.com/n1505471/pizza-shop/eventsource/eventsourcetest"
+)
+
+func TestOrderCancelled_Load(t *testing.T) {
+	cases := eventsourcetest.EventLoadTestCases{
+		{
+			Label:   "correctly handles version 1 event",
+			Version: 1,
+			Event: `
+				{
+					"orderId":"84de2628-ac3b-4fcf-b2a1-05cf5b1b5743",
+					"reason":"Driver could not find the address",
+					"refundDue":true
+				}
+			`,
+			Expected: &OrderCancelled{
+				OrderID:   "84de2628-ac3b-4fcf-b2a1-05cf5b1b5743",
+				Reason:    "Driver could not find the address",
+				RefundDue: true,
+			},
+		},
+		{
+			Label:   "version 1 returns error with invalid json",
+			Version: 1,
+			Event: `
+				{
+					"orderId": 123
+				}
+			`,
+			Expected:    &OrderCancelled{},
+			ShouldError: true,
+		},
+	}
+
+	cases.Test(t)
+}
@@ -0,0 +1,32 @@
+package event
+
+import (
+	"encoding/json"
+
+	"forge.lmig.com/n1505471/pizza-shop/eventsource"
+)
+
+func init() {
+	eventsource.RegisterEventType(&OrderRejected{})
+}
+
+// OrderRejected fired when a submitted order is not approved
+type OrderRejected struct {
+	OrderID string `json:"orderId"`
+	Reason  string `json:"reason"`
+}
+
+func (e *OrderRejected) Version() int {
+	return 1
+}
+
+func (e *OrderRejected) Load(data json.RawMessage, version int) error {
+	switch version {
+	default:
+		err := json.Unmarshal(data, e)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package event
+
+import (
+	"testing"
+
+	"forge.lmig.com/n1505471/pizza-shop/eventsource/eventsourcetest"
+)
+
+func TestOrderRejected_Load(t *testing.T) {
+	cases := eventsourcetest.EventLoadTestCases{
+		{
+			Label:   "correctly handles version 1 event",
+			Version: 1,
+			Event: `
+				{
+					"orderId":"84de2628-ac3b-4fcf-b2a1-05cf5b1b5743",
+					"reason":"Out of dough"
+				}
+			`,
+			Expected: &OrderRejected{
+				OrderID: "84de2628-ac3b-4fcf-b2a1-05cf5b1b5743",
+				Reason:  "Out of dough",
+			},
+		},
+		{
+			Label:   "version 1 returns error with invalid json",
+			Version: 1,
+			Event: `
+				{
+					"orderId": 123
+				}
+			`,
+			Expected:    &OrderRejected{},
+			ShouldError: true,
+		},
+	}
+
+	cases.Test(t)
+}
@@ -9,6 +9,7 @@ import (
 
 func init() {
 	eventsource.RegisterEventType(&OrderStartedEvent{})
+	eventsource.RegisterUpcaster(&OrderStartedEvent{}, 1, upcastOrderStartedEventV1)
 }
 
 // OrderStartedEvent fired when an order is started
@@ -24,15 +25,6 @@ func (e *OrderStartedEvent) Version() int {
 
 func (e *OrderStartedEvent) Load(data json.RawMessage, version int) error {
 	switch version {
-	case 1:
-		v1 := OrderStartedEventV1{}
-		err := json.Unmarshal(data, &v1)
-		if err != nil {
-			return err
-		}
-		e.OrderID = v1.OrderID
-		e.ServiceType = model.ServiceType(v1.ServiceType)
-		e.Description = v1.Description
 	default:
 		err := json.Unmarshal(data, e)
 		if err != nil {
@@ -47,3 +39,16 @@ type OrderStartedEventV1 struct {
 	ServiceType int    `json:"serviceType"`
 	Description string `json:"description"`
 }
+
+// upcastOrderStartedEventV1 converts the numeric service type of version 1 to its name
+func upcastOrderStartedEventV1(data json.RawMessage) (json.RawMessage, error) {
+	v1 := OrderStartedEventV1{}
+	if err := json.Unmarshal(data, &v1); err != nil {
+		return nil, err
+	}
+	return json.Marshal(&OrderStartedEvent{
+		OrderID:     v1.OrderID,
+		ServiceType: model.ServiceType(v1.ServiceType),
+		Description: v1.Description,
+	})
+}
@@ -69,3 +69,61 @@ func TestOrderStartedEvent_Load(t *testing.T) {
 
 	cases.Test(t)
 }
+
+func TestOrderStartedEvent_Upcast(t *testing.T) {
+	cases := eventsourcetest.UpcastTestCases{
+		{
+			Label:     "upcasts version 1 to version 2",
+			EventType: &OrderStartedEvent{},
+			Version:   1,
+			Event: `
+				{
+					"orderId":"84de2628-ac3b-4fcf-b2a1-05cf5b1b5743",
+					"serviceType": 2,
+					"description": "a test!"
+				}
+			`,
+			Expected: `
+				{
+					"orderId":"84de2628-ac3b-4fcf-b2a1-05cf5b1b5743",
+					"serviceType": "Delivery",
+					"description": "a test!"
+				}
+			`,
+			ExpectedVersion: 2,
+		},
+		{
+			Label:     "leaves version 2 unchanged",
+			EventType: &OrderStartedEvent{},
+			Version:   2,
+			Event: `
+				{
+					"orderId":"84de2628-ac3b-4fcf-b2a1-05cf5b1b5743",
+					"serviceType": "Delivery",
+					"description": "a test!"
+				}
+			`,
+			Expected: `
+				{
+					"orderId":"84de2628-ac3b-4fcf-b2a1-05cf5b1b5743",
+					"serviceType": "Delivery",
+					"description": "a test!"
+				}
+			`,
+			ExpectedVersion: 2,
+		},
+		{
+			Label:     "returns error with invalid version 1 json",
+			EventType: &OrderStartedEvent{},
+			Version:   1,
+			Event: `
+				{
+					"serviceType": "test"
+				}
+			`,
+			ShouldError: true,
+		},
+	}
+
+	cases.Test(t)
+}
@@ -9,6 +9,7 @@ import (
 
 func init() {
 	eventsource.RegisterEventType(&OrderServiceTypeSetEvent{})
+	eventsource.RegisterUpcaster(&OrderServiceTypeSetEvent{}, 1, upcastOrderServiceTypeSetEventV1)
 }
 
 // OrderServiceTypeSetEvent fired when an order's service type is set
@@ -23,14 +24,6 @@ func (e *OrderServiceTypeSetEvent) Version() int {
 
 func (e *OrderServiceTypeSetEvent) Load(data json.RawMessage, version int) error {
 	switch version {
-	case 1:
-		v1 := OrderServiceTypeSetEventV1{}
-		err := json.Unmarshal(data, &v1)
-		if err != nil {
-			return err
-		}
-		e.OrderID = v1.OrderID
-		e.ServiceType = model.ServiceType(v1.ServiceType)
 	default:
 		err := json.Unmarshal(data, e)
 		if err != nil {
@@ -44,3 +37,15 @@ type OrderServiceTypeSetEventV1 struct {
 	OrderID     string `json:"orderId"`
 	ServiceType int    `json:"serviceType"`
 }
+
+// upcastOrderServiceTypeSetEventV1 converts the numeric service type of version 1 to its name
+func upcastOrderServiceTypeSetEventV1(data json.RawMessage) (json.RawMessage, error) {
+	v1 := OrderServiceTypeSetEventV1{}
+	if err := json.Unmarshal(data, &v1); err != nil {
+		return nil, err
+	}
+	return json.Marshal(&OrderServiceTypeSetEvent{
+		OrderID:     v1.OrderID,
+		ServiceType: model.ServiceType(v1.ServiceType),
+	})
+}
@@ -66,3 +66,57 @@ func TestOrderServiceTypeSetEvent_Load(t *testing.T) {
 
 	cases.Test(t)
 }
+
+func TestOrderServiceTypeSetEvent_Upcast(t *testing.T) {
+	cases := eventsourcetest.UpcastTestCases{
+		{
+			Label:     "upcasts version 1 to version 2",
+			EventType: &OrderServiceTypeSetEvent{},
+			Version:   1,
+			Event: `
+				{
+					"orderId":"84de2628-ac3b-4fcf-b2a1-05cf5b1b5743",
+					"serviceType": 2
+				}
+			`,
+			Expected: `
+				{
+					"orderId":"84de2628-ac3b-4fcf-b2a1-05cf5b1b5743",
+					"serviceType": "Delivery"
+				}
+			`,
+			ExpectedVersion: 2,
+		},
+		{
+			Label:     "leaves version 2 unchanged",
+			EventType: &OrderServiceTypeSetEvent{},
+			Version:   2,
+			Event: `
+				{
+					"orderId":"84de2628-ac3b-4fcf-b2a1-05cf5b1b5743",
+					"serviceType": "Delivery"
+				}
+			`,
+			Expected: `
+				{
+					"orderId":"84de2628-ac3b-4fcf-b2a1-05cf5b1b5743",
+					"serviceType": "Delivery"
+				}
+			`,
+			ExpectedVersion: 2,
+		},
+		{
+			Label:     "returns error with invalid version 1 json",
+			EventType: &OrderServiceTypeSetEvent{},
+			Version:   1,
+			Event: `
+				{
+					"serviceType": "test"
+				}
+			`,
+			ShouldError: true,
+		},
+	}
+
+	cases.Test(t)
+}
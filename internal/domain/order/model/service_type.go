@@ -1,5 +1,7 @@
 package model
 
+import "fmt"
+
 // ServiceType is the type of order, e.g. pickup or delivery
 type ServiceType int
 
@@ -15,3 +17,12 @@ func (r ServiceType) String() string {
 
 //go:generate jsonenums -type=ServiceType
 //go:generate optional -type=ServiceType
+
+// ParseServiceType returns the ServiceType with the given name, e.g. "Pickup"
+func ParseServiceType(name string) (ServiceType, error) {
+	v, ok := _ServiceTypeNameToValue[name]
+	if !ok {
+		return 0, fmt.Errorf("invalid ServiceType %q", name)
+	}
+	return v, nil
+}
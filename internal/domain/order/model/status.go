@@ -1,5 +1,7 @@
 package model
 
+import "fmt"
+
 // ServiceType is the type of order, e.g. pickup or delivery
 type Status int
 
@@ -9,6 +11,8 @@ const (
 	Submitted
 	Approved
 	Delivered
+	Rejected
+	Cancelled
 )
 
 func (r Status) String() string {
@@ -16,3 +20,12 @@ func (r Status) String() string {
 }
 
 //go:generate jsonenums -type=Status
+
+// ParseStatus returns the Status with the given name, e.g. "Submitted"
+func ParseStatus(name string) (Status, error) {
+	v, ok := _StatusNameToValue[name]
+	if !ok {
+		return 0, fmt.Errorf("invalid Status %q", name)
+	}
+	return v, nil
+}
@@ -16,6 +16,8 @@ var (
 		"Submitted": Submitted,
 		"Approved":  Approved,
 		"Delivered": Delivered,
+		"Rejected":  Rejected,
+		"Cancelled": Cancelled,
 	}
 
 	_StatusValueToName = map[Status]string{
@@ -23,6 +25,8 @@ var (
 		Submitted: "Submitted",
 		Approved:  "Approved",
 		Delivered: "Delivered",
+		Rejected:  "Rejected",
+		Cancelled: "Cancelled",
 	}
 )
 
@@ -34,6 +38,8 @@ func init() {
 			interface{}(Submitted).(fmt.Stringer).String(): Submitted,
 			interface{}(Approved).(fmt.Stringer).String():  Approved,
 			interface{}(Delivered).(fmt.Stringer).String(): Delivered,
+			interface{}(Rejected).(fmt.Stringer).String():  Rejected,
+			interface{}(Cancelled).(fmt.Stringer).String(): Cancelled,
 		}
 	}
 }
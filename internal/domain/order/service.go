@@ -1,6 +1,7 @@
 package order
 
 import (
+	"context"
 	"fmt"
 
 	"forge.lmig.com/n1505471/pizza-shop/eventsource"
@@ -10,19 +11,21 @@ import (
 )
 
 type ServiceAPI interface {
-	StartOrder(order *model.Order) (string, error)
-	UpdateOrder(order *model.OrderPatch) error
-	SubmitOrder(orderID string) error
-	ApproveOrder(orderID string) error
-	DeliverOrder(orderID string) error
+	StartOrder(ctx context.Context, order *model.Order) (string, error)
+	UpdateOrder(ctx context.Context, order *model.OrderPatch) error
+	SubmitOrder(ctx context.Context, orderID string) error
+	ApproveOrder(ctx context.Context, orderID string) error
+	DeliverOrder(ctx context.Context, orderID string) error
+	RejectOrder(ctx context.Context, orderID string, reason string) error
+	CancelOrder(ctx context.Context, orderID string, reason string) error
 }
 
 type Service struct {
 	eventSource eventsource.EventSourceAPI
 }
 
-func (s *Service) processCommand(c eventsource.Command) error {
-	return s.eventSource.ProcessCommand(c, &Aggregate{})
+func (s *Service) processCommand(ctx context.Context, c eventsource.Command) error {
+	return s.eventSource.ProcessCommand(ctx, c, &Aggregate{})
 }
 
 func NewService(eventSource eventsource.EventSourceAPI) *Service {
@@ -31,7 +34,7 @@ func NewService(eventSource eventsource.EventSourceAPI) *Service {
 	}
 }
 
-func (s *Service) StartOrder(order *model.Order) (string, error) {
+func (s *Service) StartOrder(ctx context.Context, order *model.Order) (string, error) {
 	if order.OrderID == "" {
 		order.OrderID = uuid.New().String()
 	}
@@ -42,14 +45,14 @@ func (s *Service) StartOrder(order *model.Order) (string, error) {
 		Description: order.Description,
 	}
 
-	if err := s.processCommand(c); err != nil {
+	if err := s.processCommand(ctx, c); err != nil {
 		return "", err
 	}
 
 	return c.OrderID, nil
 }
 
-func (s *Service) UpdateOrder(order *model.OrderPatch) error {
+func (s *Service) UpdateOrder(ctx context.Context, order *model.OrderPatch) error {
 	if order.OrderID == "" {
 		return fmt.Errorf("OrderID must be provided to update operation.")
 	}
@@ -60,43 +63,69 @@ func (s *Service) UpdateOrder(order *model.OrderPatch) error {
 		Description: order.Description,
 	}
 
-	if err := s.processCommand(c); err != nil {
+	if err := s.processCommand(ctx, c); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (s *Service) SubmitOrder(orderID string) error {
+func (s *Service) SubmitOrder(ctx context.Context, orderID string) error {
 	c := &command.SubmitOrderCommand{
 		OrderID: orderID,
 	}
 
-	if err := s.processCommand(c); err != nil {
+	if err := s.processCommand(ctx, c); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (s *Service) ApproveOrder(orderID string) error {
+func (s *Service) ApproveOrder(ctx context.Context, orderID string) error {
 	c := &command.ApproveOrderCommand{
 		OrderID: orderID,
 	}
 
-	if err := s.processCommand(c); err != nil {
+	if err := s.processCommand(ctx, c); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (s *Service) DeliverOrder(orderID string) error {
+func (s *Service) DeliverOrder(ctx context.Context, orderID string) error {
 	c := &command.DeliverOrderCommand{
 		OrderID: orderID,
 	}
 
-	if err := s.processCommand(c); err != nil {
+	if err := s.processCommand(ctx, c); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *Service) RejectOrder(ctx context.Context, orderID string, reason string) error {
+	c := &command.RejectOrderCommand{
+		OrderID: orderID,
+		Reason:  reason,
+	}
+
+	if err := s.processCommand(ctx, c); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *Service) CancelOrder(ctx context.Context, orderID string, reason string) error {
+	c := &command.CancelOrderCommand{
+		OrderID: orderID,
+		Reason:  reason,
+	}
+
+	if err := s.processCommand(ctx, c); err != nil {
 		return err
 	}
 
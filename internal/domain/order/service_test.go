@@ -1,9 +1,11 @@
 package order
 
 import (
+	"context"
 	"fmt"
 	"testing"
 
+	"github.com/go-playground/validator/v10"
 	"github.com/go-test/deep"
 	"github.com/markphelps/optional"
 
@@ -12,6 +14,7 @@ import (
 	"forge.lmig.com/n1505471/pizza-shop/internal/domain/order/model"
 
 	"forge.lmig.com/n1505471/pizza-shop/eventsource"
+	"forge.lmig.com/n1505471/pizza-shop/eventsource/store/memory"
 )
 
 func TestService_StartOrder(t *testing.T) {
@@ -71,7 +74,7 @@ func TestService_StartOrder(t *testing.T) {
 			shouldError: c.ShouldError,
 		})
 
-		_, err := s.StartOrder(c.Order)
+		_, err := s.StartOrder(context.Background(), c.Order)
 		if c.ShouldError && err == nil {
 			t.Errorf("Cases[%d] FAILED: %s, expected an error.", i, c.Label)
 			continue
@@ -140,7 +143,7 @@ func TestService_UpdateOrder(t *testing.T) {
 			shouldError: c.ShouldError,
 		})
 
-		err := s.UpdateOrder(c.Order)
+		err := s.UpdateOrder(context.Background(), c.Order)
 		if c.ShouldError && err == nil {
 			t.Errorf("Cases[%d] FAILED: %s, expected an error.", i, c.Label)
 			continue
@@ -189,7 +192,7 @@ func TestService_SubmitOrder(t *testing.T) {
 			shouldError: c.ShouldError,
 		})
 
-		err := s.SubmitOrder("testOrderId")
+		err := s.SubmitOrder(context.Background(), "testOrderId")
 		if c.ShouldError && err == nil {
 			t.Errorf("Cases[%d] FAILED: %s, expected an error.", i, c.Label)
 			continue
@@ -238,7 +241,7 @@ func TestService_ApproveOrder(t *testing.T) {
 			shouldError: c.ShouldError,
 		})
 
-		err := s.ApproveOrder("testOrderId")
+		err := s.ApproveOrder(context.Background(), "testOrderId")
 		if c.ShouldError && err == nil {
 			t.Errorf("Cases[%d] FAILED: %s, expected an error.", i, c.Label)
 			continue
@@ -287,7 +290,7 @@ func TestService_DeliverOrder(t *testing.T) {
 			shouldError: c.ShouldError,
 		})
 
-		err := s.DeliverOrder("testOrderId")
+		err := s.DeliverOrder(context.Background(), "testOrderId")
 		if c.ShouldError && err == nil {
 			t.Errorf("Cases[%d] FAILED: %s, expected an error.", i, c.Label)
 			continue
@@ -299,6 +302,168 @@ func TestService_DeliverOrder(t *testing.T) {
 	}
 }
 
+func TestService_RejectOrder(t *testing.T) {
+	cases := []struct {
+		Label       string
+		Check       Condition
+		ShouldError bool
+	}{
+		{
+			Label: "Should correctly issue the RejectOrderCommand command",
+			Check: func(c eventsource.Command) error {
+				cmd, ok := c.(*command.RejectOrderCommand)
+				if !ok {
+					return fmt.Errorf("Expected %T, got %T", &command.RejectOrderCommand{}, c)
+				}
+				if cmd.OrderID != "testOrderId" {
+					return fmt.Errorf("Expected `%s` for OrderID, got `%s`", "testOrderId", cmd.OrderID)
+				}
+				if cmd.Reason != "Out of dough" {
+					return fmt.Errorf("Expected `%s` for Reason, got `%s`", "Out of dough", cmd.Reason)
+				}
+				return nil
+			},
+		},
+		{
+			Label: "Should bubble up errors",
+			Check: func(c eventsource.Command) error {
+				return nil
+			},
+			ShouldError: true,
+		},
+	}
+
+	for i, c := range cases {
+		s := NewService(&mockEventSource{
+			check:       c.Check,
+			shouldError: c.ShouldError,
+		})
+
+		err := s.RejectOrder(context.Background(), "testOrderId", "Out of dough")
+		if c.ShouldError && err == nil {
+			t.Errorf("Cases[%d] FAILED: %s, expected an error.", i, c.Label)
+			continue
+		}
+
+		if !c.ShouldError && err != nil {
+			t.Errorf("Cases[%d] FAILED: %s.  Error: %s", i, c.Label, err)
+		}
+	}
+}
+
+func TestService_CancelOrder(t *testing.T) {
+	cases := []struct {
+		Label       string
+		Check       Condition
+		ShouldError bool
+	}{
+		{
+			Label: "Should correctly issue the CancelOrderCommand command",
+			Check: func(c eventsource.Command) error {
+				cmd, ok := c.(*command.CancelOrderCommand)
+				if !ok {
+					return fmt.Errorf("Expected %T, got %T", &command.CancelOrderCommand{}, c)
+				}
+				if cmd.OrderID != "testOrderId" {
+					return fmt.Errorf("Expected `%s` for OrderID, got `%s`", "testOrderId", cmd.OrderID)
+				}
+				if cmd.Reason != "Out of dough" {
+					return fmt.Errorf("Expected `%s` for Reason, got `%s`", "Out of dough", cmd.Reason)
+				}
+				return nil
+			},
+		},
+		{
+			Label: "Should bubble up errors",
+			Check: func(c eventsource.Command) error {
+				return nil
+			},
+			ShouldError: true,
+		},
+	}
+
+	for i, c := range cases {
+		s := NewService(&mockEventSource{
+			check:       c.Check,
+			shouldError: c.ShouldError,
+		})
+
+		err := s.CancelOrder(context.Background(), "testOrderId", "Out of dough")
+		if c.ShouldError && err == nil {
+			t.Errorf("Cases[%d] FAILED: %s, expected an error.", i, c.Label)
+			continue
+		}
+
+		if !c.ShouldError && err != nil {
+			t.Errorf("Cases[%d] FAILED: %s.  Error: %s", i, c.Label, err)
+		}
+	}
+}
+
+func TestService_RoundTrip(t *testing.T) {
+	es := eventsource.New(memory.New(), eventsource.WithMiddleware(eventsource.ValidateCommands(validator.New())))
+	s := NewService(es)
+
+	orderID, err := s.StartOrder(context.Background(), &model.Order{
+		ServiceType: model.Pickup,
+		Description: "I'm a test!",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []func() error{
+		func() error {
+			return s.UpdateOrder(context.Background(), &model.OrderPatch{
+				OrderID:     orderID,
+				ServiceType: model.NewOptionalServiceType(model.Delivery),
+				Description: optional.NewString("I'm a new test!"),
+			})
+		},
+		func() error { return s.SubmitOrder(context.Background(), orderID) },
+		func() error { return s.ApproveOrder(context.Background(), orderID) },
+		func() error { return s.DeliverOrder(context.Background(), orderID) },
+	}
+	for i, step := range steps {
+		if err := step(); err != nil {
+			t.Fatalf("Steps[%d] FAILED.  Error: %s", i, err)
+		}
+	}
+
+	a := &Aggregate{}
+	a.Init(orderID)
+	if err := es.LoadAggregate(context.Background(), a); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := &Aggregate{
+		AggregateBase: eventsource.AggregateBase{Sequence: 6},
+		OrderID:       orderID,
+		ServiceType:   model.Delivery,
+		Description:   "I'm a new test!",
+		Status:        model.Delivered,
+	}
+	if diff := deep.Equal(a, expected); diff != nil {
+		t.Error(diff)
+	}
+
+	if err := s.SubmitOrder(context.Background(), orderID); err == nil {
+		t.Errorf("Expected an error when submitting a delivered order.")
+	}
+}
+
+func TestService_StartOrder_Validation(t *testing.T) {
+	es := eventsource.New(memory.New(), eventsource.WithMiddleware(eventsource.ValidateCommands(validator.New())))
+	s := NewService(es)
+
+	_, err := s.StartOrder(context.Background(), &model.Order{
+		Description: "I'm a test!",
+	})
+	if _, ok := err.(*eventsource.CommandValidationError); !ok {
+		t.Errorf("Expected a CommandValidationError for an order without a ServiceType, got: %v", err)
+	}
+}
+
 type Condition func(c eventsource.Command) error
 
 type mockEventSource struct {
@@ -307,7 +472,7 @@ type mockEventSource struct {
 	shouldError bool
 }
 
-func (m *mockEventSource) ProcessCommand(got eventsource.Command, a eventsource.Aggregate) error {
+func (m *mockEventSource) ProcessCommand(_ context.Context, got eventsource.Command, a eventsource.Aggregate) error {
 
 	if m.shouldError {
 		return fmt.Errorf("Expecting an error here.")
@@ -1,6 +1,7 @@
 package order
 
 import (
+	"context"
 	"log"
 
 	"forge.lmig.com/n1505471/pizza-shop/internal/domain/order/model"
@@ -22,21 +23,25 @@ func NewProjection(repo repository.Interface) *Projection {
 	}
 }
 
-func (p *Projection) HandleEvent(e es.Event) error {
+func (p *Projection) HandleEvent(ctx context.Context, e es.Event) error {
 	log.Printf("Handling %T.\n", e)
 	switch d := e.Data.(type) {
 	case *event.OrderStartedEvent:
-		return p.handleOrderStartedEvent(d, e)
+		return p.handleOrderStartedEvent(ctx, d, e)
 	case *event.OrderServiceTypeSetEvent:
-		return p.handleServiceTypeSetEvent(d, e)
+		return p.handleServiceTypeSetEvent(ctx, d, e)
 	case *event.OrderDescriptionSet:
-		return p.handleDescriptionSetEvent(d, e)
+		return p.handleDescriptionSetEvent(ctx, d, e)
 	case *event.OrderSubmitted:
-		return p.handleSubmittedEvent(d, e)
+		return p.handleSubmittedEvent(ctx, d, e)
 	case *event.OrderApproved:
-		return p.handleApprovedEvent(d, e)
+		return p.handleApprovedEvent(ctx, d, e)
 	case *event.OrderDelivered:
-		return p.handleDeliveredEvent(d, e)
+		return p.handleDeliveredEvent(ctx, d, e)
+	case *event.OrderRejected:
+		return p.handleRejectedEvent(ctx, d, e)
+	case *event.OrderCancelled:
+		return p.handleCancelledEvent(ctx, d, e)
 	default:
 		log.Printf("Unsupported event %T received in handler of the Order Projection: %+v", d, e)
 		return nil
@@ -47,8 +52,8 @@ func (p *Projection) HandleEvent(e es.Event) error {
  * Event Handlers
  */
 
-func (p *Projection) handleOrderStartedEvent(d *event.OrderStartedEvent, e es.Event) error {
-	return p.repo.Save(&Order{
+func (p *Projection) handleOrderStartedEvent(ctx context.Context, d *event.OrderStartedEvent, e es.Event) error {
+	return p.repo.Save(ctx, &Order{
 		OrderID:     d.OrderID,
 		ServiceType: d.ServiceType,
 		Description: d.Description,
@@ -58,41 +63,57 @@ func (p *Projection) handleOrderStartedEvent(d *event.OrderStartedEvent, e es.Ev
 	})
 }
 
-func (p *Projection) handleServiceTypeSetEvent(d *event.OrderServiceTypeSetEvent, e es.Event) error {
-	return p.repo.Patch(d.OrderID, &Order{
+func (p *Projection) handleServiceTypeSetEvent(ctx context.Context, d *event.OrderServiceTypeSetEvent, e es.Event) error {
+	return p.repo.Patch(ctx, d.OrderID, &Order{
 		ServiceType: d.ServiceType,
 		UpdatedAt:   &e.Timestamp,
 	})
 }
 
-func (p *Projection) handleDescriptionSetEvent(d *event.OrderDescriptionSet, e es.Event) error {
+func (p *Projection) handleDescriptionSetEvent(ctx context.Context, d *event.OrderDescriptionSet, e es.Event) error {
 
-	return p.repo.Patch(d.OrderID, &Order{
+	return p.repo.Patch(ctx, d.OrderID, &Order{
 		Description: d.Description,
 		UpdatedAt:   &e.Timestamp,
 	})
 }
 
-func (p *Projection) handleSubmittedEvent(d *event.OrderSubmitted, e es.Event) error {
+func (p *Projection) handleSubmittedEvent(ctx context.Context, d *event.OrderSubmitted, e es.Event) error {
 
-	return p.repo.Patch(d.OrderID, &Order{
+	return p.repo.Patch(ctx, d.OrderID, &Order{
 		Status:    model.Submitted,
 		UpdatedAt: &e.Timestamp,
 	})
 }
 
-func (p *Projection) handleApprovedEvent(d *event.OrderApproved, e es.Event) error {
+func (p *Projection) handleApprovedEvent(ctx context.Context, d *event.OrderApproved, e es.Event) error {
 
-	return p.repo.Patch(d.OrderID, &Order{
+	return p.repo.Patch(ctx, d.OrderID, &Order{
 		Status:    model.Approved,
 		UpdatedAt: &e.Timestamp,
 	})
 }
 
-func (p *Projection) handleDeliveredEvent(d *event.OrderDelivered, e es.Event) error {
+func (p *Projection) handleDeliveredEvent(ctx context.Context, d *event.OrderDelivered, e es.Event) error {
 
-	return p.repo.Patch(d.OrderID, &Order{
+	return p.repo.Patch(ctx, d.OrderID, &Order{
 		Status:    model.Delivered,
 		UpdatedAt: &e.Timestamp,
 	})
 }
+
+func (p *Projection) handleRejectedEvent(ctx context.Context, d *event.OrderRejected, e es.Event) error {
+
+	return p.repo.Patch(ctx, d.OrderID, &Order{
+		Status:    model.Rejected,
+		UpdatedAt: &e.Timestamp,
+	})
+}
+
+func (p *Projection) handleCancelledEvent(ctx context.Context, d *event.OrderCancelled, e es.Event) error {
+
+	return p.repo.Patch(ctx, d.OrderID, &Order{
+		Status:    model.Cancelled,
+		UpdatedAt: &e.Timestamp,
+	})
+}
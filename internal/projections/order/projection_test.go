@@ -1,6 +1,7 @@
 package order
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -53,6 +54,17 @@ var deliveredEvent = eventsource.NewEvent(orderAgg, &event.OrderDelivered{
 	OrderID: "testOrderId",
 })
 
+var rejectedEvent = eventsource.NewEvent(orderAgg, &event.OrderRejected{
+	OrderID: "testOrderId",
+	Reason:  "Out of dough",
+})
+
+var cancelledEvent = eventsource.NewEvent(orderAgg, &event.OrderCancelled{
+	OrderID:   "testOrderId",
+	Reason:    "Driver could not find the address",
+	RefundDue: true,
+})
+
 func TestProjection_ApplyEvent(t *testing.T) {
 	cases := []struct {
 		Event    eventsource.Event
@@ -110,13 +122,29 @@ func TestProjection_ApplyEvent(t *testing.T) {
 				UpdatedAt: &deliveredEvent.Timestamp,
 			},
 		},
+		{
+			Event: rejectedEvent,
+			Expected: &Order{
+				OrderID:   "testOrderId",
+				Status:    model.Rejected,
+				UpdatedAt: &rejectedEvent.Timestamp,
+			},
+		},
+		{
+			Event: cancelledEvent,
+			Expected: &Order{
+				OrderID:   "testOrderId",
+				Status:    model.Cancelled,
+				UpdatedAt: &cancelledEvent.Timestamp,
+			},
+		},
 	}
 
 	for i, c := range cases {
 		p.repo = &mockRepo{
 			expected: c.Expected,
 		}
-		if err := p.HandleEvent(c.Event); err != nil {
+		if err := p.HandleEvent(context.Background(), c.Event); err != nil {
 			t.Errorf("Cases[%d]: %s", i, err)
 		}
 	}
@@ -126,14 +154,14 @@ type mockRepo struct {
 	expected *Order
 }
 
-func (m *mockRepo) Save(got *Order) error {
+func (m *mockRepo) Save(_ context.Context, got *Order) error {
 	if diff := deep.Equal(got, m.expected); diff != nil {
 		return fmt.Errorf("%s", diff)
 	}
 	return nil
 }
 
-func (m *mockRepo) Patch(orderID string, got *Order) error {
+func (m *mockRepo) Patch(_ context.Context, orderID string, got *Order) error {
 	if orderID != m.expected.OrderID {
 		return fmt.Errorf("Expected %s, got %s for OrderID in Patch operation.", m.expected.OrderID, orderID)
 	}
@@ -1,22 +1,28 @@
 package repository
 
 import (
+	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"log"
 	"sort"
+	"strconv"
 	"strings"
 
+	"forge.lmig.com/n1505471/pizza-shop/eventsource"
+	"forge.lmig.com/n1505471/pizza-shop/internal/domain/order/model"
 	. "forge.lmig.com/n1505471/pizza-shop/internal/projections/order/model"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
 )
 
 type Interface interface {
-	Save(order *Order) error
-	Patch(orderID string, updates *Order) error
+	Save(ctx context.Context, order *Order) error
+	Patch(ctx context.Context, orderID string, updates *Order) error
 }
 
 // The Repository provides a way to persist and retrieve entities from permanent storage
@@ -36,20 +42,21 @@ func NewRepository(db dynamodbiface.DynamoDBAPI, tableName string) *Repository {
  * Write Handlers
  */
 
-func (r *Repository) Save(order *Order) error {
+func (r *Repository) Save(ctx context.Context, order *Order) error {
 	av, err := dynamodbattribute.MarshalMap(order)
 	if err != nil {
 		return err
 	}
+	av[entityTypeAttribute] = &dynamodb.AttributeValue{S: aws.String(orderEntityType)}
 
-	_, err = r.db.PutItem(&dynamodb.PutItemInput{
+	_, err = r.db.PutItemWithContext(ctx, &dynamodb.PutItemInput{
 		TableName: r.tableName,
 		Item:      av,
 	})
 	return err
 }
 
-func (r *Repository) Patch(orderID string, order *Order) error {
+func (r *Repository) Patch(ctx context.Context, orderID string, order *Order) error {
 
 	// Convert order to updates map with correct keys
 	var updates map[string]interface{}
@@ -97,39 +104,224 @@ func (r *Repository) Patch(orderID string, order *Order) error {
 
 	log.Printf("Update item request: %+v", i)
 
-	if _, err := r.db.UpdateItem(i); err != nil {
+	if _, err := r.db.UpdateItemWithContext(ctx, i); err != nil {
 		return err
 	}
 	return nil
 }
 
 /*
- * Query Handlers
+ * Checkpoints
  */
 
-// QueryAllOrders retrieves a list of all orders, sorted in random order
-func (r *Repository) QueryAllOrders() ([]*Order, error) {
+// lastSequenceAttribute holds the projection checkpoint on each order item
+const lastSequenceAttribute = "lastSequence"
+
+// LastSequence returns the last event sequence applied to the order, stored alongside the order itself
+func (r *Repository) LastSequence(ctx context.Context, orderID string) (int, error) {
+	result, err := r.db.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"orderId": {S: aws.String(orderID)},
+		},
+		ProjectionExpression: aws.String("#lastSequence"),
+		ExpressionAttributeNames: map[string]*string{
+			"#lastSequence": aws.String(lastSequenceAttribute),
+		},
+		ConsistentRead: aws.Bool(true),
+		TableName:      r.tableName,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	checkpoint := struct {
+		LastSequence int `json:"lastSequence"`
+	}{}
+	if err := dynamodbattribute.UnmarshalMap(result.Item, &checkpoint); err != nil {
+		return 0, err
+	}
 
-	result, err := r.db.Scan(&dynamodb.ScanInput{
+	return checkpoint.LastSequence, nil
+}
+
+// SaveSequence records the last event sequence applied to the order, never moving it backwards
+func (r *Repository) SaveSequence(ctx context.Context, orderID string, sequence int) error {
+	_, err := r.db.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
 		TableName: r.tableName,
+		Key: map[string]*dynamodb.AttributeValue{
+			"orderId": {S: aws.String(orderID)},
+		},
+		ExpressionAttributeNames: map[string]*string{
+			"#lastSequence": aws.String(lastSequenceAttribute),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":lastSequence": {N: aws.String(strconv.Itoa(sequence))},
+		},
+		UpdateExpression:    aws.String("SET #lastSequence = :lastSequence"),
+		ConditionExpression: aws.String("attribute_not_exists(#lastSequence) OR #lastSequence < :lastSequence"),
 	})
 	if err != nil {
-		return nil, err
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case dynamodb.ErrCodeConditionalCheckFailedException:
+				// A later event has already been applied
+				return nil
+			}
+		}
+	}
+	return err
+}
+
+/*
+ * Query Handlers
+ */
+
+// Attributes orders can be sorted by
+const (
+	SortByCreatedAt = "createdAt"
+	SortByUpdatedAt = "updatedAt"
+)
+
+// entityTypeAttribute is set on every saved order, giving the indexes used to list
+// orders without a filter a single partition to query.  Orders saved before it was
+// added only show up in those listings once the projection is replayed.
+const (
+	entityTypeAttribute = "entityType"
+	orderEntityType     = "Order"
+)
+
+// OrderQuery filters, sorts and pages through orders.  Zero values are ignored.
+type OrderQuery struct {
+	Status      model.Status
+	ServiceType model.ServiceType
+
+	// SortBy is SortByCreatedAt or SortByUpdatedAt, defaulting to SortByCreatedAt
+	SortBy     string
+	Descending bool
+
+	// Limit caps the number of orders read per page.  When filtering on both status and
+	// service type, pages can hold fewer orders than the limit and still have a cursor.
+	Limit  int64
+	Cursor string
+}
+
+// OrderPage is a page of orders, with the cursor for the next page or empty on the last page
+type OrderPage struct {
+	Orders []*Order
+	Cursor string
+}
+
+// InvalidQueryError is returned when an OrderQuery can't be run, e.g. for a malformed cursor
+type InvalidQueryError struct {
+	Message string
+}
+
+func (e *InvalidQueryError) Error() string {
+	return e.Message
+}
+
+// QueryOrders retrieves a page of orders from the index partitioned by the first filter and
+// sorted by the requested attribute.  Unfiltered queries use the entityType partition.
+func (r *Repository) QueryOrders(ctx context.Context, q *OrderQuery) (*OrderPage, error) {
+	sortBy := q.SortBy
+	if sortBy == "" {
+		sortBy = SortByCreatedAt
+	}
+	if sortBy != SortByCreatedAt && sortBy != SortByUpdatedAt {
+		return nil, &InvalidQueryError{Message: fmt.Sprintf("Orders cannot be sorted by %s", sortBy)}
+	}
+
+	names := make(map[string]*string)
+	values := make(map[string]*dynamodb.AttributeValue)
+	var attributes []string
+	var conditions []string
+	addCondition := func(attribute string, value string) {
+		names["#"+attribute] = aws.String(attribute)
+		values[":"+attribute] = &dynamodb.AttributeValue{S: aws.String(value)}
+		attributes = append(attributes, attribute)
+		conditions = append(conditions, fmt.Sprintf("#%s = :%s", attribute, attribute))
+	}
+	if q.Status > 0 {
+		addCondition("status", q.Status.String())
+	}
+	if q.ServiceType > 0 {
+		addCondition("serviceType", q.ServiceType.String())
+	}
+	if len(conditions) == 0 {
+		addCondition(entityTypeAttribute, orderEntityType)
+	}
+
+	input := &dynamodb.QueryInput{
+		TableName:                 r.tableName,
+		IndexName:                 aws.String(indexName(attributes[0], sortBy)),
+		KeyConditionExpression:    aws.String(conditions[0]),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+		ScanIndexForward:          aws.Bool(!q.Descending),
+	}
+	if len(conditions) > 1 {
+		input.FilterExpression = aws.String(strings.Join(conditions[1:], " AND "))
+	}
+	if q.Limit > 0 {
+		input.Limit = aws.Int64(q.Limit)
+	}
+	if q.Cursor != "" {
+		key, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		input.ExclusiveStartKey = key
 	}
 
-	log.Printf("Raw Orders: %+v", result.Items)
+	result, err := r.db.QueryWithContext(ctx, input)
+	if err != nil {
+		return nil, err
+	}
 
 	orders := []*Order{}
 	if err := dynamodbattribute.UnmarshalListOfMaps(result.Items, &orders); err != nil {
 		return nil, err
 	}
 
+	cursor, err := encodeCursor(result.LastEvaluatedKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OrderPage{
+		Orders: orders,
+		Cursor: cursor,
+	}, nil
+}
+
+// QueryAllOrders retrieves a list of all orders, sorted in random order
+func (r *Repository) QueryAllOrders(ctx context.Context) ([]*Order, error) {
+
+	orders := []*Order{}
+	var pageErr error
+	err := r.db.ScanPagesWithContext(ctx, &dynamodb.ScanInput{
+		TableName: r.tableName,
+	}, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		var pageOrders []*Order
+		if pageErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &pageOrders); pageErr != nil {
+			return false
+		}
+		orders = append(orders, pageOrders...)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if pageErr != nil {
+		return nil, pageErr
+	}
+
 	return orders, nil
 }
 
-func (r *Repository) GetOrder(orderID string) (*Order, error) {
+func (r *Repository) GetOrder(ctx context.Context, orderID string) (*Order, error) {
 
-	result, err := r.db.GetItem(&dynamodb.GetItemInput{
+	result, err := r.db.GetItemWithContext(ctx, &dynamodb.GetItemInput{
 		Key: map[string]*dynamodb.AttributeValue{
 			"orderId": {
 				S: aws.String(orderID),
@@ -178,6 +370,41 @@ func patchHelper(update interface{}, path string, vals map[string]*dynamodb.Attr
 	return nil
 }
 
+// indexName is the name of the GSI partitioned by the attribute and sorted by sortBy
+func indexName(attribute string, sortBy string) string {
+	return fmt.Sprintf("%s-%s-index", attribute, sortBy)
+}
+
+// encodeCursor turns the last evaluated key into an opaque token, empty on the last page
+func encodeCursor(key map[string]*dynamodb.AttributeValue) (string, error) {
+	if len(key) == 0 {
+		return "", nil
+	}
+	var values map[string]interface{}
+	if err := dynamodbattribute.UnmarshalMap(key, &values); err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(values)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeCursor(cursor string) (map[string]*dynamodb.AttributeValue, error) {
+	invalid := &InvalidQueryError{Message: fmt.Sprintf("Invalid cursor: %s", cursor)}
+
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, invalid
+	}
+	var values map[string]interface{}
+	if err := json.Unmarshal(data, &values); err != nil || len(values) == 0 {
+		return nil, invalid
+	}
+	return dynamodbattribute.MarshalMap(values)
+}
+
 func sortedKeys(m map[string]*dynamodb.AttributeValue) []string {
 	keys := make([]string, len(m))
 	i := 0
@@ -188,3 +415,5 @@ func sortedKeys(m map[string]*dynamodb.AttributeValue) []string {
 	sort.Strings(keys)
 	return keys
 }
+
+var _ eventsource.CheckpointStore = (*Repository)(nil)
@@ -1,6 +1,7 @@
 package repository
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -10,6 +11,8 @@ import (
 	"forge.lmig.com/n1505471/pizza-shop/internal/domain/order/model"
 	. "forge.lmig.com/n1505471/pizza-shop/internal/projections/order/model"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
 	"github.com/go-test/deep"
@@ -89,18 +92,239 @@ func TestRepository_Patch(t *testing.T) {
 
 	for i, c := range cases {
 		mockDb.Expected = c.Expected
-		if err := repo.Patch(mockOrderID, c.Updates); err != nil {
+		if err := repo.Patch(context.Background(), mockOrderID, c.Updates); err != nil {
 			t.Errorf("Cases[%d]: %s", i, err)
 		}
 	}
 }
 
+func TestRepository_SaveSequence(t *testing.T) {
+	mockDb.Expected = &dynamodb.UpdateItemInput{
+		TableName: aws.String(mockTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"orderId": {S: aws.String(mockOrderID)},
+		},
+		ExpressionAttributeNames: map[string]*string{
+			"#lastSequence": aws.String("lastSequence"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":lastSequence": {N: aws.String("3")},
+		},
+		UpdateExpression:    aws.String("SET #lastSequence = :lastSequence"),
+		ConditionExpression: aws.String("attribute_not_exists(#lastSequence) OR #lastSequence < :lastSequence"),
+	}
+	if err := repo.SaveSequence(context.Background(), mockOrderID, 3); err != nil {
+		t.Error(err)
+	}
+
+	// A later sequence has already been saved
+	conditionFailedRepo := NewRepository(&mockDynamoDb{
+		Error: awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "condition failed", nil),
+	}, mockTable)
+	if err := conditionFailedRepo.SaveSequence(context.Background(), mockOrderID, 2); err != nil {
+		t.Errorf("Expected conditional check failures to be ignored, got: %s", err)
+	}
+}
+
+func TestRepository_LastSequence(t *testing.T) {
+	cases := []struct {
+		Item     map[string]*dynamodb.AttributeValue
+		Expected int
+	}{
+		{
+			Item:     map[string]*dynamodb.AttributeValue{},
+			Expected: 0,
+		},
+		{
+			Item: map[string]*dynamodb.AttributeValue{
+				"lastSequence": {N: aws.String("4")},
+			},
+			Expected: 4,
+		},
+	}
+
+	for i, c := range cases {
+		r := NewRepository(&mockDynamoDb{Item: c.Item}, mockTable)
+		got, err := r.LastSequence(context.Background(), mockOrderID)
+		if err != nil {
+			t.Errorf("Cases[%d]: %s", i, err)
+			continue
+		}
+		if got != c.Expected {
+			t.Errorf("Cases[%d]: expected %d, got %d", i, c.Expected, got)
+		}
+	}
+}
+
+func TestRepository_QueryOrders(t *testing.T) {
+	lastKey := map[string]*dynamodb.AttributeValue{
+		"orderId":   {S: aws.String(mockOrderID)},
+		"status":    {S: aws.String("Submitted")},
+		"createdAt": {S: aws.String("2020-04-19T19:45:11.5Z")},
+	}
+	cursor, err := encodeCursor(lastKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		Label          string
+		Query          *OrderQuery
+		Expected       *dynamodb.QueryInput
+		ExpectedCursor string
+		ShouldError    bool
+	}{
+		{
+			Label: "lists every order by creation date",
+			Query: &OrderQuery{},
+			Expected: &dynamodb.QueryInput{
+				TableName:              aws.String(mockTable),
+				IndexName:              aws.String("entityType-createdAt-index"),
+				KeyConditionExpression: aws.String("#entityType = :entityType"),
+				ExpressionAttributeNames: map[string]*string{
+					"#entityType": aws.String("entityType"),
+				},
+				ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+					":entityType": {S: aws.String("Order")},
+				},
+				ScanIndexForward: aws.Bool(true),
+			},
+		},
+		{
+			Label: "filters by status and service type, continuing from the cursor",
+			Query: &OrderQuery{
+				Status:      model.Submitted,
+				ServiceType: model.Delivery,
+				SortBy:      SortByUpdatedAt,
+				Descending:  true,
+				Limit:       10,
+				Cursor:      cursor,
+			},
+			Expected: &dynamodb.QueryInput{
+				TableName:              aws.String(mockTable),
+				IndexName:              aws.String("status-updatedAt-index"),
+				KeyConditionExpression: aws.String("#status = :status"),
+				FilterExpression:       aws.String("#serviceType = :serviceType"),
+				ExpressionAttributeNames: map[string]*string{
+					"#status":      aws.String("status"),
+					"#serviceType": aws.String("serviceType"),
+				},
+				ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+					":status":      {S: aws.String("Submitted")},
+					":serviceType": {S: aws.String("Delivery")},
+				},
+				ScanIndexForward:  aws.Bool(false),
+				Limit:             aws.Int64(10),
+				ExclusiveStartKey: lastKey,
+			},
+			ExpectedCursor: cursor,
+		},
+		{
+			Label: "filters by service type",
+			Query: &OrderQuery{ServiceType: model.Pickup},
+			Expected: &dynamodb.QueryInput{
+				TableName:              aws.String(mockTable),
+				IndexName:              aws.String("serviceType-createdAt-index"),
+				KeyConditionExpression: aws.String("#serviceType = :serviceType"),
+				ExpressionAttributeNames: map[string]*string{
+					"#serviceType": aws.String("serviceType"),
+				},
+				ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+					":serviceType": {S: aws.String("Pickup")},
+				},
+				ScanIndexForward: aws.Bool(true),
+			},
+		},
+		{
+			Label:       "rejects unknown sort attributes",
+			Query:       &OrderQuery{SortBy: "description"},
+			ShouldError: true,
+		},
+		{
+			Label:       "rejects malformed cursors",
+			Query:       &OrderQuery{Cursor: "not a cursor"},
+			ShouldError: true,
+		},
+	}
+
+	for i, c := range cases {
+		db := &mockDynamoDb{
+			Expected: c.Expected,
+			Items: []map[string]*dynamodb.AttributeValue{
+				{"orderId": {S: aws.String(mockOrderID)}},
+			},
+		}
+		if c.ExpectedCursor != "" {
+			db.LastEvaluatedKey = lastKey
+		}
+		page, err := NewRepository(db, mockTable).QueryOrders(context.Background(), c.Query)
+		if c.ShouldError {
+			if _, ok := err.(*InvalidQueryError); !ok {
+				t.Errorf("Cases[%d] FAILED: %s.  Expected an InvalidQueryError, got: %v", i, c.Label, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Cases[%d] FAILED: %s.  Error: %s", i, c.Label, err)
+			continue
+		}
+		if diff := deep.Equal(page, &OrderPage{Orders: []*Order{{OrderID: mockOrderID}}, Cursor: c.ExpectedCursor}); diff != nil {
+			t.Errorf("Cases[%d] FAILED: %s.  Error: %s", i, c.Label, diff)
+		}
+	}
+}
+
+func TestRepository_QueryAllOrders(t *testing.T) {
+	db := &mockDynamoDb{
+		Pages: [][]map[string]*dynamodb.AttributeValue{
+			{{"orderId": {S: aws.String("a")}}, {"orderId": {S: aws.String("b")}}},
+			{{"orderId": {S: aws.String("c")}}},
+		},
+	}
+	orders, err := NewRepository(db, mockTable).QueryAllOrders(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []*Order{{OrderID: "a"}, {OrderID: "b"}, {OrderID: "c"}}
+	if diff := deep.Equal(orders, expected); diff != nil {
+		t.Error(diff)
+	}
+}
+
 type mockDynamoDb struct {
 	dynamodbiface.DynamoDBAPI
-	Expected interface{}
+	Expected         interface{}
+	Item             map[string]*dynamodb.AttributeValue
+	Items            []map[string]*dynamodb.AttributeValue
+	LastEvaluatedKey map[string]*dynamodb.AttributeValue
+	Pages            [][]map[string]*dynamodb.AttributeValue
+	Error            error
+}
+
+func (m mockDynamoDb) QueryWithContext(_ aws.Context, in *dynamodb.QueryInput, _ ...request.Option) (*dynamodb.QueryOutput, error) {
+	if diff := deep.Equal(m.Expected, in); diff != nil {
+		return nil, fmt.Errorf("%s", diff)
+	}
+	return &dynamodb.QueryOutput{Items: m.Items, LastEvaluatedKey: m.LastEvaluatedKey}, m.Error
 }
 
-func (m mockDynamoDb) UpdateItem(in *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+func (m mockDynamoDb) ScanPagesWithContext(_ aws.Context, in *dynamodb.ScanInput, fn func(*dynamodb.ScanOutput, bool) bool, _ ...request.Option) error {
+	for i, items := range m.Pages {
+		if !fn(&dynamodb.ScanOutput{Items: items}, i == len(m.Pages)-1) {
+			break
+		}
+	}
+	return m.Error
+}
+
+func (m mockDynamoDb) GetItemWithContext(_ aws.Context, in *dynamodb.GetItemInput, _ ...request.Option) (*dynamodb.GetItemOutput, error) {
+	return &dynamodb.GetItemOutput{Item: m.Item}, m.Error
+}
+
+func (m mockDynamoDb) UpdateItemWithContext(_ aws.Context, in *dynamodb.UpdateItemInput, _ ...request.Option) (*dynamodb.UpdateItemOutput, error) {
+	if m.Error != nil {
+		return nil, m.Error
+	}
 	// Check if this matches the expected
 	if diff := deep.Equal(m.Expected, in); diff != nil {
 		return nil, fmt.Errorf("%s", diff)
@@ -0,0 +1,141 @@
+package orderfulfillment
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"forge.lmig.com/n1505471/pizza-shop/eventsource"
+	"forge.lmig.com/n1505471/pizza-shop/eventsource/saga"
+	"forge.lmig.com/n1505471/pizza-shop/internal/domain/approval"
+	"forge.lmig.com/n1505471/pizza-shop/internal/domain/delivery"
+)
+
+func init() {
+	eventsource.RegisterEventType(&SubmitOrderForApproval{})
+	eventsource.RegisterEventType(&OrderSubmittedForApproval{})
+	eventsource.RegisterEventType(&SubmitOrderForDelivery{})
+	eventsource.RegisterEventType(&OrderSubmittedForDelivery{})
+}
+
+// SubmitOrderForApproval asks the vendor system to approve the order
+type SubmitOrderForApproval struct {
+	OrderID     string `json:"orderId"`
+	Description string `json:"description"`
+}
+
+func (e *SubmitOrderForApproval) Version() int {
+	return 1
+}
+
+func (e *SubmitOrderForApproval) Load(data json.RawMessage, version int) error {
+	switch version {
+	default:
+		err := json.Unmarshal(data, e)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OrderSubmittedForApproval records the ID the vendor system will send the approval with
+type OrderSubmittedForApproval struct {
+	OrderID    string `json:"orderId"`
+	ApprovalID int    `json:"approvalId"`
+}
+
+func (e *OrderSubmittedForApproval) Version() int {
+	return 1
+}
+
+func (e *OrderSubmittedForApproval) Load(data json.RawMessage, version int) error {
+	switch version {
+	default:
+		err := json.Unmarshal(data, e)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SubmitOrderForDelivery asks the delivery system to deliver the order
+type SubmitOrderForDelivery struct {
+	OrderID     string `json:"orderId"`
+	Description string `json:"description"`
+}
+
+func (e *SubmitOrderForDelivery) Version() int {
+	return 1
+}
+
+func (e *SubmitOrderForDelivery) Load(data json.RawMessage, version int) error {
+	switch version {
+	default:
+		err := json.Unmarshal(data, e)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OrderSubmittedForDelivery records the ID the delivery system will confirm the delivery with
+type OrderSubmittedForDelivery struct {
+	OrderID    string `json:"orderId"`
+	DeliveryID int    `json:"deliveryId"`
+}
+
+func (e *OrderSubmittedForDelivery) Version() int {
+	return 1
+}
+
+func (e *OrderSubmittedForDelivery) Load(data json.RawMessage, version int) error {
+	switch version {
+	default:
+		err := json.Unmarshal(data, e)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HandleIntents registers the handlers which carry out the saga's intents with the dispatcher
+func HandleIntents(d *saga.OutboxDispatcher, approvalSvc approval.ServiceAPI, deliverySvc delivery.ServiceAPI) {
+	d.Handle(&SubmitOrderForApproval{}, func(ctx context.Context, intent eventsource.Event) (eventsource.EventData, error) {
+		i, ok := intent.Data.(*SubmitOrderForApproval)
+		if !ok {
+			return nil, fmt.Errorf("Unsupported intent %T received: %+v", intent.Data, intent)
+		}
+
+		a, err := approvalSvc.SubmitOrderForApproval(ctx, &approval.OrderApproval{
+			Description: i.Description,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		log.Printf("Order %s submitted for approval, with callback ID: %d", i.OrderID, a.ApprovalID)
+		return &OrderSubmittedForApproval{OrderID: i.OrderID, ApprovalID: a.ApprovalID}, nil
+	})
+
+	d.Handle(&SubmitOrderForDelivery{}, func(ctx context.Context, intent eventsource.Event) (eventsource.EventData, error) {
+		i, ok := intent.Data.(*SubmitOrderForDelivery)
+		if !ok {
+			return nil, fmt.Errorf("Unsupported intent %T received: %+v", intent.Data, intent)
+		}
+
+		a, err := deliverySvc.SubmitOrderForDelivery(ctx, &delivery.OrderDelivery{
+			Description: i.Description,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		log.Printf("Order %s submitted for delivery, with callback ID: %d", i.OrderID, a.DeliveryID)
+		return &OrderSubmittedForDelivery{OrderID: i.OrderID, DeliveryID: a.DeliveryID}, nil
+	})
+}
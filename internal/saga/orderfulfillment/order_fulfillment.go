@@ -1,10 +1,12 @@
 package orderfulfillment
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"strconv"
+	"time"
 
 	"forge.lmig.com/n1505471/pizza-shop/internal/domain/order/model"
 
@@ -12,34 +14,39 @@ import (
 
 	"forge.lmig.com/n1505471/pizza-shop/eventsource/saga"
 
-	"forge.lmig.com/n1505471/pizza-shop/internal/domain/approval"
-	"forge.lmig.com/n1505471/pizza-shop/internal/domain/delivery"
-
 	"forge.lmig.com/n1505471/pizza-shop/eventsource"
 	approvalEvents "forge.lmig.com/n1505471/pizza-shop/internal/domain/approval/event"
 	deliveryEvents "forge.lmig.com/n1505471/pizza-shop/internal/domain/delivery/event"
 	orderEvents "forge.lmig.com/n1505471/pizza-shop/internal/domain/order/event"
 )
 
+// The vendor system has approvalTimeout to respond once an order is submitted for approval
+const (
+	approvalTimeout  = 30 * time.Minute
+	approvalDeadline = "ApprovalTimeout"
+)
+
+// OrderFulfillmentSaga submits orders for approval and delivery through the outbox, so the
+// vendor systems are only called once the saga's state is saved.  See HandleIntents.
 type OrderFulfillmentSaga struct {
-	deliverySvc delivery.ServiceAPI
-	approvalSvc approval.ServiceAPI
-	orderSvc    order.ServiceAPI
-
-	OrderID         string `json:"orderId"`
-	Description     string `json:"description"`
-	IsDeliveryOrder bool   `json:"isDeliveryOrder"`
-	Approved        bool   `json:"approved"`
-	Delivered       bool   `json:"delivered"`
+	orderSvc order.ServiceAPI
+
+	OrderID          string `json:"orderId"`
+	Description      string `json:"description"`
+	IsDeliveryOrder  bool   `json:"isDeliveryOrder"`
+	Approved         bool   `json:"approved"`
+	Delivered        bool   `json:"delivered"`
+	ApprovalTimedOut bool   `json:"approvalTimedOut"`
+	Rejected         bool   `json:"rejected"`
+	Cancelled        bool   `json:"cancelled"`
 }
 
 var _ saga.SagaAPI = (*OrderFulfillmentSaga)(nil)
+var _ saga.LateAssociations = (*OrderFulfillmentSaga)(nil)
 
-func New(orderSvc order.ServiceAPI, deliverySvc delivery.ServiceAPI, approvalSvc approval.ServiceAPI) *OrderFulfillmentSaga {
+func New(orderSvc order.ServiceAPI) *OrderFulfillmentSaga {
 	return &OrderFulfillmentSaga{
-		deliverySvc: deliverySvc,
-		approvalSvc: approvalSvc,
-		orderSvc:    orderSvc,
+		orderSvc: orderSvc,
 	}
 }
 
@@ -55,6 +62,27 @@ func (s *OrderFulfillmentSaga) StartEvent() string {
 	return "OrderStartedEvent"
 }
 
+// EventTypes are the events published to the saga.  ApprovalTimedOut is a deadline, which
+// the Scheduler delivers directly, and the results of the saga's intents are delivered by
+// the OutboxDispatcher.
+func (s *OrderFulfillmentSaga) EventTypes() []string {
+	return []string{
+		"OrderDescriptionSet",
+		"OrderServiceTypeSetEvent",
+		"OrderSubmitted",
+		"ApprovalReceived",
+		"ApprovalRejected",
+		"DeliveryConfirmed",
+		"DeliveryFailed",
+	}
+}
+
+// LateAssociationTypes are the callback IDs the vendor systems return to the saga's intents.  A
+// vendor can call back before the outbox saves the ID, so those events are retried.
+func (s *OrderFulfillmentSaga) LateAssociationTypes() []string {
+	return []string{"ApprovalID", "DeliveryID"}
+}
+
 func (s *OrderFulfillmentSaga) Load(data json.RawMessage, version int) error {
 	switch version {
 	default:
@@ -78,27 +106,58 @@ func (s *OrderFulfillmentSaga) AssociationID(event eventsource.Event) (*saga.Sag
 			ID:              d.OrderID,
 			AssociationType: "OrderID",
 		}, nil
+	case *orderEvents.OrderServiceTypeSetEvent:
+		return &saga.SagaAssociation{
+			ID:              d.OrderID,
+			AssociationType: "OrderID",
+		}, nil
 	case *orderEvents.OrderSubmitted:
 		return &saga.SagaAssociation{
 			ID:              d.OrderID,
 			AssociationType: "OrderID",
 		}, nil
+	case *OrderSubmittedForApproval:
+		return &saga.SagaAssociation{
+			ID:              d.OrderID,
+			AssociationType: "OrderID",
+		}, nil
 	case *approvalEvents.ApprovalReceived:
 		return &saga.SagaAssociation{
 			ID:              strconv.Itoa(d.ApprovalID),
 			AssociationType: "ApprovalID",
 		}, nil
+	case *approvalEvents.ApprovalRejected:
+		return &saga.SagaAssociation{
+			ID:              strconv.Itoa(d.ApprovalID),
+			AssociationType: "ApprovalID",
+		}, nil
+	case *approvalEvents.ApprovalTimedOut:
+		return &saga.SagaAssociation{
+			ID:              d.OrderID,
+			AssociationType: "OrderID",
+		}, nil
+	case *OrderSubmittedForDelivery:
+		return &saga.SagaAssociation{
+			ID:              d.OrderID,
+			AssociationType: "OrderID",
+		}, nil
 	case *deliveryEvents.DeliveryConfirmed:
 		return &saga.SagaAssociation{
 			ID:              strconv.Itoa(d.DeliveryID),
 			AssociationType: "DeliveryID",
 		}, nil
+	case *deliveryEvents.DeliveryFailed:
+		return &saga.SagaAssociation{
+			ID:              strconv.Itoa(d.DeliveryID),
+			AssociationType: "DeliveryID",
+		}, nil
 	default:
 		return nil, fmt.Errorf("Unsupported event %T received: %+v", d, event)
 	}
 }
 
-func (s *OrderFulfillmentSaga) HandleEvent(event eventsource.Event) (*saga.HandleEventResult, error) {
+// HandleEvent is given a context carrying the event as the cause of the saga's commands
+func (s *OrderFulfillmentSaga) HandleEvent(ctx context.Context, event eventsource.Event) (*saga.HandleEventResult, error) {
 	switch d := event.Data.(type) {
 	case *orderEvents.OrderStartedEvent:
 		s.OrderID = d.OrderID
@@ -112,75 +171,122 @@ func (s *OrderFulfillmentSaga) HandleEvent(event eventsource.Event) (*saga.Handl
 		s.IsDeliveryOrder = d.ServiceType == model.Delivery
 		return nil, nil
 	case *orderEvents.OrderSubmitted:
-		ids, err := s.startSaga(d)
-		if err != nil {
-			return nil, err
-		}
-		return &saga.HandleEventResult{AssociationIDs: ids}, nil
+		return &saga.HandleEventResult{
+			Intents: []eventsource.EventData{
+				&SubmitOrderForApproval{OrderID: s.OrderID, Description: s.Description},
+			},
+			Deadlines: []*saga.ScheduleDeadline{{
+				Name:  approvalDeadline,
+				After: approvalTimeout,
+				Data:  &approvalEvents.ApprovalTimedOut{OrderID: s.OrderID},
+			}},
+		}, nil
+	case *OrderSubmittedForApproval:
+		return &saga.HandleEventResult{
+			AssociationIDs: []*saga.SagaAssociation{{
+				ID:              strconv.Itoa(d.ApprovalID),
+				AssociationType: "ApprovalID",
+			}},
+		}, nil
 	case *approvalEvents.ApprovalReceived:
-		return s.handleApprovalReceived(d)
+		return s.handleApprovalReceived(ctx, d)
+	case *approvalEvents.ApprovalRejected:
+		return s.handleApprovalRejected(ctx, d)
+	case *approvalEvents.ApprovalTimedOut:
+		return s.handleApprovalTimedOut(ctx, d)
+	case *OrderSubmittedForDelivery:
+		return &saga.HandleEventResult{
+			AssociationIDs: []*saga.SagaAssociation{{
+				ID:              strconv.Itoa(d.DeliveryID),
+				AssociationType: "DeliveryID",
+			}},
+		}, nil
 	case *deliveryEvents.DeliveryConfirmed:
-		return s.handleDeliveryConfirmed(d)
+		return s.handleDeliveryConfirmed(ctx, d)
+	case *deliveryEvents.DeliveryFailed:
+		return s.handleDeliveryFailed(ctx, d)
 	default:
 		return nil, fmt.Errorf("Unsupported event %T received: %+v", d, event)
 	}
 }
 
-func (s *OrderFulfillmentSaga) startSaga(_ *orderEvents.OrderSubmitted) ([]*saga.SagaAssociation, error) {
-	a, err := s.approvalSvc.SubmitOrderForApproval(&approval.OrderApproval{
-		Description: s.Description,
-	})
-	if err != nil {
-		return nil, err
-	}
-	associations := []*saga.SagaAssociation{
-		{
-			ID:              strconv.Itoa(a.ApprovalID),
-			AssociationType: "ApprovalID",
-		},
-	}
-	return associations, nil
-}
-
-func (s *OrderFulfillmentSaga) handleApprovalReceived(_ *approvalEvents.ApprovalReceived) (*saga.HandleEventResult, error) {
+func (s *OrderFulfillmentSaga) handleApprovalReceived(ctx context.Context, _ *approvalEvents.ApprovalReceived) (*saga.HandleEventResult, error) {
 
-	if err := s.orderSvc.ApproveOrder(s.OrderID); err != nil {
+	if err := s.orderSvc.ApproveOrder(ctx, s.OrderID); err != nil {
 		return nil, err
 	}
 
 	s.Approved = true
+	result := &saga.HandleEventResult{CancelDeadlines: []string{approvalDeadline}}
 
 	if !s.IsDeliveryOrder {
-		log.Printf("This order is not marked for delivery, skipping.")
+		log.Printf("This order is not marked for delivery, fulfillment is complete!")
+		result.Ended = true
+		return result, nil
+	}
+
+	result.Intents = []eventsource.EventData{
+		&SubmitOrderForDelivery{OrderID: s.OrderID, Description: s.Description},
+	}
+	return result, nil
+}
+
+func (s *OrderFulfillmentSaga) handleApprovalTimedOut(ctx context.Context, _ *approvalEvents.ApprovalTimedOut) (*saga.HandleEventResult, error) {
+
+	if s.Approved {
+		log.Printf("Order %s was approved before the deadline, ignoring the timeout.", s.OrderID)
 		return nil, nil
 	}
 
-	a, err := s.deliverySvc.SubmitOrderForDelivery(&delivery.OrderDelivery{
-		Description: s.Description,
-	})
-	if err != nil {
+	reason := fmt.Sprintf("No approval received within %s", approvalTimeout)
+	if err := s.orderSvc.RejectOrder(ctx, s.OrderID, reason); err != nil {
 		return nil, err
 	}
 
-	log.Printf("Order submitted for delivery, with callback ID: %d", a.DeliveryID)
+	s.ApprovalTimedOut = true
+	s.Rejected = true
 
-	associations := []*saga.SagaAssociation{
-		{
-			ID:              strconv.Itoa(a.DeliveryID),
-			AssociationType: "DeliveryID",
-		},
+	log.Printf("No approval received for order %s within %s, the order has been rejected.", s.OrderID, approvalTimeout)
+	return &saga.HandleEventResult{Ended: true}, nil
+}
+
+func (s *OrderFulfillmentSaga) handleApprovalRejected(ctx context.Context, d *approvalEvents.ApprovalRejected) (*saga.HandleEventResult, error) {
+
+	if err := s.orderSvc.RejectOrder(ctx, s.OrderID, d.Reason); err != nil {
+		return nil, err
 	}
-	return &saga.HandleEventResult{AssociationIDs: associations}, nil
+
+	s.Rejected = true
+
+	log.Printf("Order %s was not approved, details: %s", s.OrderID, d.Reason)
+	return &saga.HandleEventResult{
+		CancelDeadlines: []string{approvalDeadline},
+		Ended:           true,
+	}, nil
 }
 
-func (s *OrderFulfillmentSaga) handleDeliveryConfirmed(_ *deliveryEvents.DeliveryConfirmed) (*saga.HandleEventResult, error) {
+func (s *OrderFulfillmentSaga) handleDeliveryConfirmed(ctx context.Context, _ *deliveryEvents.DeliveryConfirmed) (*saga.HandleEventResult, error) {
 
-	if err := s.orderSvc.DeliverOrder(s.OrderID); err != nil {
+	if err := s.orderSvc.DeliverOrder(ctx, s.OrderID); err != nil {
 		return nil, err
 	}
 
 	s.Delivered = true
 
 	log.Printf("Order has been delivered, fulfillment is complete!")
-	return nil, nil
+	return &saga.HandleEventResult{Ended: true}, nil
+}
+
+// handleDeliveryFailed cancels the order.  The order has been approved by now, so the
+// OrderCancelled event marks a refund as due for whoever handles payments.
+func (s *OrderFulfillmentSaga) handleDeliveryFailed(ctx context.Context, d *deliveryEvents.DeliveryFailed) (*saga.HandleEventResult, error) {
+
+	if err := s.orderSvc.CancelOrder(ctx, s.OrderID, d.Reason); err != nil {
+		return nil, err
+	}
+
+	s.Cancelled = true
+
+	log.Printf("Delivery failed for order %s, the order has been cancelled, details: %s", s.OrderID, d.Reason)
+	return &saga.HandleEventResult{Ended: true}, nil
 }
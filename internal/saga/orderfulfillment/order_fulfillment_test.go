@@ -1,8 +1,10 @@
 package orderfulfillment
 
 import (
+	"context"
 	"fmt"
 	"testing"
+	"time"
 
 	"forge.lmig.com/n1505471/pizza-shop/internal/domain/delivery"
 
@@ -17,6 +19,7 @@ import (
 
 	"forge.lmig.com/n1505471/pizza-shop/eventsource"
 	"forge.lmig.com/n1505471/pizza-shop/eventsource/saga"
+	"forge.lmig.com/n1505471/pizza-shop/eventsource/saga/store/memory"
 	orderEvents "forge.lmig.com/n1505471/pizza-shop/internal/domain/order/event"
 
 	"github.com/go-test/deep"
@@ -44,6 +47,21 @@ func TestOrderFulfillmentSaga_StartEvent(t *testing.T) {
 	}
 }
 
+func TestOrderFulfillmentSaga_EventTypes(t *testing.T) {
+	expected := []string{
+		"OrderDescriptionSet",
+		"OrderServiceTypeSetEvent",
+		"OrderSubmitted",
+		"ApprovalReceived",
+		"ApprovalRejected",
+		"DeliveryConfirmed",
+		"DeliveryFailed",
+	}
+	if diff := deep.Equal(expected, testSaga.EventTypes()); diff != nil {
+		t.Error(diff)
+	}
+}
+
 func TestOrderFulfillmentSaga_Load(t *testing.T) {
 	cases := eventsourcetest.SagaLoadTestCases{
 		{
@@ -104,6 +122,17 @@ func TestOrderFulfillmentSaga_AssociationID(t *testing.T) {
 				AssociationType: "OrderID",
 			},
 		},
+		{
+			Label: "handles OrderServiceTypeSetEvent",
+			Saga:  &OrderFulfillmentSaga{},
+			Event: eventsource.Event{Data: &orderEvents.OrderServiceTypeSetEvent{
+				OrderID: "orderID",
+			}},
+			Expected: &saga.SagaAssociation{
+				ID:              "orderID",
+				AssociationType: "OrderID",
+			},
+		},
 		{
 			Label: "handles OrderSubmitted",
 			Saga:  &OrderFulfillmentSaga{},
@@ -115,6 +144,17 @@ func TestOrderFulfillmentSaga_AssociationID(t *testing.T) {
 				AssociationType: "OrderID",
 			},
 		},
+		{
+			Label: "handles OrderSubmittedForApproval",
+			Saga:  &OrderFulfillmentSaga{},
+			Event: eventsource.Event{Data: &OrderSubmittedForApproval{
+				OrderID: "orderID",
+			}},
+			Expected: &saga.SagaAssociation{
+				ID:              "orderID",
+				AssociationType: "OrderID",
+			},
+		},
 		{
 			Label: "handles ApprovalReceived",
 			Saga:  &OrderFulfillmentSaga{},
@@ -127,7 +167,40 @@ func TestOrderFulfillmentSaga_AssociationID(t *testing.T) {
 			},
 		},
 		{
-			Label: "handles ApprovalReceived",
+			Label: "handles ApprovalRejected",
+			Saga:  &OrderFulfillmentSaga{},
+			Event: eventsource.Event{Data: &approvalEvents.ApprovalRejected{
+				ApprovalID: 1,
+			}},
+			Expected: &saga.SagaAssociation{
+				ID:              "1",
+				AssociationType: "ApprovalID",
+			},
+		},
+		{
+			Label: "handles ApprovalTimedOut",
+			Saga:  &OrderFulfillmentSaga{},
+			Event: eventsource.Event{Data: &approvalEvents.ApprovalTimedOut{
+				OrderID: "orderID",
+			}},
+			Expected: &saga.SagaAssociation{
+				ID:              "orderID",
+				AssociationType: "OrderID",
+			},
+		},
+		{
+			Label: "handles OrderSubmittedForDelivery",
+			Saga:  &OrderFulfillmentSaga{},
+			Event: eventsource.Event{Data: &OrderSubmittedForDelivery{
+				OrderID: "orderID",
+			}},
+			Expected: &saga.SagaAssociation{
+				ID:              "orderID",
+				AssociationType: "OrderID",
+			},
+		},
+		{
+			Label: "handles DeliveryConfirmed",
 			Saga:  &OrderFulfillmentSaga{},
 			Event: eventsource.Event{Data: &deliveryEvents.DeliveryConfirmed{
 				DeliveryID: 1,
@@ -137,6 +210,17 @@ func TestOrderFulfillmentSaga_AssociationID(t *testing.T) {
 				AssociationType: "DeliveryID",
 			},
 		},
+		{
+			Label: "handles DeliveryFailed",
+			Saga:  &OrderFulfillmentSaga{},
+			Event: eventsource.Event{Data: &deliveryEvents.DeliveryFailed{
+				DeliveryID: 1,
+			}},
+			Expected: &saga.SagaAssociation{
+				ID:              "1",
+				AssociationType: "DeliveryID",
+			},
+		},
 		{
 			Label:       "returns error for unsupported event types",
 			Saga:        &OrderFulfillmentSaga{},
@@ -168,14 +252,38 @@ var submittedEvent = eventsource.Event{Data: &orderEvents.OrderSubmitted{
 	OrderID: "orderID",
 }}
 
+var submittedForApproval = eventsource.Event{Data: &OrderSubmittedForApproval{
+	OrderID:    "orderID",
+	ApprovalID: 1,
+}}
+
 var approvalReceived = eventsource.Event{Data: &approvalEvents.ApprovalReceived{
 	ApprovalID: 1,
 }}
 
+var approvalRejected = eventsource.Event{Data: &approvalEvents.ApprovalRejected{
+	ApprovalID: 1,
+	Reason:     "Out of dough",
+}}
+
+var approvalTimedOut = eventsource.Event{Data: &approvalEvents.ApprovalTimedOut{
+	OrderID: "orderID",
+}}
+
+var submittedForDelivery = eventsource.Event{Data: &OrderSubmittedForDelivery{
+	OrderID:    "orderID",
+	DeliveryID: 2,
+}}
+
 var deliveryConfirmed = eventsource.Event{Data: &deliveryEvents.DeliveryConfirmed{
 	DeliveryID: 2,
 }}
 
+var deliveryFailed = eventsource.Event{Data: &deliveryEvents.DeliveryFailed{
+	DeliveryID: 2,
+	Reason:     "Driver could not find the address",
+}}
+
 func TestOrderFulfillmentSaga_HandleEvent(t *testing.T) {
 	cases := eventsourcetest.SagaHandleEventTestCases{
 		{
@@ -210,10 +318,7 @@ func TestOrderFulfillmentSaga_HandleEvent(t *testing.T) {
 			},
 		},
 		{
-			Label: "handles OrderSubmitted correctly",
-			Saga: New(&mockOrderSvc{}, &mockDeliverySvc{}, &mockApprovalSvc{Expected: &approval.OrderApproval{
-				Description: "test description",
-			}}),
+			Label: "submits the order for approval on OrderSubmitted",
 			Given: []eventsource.Event{
 				orderStartedEvent,
 				serviceTypeSetEvent,
@@ -224,31 +329,38 @@ func TestOrderFulfillmentSaga_HandleEvent(t *testing.T) {
 				Description:     "test description",
 				IsDeliveryOrder: true,
 			},
-			ExpectedResult: &saga.HandleEventResult{AssociationIDs: []*saga.SagaAssociation{{
-				AssociationType: "ApprovalID",
-				ID:              "1",
-			}}},
+			ExpectedResult: &saga.HandleEventResult{
+				Intents: []eventsource.EventData{
+					&SubmitOrderForApproval{OrderID: "orderID", Description: "test description"},
+				},
+				Deadlines: []*saga.ScheduleDeadline{{
+					Name:  "ApprovalTimeout",
+					After: 30 * time.Minute,
+					Data:  &approvalEvents.ApprovalTimedOut{OrderID: "orderID"},
+				}},
+			},
 		},
 		{
-			Label: "forwards errors from approval service on OrderSubmitted",
-			Saga:  New(&mockOrderSvc{}, &mockDeliverySvc{}, &mockApprovalSvc{ShouldError: true}),
+			Label: "associates the approval on OrderSubmittedForApproval",
 			Given: []eventsource.Event{
 				orderStartedEvent,
-				serviceTypeSetEvent,
+				submittedEvent,
+			},
+			Event: submittedForApproval,
+			ExpectedSaga: &OrderFulfillmentSaga{
+				OrderID:     "orderID",
+				Description: "test description",
+			},
+			ExpectedResult: &saga.HandleEventResult{
+				AssociationIDs: []*saga.SagaAssociation{{
+					AssociationType: "ApprovalID",
+					ID:              "1",
+				}},
 			},
-			Event:         submittedEvent,
-			ShouldError:   true,
-			ExpectedError: fmt.Errorf("Error in SubmitOrderForApproval"),
 		},
 		{
 			Label: "handles ApprovalReceived correctly",
-			Saga: New(
-				&mockOrderSvc{Expected: "orderID"},
-				&mockDeliverySvc{Expected: &delivery.OrderDelivery{
-					Description: "test description",
-				}},
-				&mockApprovalSvc{},
-			),
+			Saga:  New(&mockOrderSvc{Expected: "orderID"}),
 			Given: []eventsource.Event{
 				orderStartedEvent,
 				serviceTypeSetEvent,
@@ -261,14 +373,16 @@ func TestOrderFulfillmentSaga_HandleEvent(t *testing.T) {
 				IsDeliveryOrder: true,
 				Approved:        true,
 			},
-			ExpectedResult: &saga.HandleEventResult{AssociationIDs: []*saga.SagaAssociation{{
-				AssociationType: "DeliveryID",
-				ID:              "2",
-			}}},
+			ExpectedResult: &saga.HandleEventResult{
+				Intents: []eventsource.EventData{
+					&SubmitOrderForDelivery{OrderID: "orderID", Description: "test description"},
+				},
+				CancelDeadlines: []string{"ApprovalTimeout"},
+			},
 		},
 		{
 			Label: "forwards errors from order service on ApprovalReceived",
-			Saga:  New(&mockOrderSvc{ShouldError: true}, &mockDeliverySvc{}, &mockApprovalSvc{}),
+			Saga:  New(&mockOrderSvc{ShouldError: true}),
 			Given: []eventsource.Event{
 				orderStartedEvent,
 				serviceTypeSetEvent,
@@ -280,13 +394,16 @@ func TestOrderFulfillmentSaga_HandleEvent(t *testing.T) {
 		},
 		{
 			Label: "skips orders not intended for delivery on ApprovalReceived",
-			Saga:  New(&mockOrderSvc{}, &mockDeliverySvc{}, &mockApprovalSvc{}),
+			Saga:  New(&mockOrderSvc{}),
 			Given: []eventsource.Event{
 				orderStartedEvent,
 				submittedEvent,
 			},
-			Event:          approvalReceived,
-			ExpectedResult: nil,
+			Event: approvalReceived,
+			ExpectedResult: &saga.HandleEventResult{
+				CancelDeadlines: []string{"ApprovalTimeout"},
+				Ended:           true,
+			},
 			ExpectedSaga: &OrderFulfillmentSaga{
 				OrderID:         "orderID",
 				Description:     "test description",
@@ -295,24 +412,79 @@ func TestOrderFulfillmentSaga_HandleEvent(t *testing.T) {
 			},
 		},
 		{
-			Label: "forwards errors from delivery service on ApprovalReceived",
-			Saga:  New(&mockOrderSvc{}, &mockDeliverySvc{ShouldError: true}, &mockApprovalSvc{}),
+			Label: "rejects the order on ApprovalRejected",
+			Saga:  New(&mockOrderSvc{Expected: "orderID"}),
 			Given: []eventsource.Event{
 				orderStartedEvent,
-				serviceTypeSetEvent,
 				submittedEvent,
 			},
-			Event:         approvalReceived,
+			Event: approvalRejected,
+			ExpectedSaga: &OrderFulfillmentSaga{
+				OrderID:     "orderID",
+				Description: "test description",
+				Rejected:    true,
+			},
+			ExpectedResult: &saga.HandleEventResult{
+				CancelDeadlines: []string{"ApprovalTimeout"},
+				Ended:           true,
+			},
+		},
+		{
+			Label: "forwards errors from order service on ApprovalRejected",
+			Saga:  New(&mockOrderSvc{ShouldError: true}),
+			Given: []eventsource.Event{
+				orderStartedEvent,
+				submittedEvent,
+			},
+			Event:         approvalRejected,
 			ShouldError:   true,
-			ExpectedError: fmt.Errorf("Error in SubmitOrderForDelivery"),
+			ExpectedError: fmt.Errorf("Error in RejectOrder"),
+		},
+		{
+			Label: "rejects the order on ApprovalTimedOut",
+			Saga:  New(&mockOrderSvc{Expected: "orderID"}),
+			Given: []eventsource.Event{
+				orderStartedEvent,
+				submittedEvent,
+			},
+			Event: approvalTimedOut,
+			ExpectedSaga: &OrderFulfillmentSaga{
+				OrderID:          "orderID",
+				Description:      "test description",
+				ApprovalTimedOut: true,
+				Rejected:         true,
+			},
+			ExpectedResult: &saga.HandleEventResult{Ended: true},
+		},
+		{
+			Label: "forwards errors from order service on ApprovalTimedOut",
+			Saga:  New(&mockOrderSvc{ShouldError: true}),
+			Given: []eventsource.Event{
+				orderStartedEvent,
+				submittedEvent,
+			},
+			Event:         approvalTimedOut,
+			ShouldError:   true,
+			ExpectedError: fmt.Errorf("Error in RejectOrder"),
+		},
+		{
+			Label: "ignores ApprovalTimedOut once approved",
+			Saga:  New(&mockOrderSvc{}),
+			Given: []eventsource.Event{
+				orderStartedEvent,
+				submittedEvent,
+				approvalReceived,
+			},
+			Event: approvalTimedOut,
+			ExpectedSaga: &OrderFulfillmentSaga{
+				OrderID:     "orderID",
+				Description: "test description",
+				Approved:    true,
+			},
 		},
 		{
 			Label: "handles DeliveryConfirmed correctly",
-			Saga: New(
-				&mockOrderSvc{Expected: "orderID"},
-				&mockDeliverySvc{},
-				&mockApprovalSvc{},
-			),
+			Saga:  New(&mockOrderSvc{Expected: "orderID"}),
 			Given: []eventsource.Event{
 				orderStartedEvent,
 				serviceTypeSetEvent,
@@ -327,11 +499,11 @@ func TestOrderFulfillmentSaga_HandleEvent(t *testing.T) {
 				Approved:        true,
 				Delivered:       true,
 			},
-			ExpectedResult: nil,
+			ExpectedResult: &saga.HandleEventResult{Ended: true},
 		},
 		{
 			Label: "forwards errors from order service on DeliveryConfirmed",
-			Saga:  New(&mockOrderSvc{ShouldError: true}, &mockDeliverySvc{}, &mockApprovalSvc{}),
+			Saga:  New(&mockOrderSvc{ShouldError: true}),
 			Given: []eventsource.Event{
 				orderStartedEvent,
 				serviceTypeSetEvent,
@@ -342,6 +514,38 @@ func TestOrderFulfillmentSaga_HandleEvent(t *testing.T) {
 			ShouldError:   true,
 			ExpectedError: fmt.Errorf("Error in DeliverOrder"),
 		},
+		{
+			Label: "cancels the order on DeliveryFailed",
+			Saga:  New(&mockOrderSvc{Expected: "orderID"}),
+			Given: []eventsource.Event{
+				orderStartedEvent,
+				serviceTypeSetEvent,
+				submittedEvent,
+				approvalReceived,
+			},
+			Event: deliveryFailed,
+			ExpectedSaga: &OrderFulfillmentSaga{
+				OrderID:         "orderID",
+				Description:     "test description",
+				IsDeliveryOrder: true,
+				Approved:        true,
+				Cancelled:       true,
+			},
+			ExpectedResult: &saga.HandleEventResult{Ended: true},
+		},
+		{
+			Label: "forwards errors from order service on DeliveryFailed",
+			Saga:  New(&mockOrderSvc{ShouldError: true}),
+			Given: []eventsource.Event{
+				orderStartedEvent,
+				serviceTypeSetEvent,
+				submittedEvent,
+				approvalReceived,
+			},
+			Event:         deliveryFailed,
+			ShouldError:   true,
+			ExpectedError: fmt.Errorf("Error in CancelOrder"),
+		},
 		{
 			Label:       "returns error for unsupported event types",
 			Saga:        &OrderFulfillmentSaga{},
@@ -353,13 +557,262 @@ func TestOrderFulfillmentSaga_HandleEvent(t *testing.T) {
 	cases.Test(t)
 }
 
+func TestOrderFulfillmentSaga_ProcessEvent(t *testing.T) {
+	cases := []struct {
+		Label    string
+		Events   []eventsource.Event
+		Expected *OrderFulfillmentSaga
+	}{
+		{
+			Label: "fulfills a delivery order",
+			Events: []eventsource.Event{
+				orderStartedEvent,
+				serviceTypeSetEvent,
+				submittedEvent,
+				approvalReceived,
+				deliveryConfirmed,
+			},
+			Expected: &OrderFulfillmentSaga{
+				OrderID:         "orderID",
+				Description:     "test description",
+				IsDeliveryOrder: true,
+				Approved:        true,
+				Delivered:       true,
+			},
+		},
+		{
+			Label: "fulfills a pickup order once approved",
+			Events: []eventsource.Event{
+				orderStartedEvent,
+				submittedEvent,
+				approvalReceived,
+			},
+			Expected: &OrderFulfillmentSaga{
+				OrderID:     "orderID",
+				Description: "test description",
+				Approved:    true,
+			},
+		},
+		{
+			Label: "cancels a delivery order when the delivery fails",
+			Events: []eventsource.Event{
+				orderStartedEvent,
+				serviceTypeSetEvent,
+				submittedEvent,
+				approvalReceived,
+				deliveryFailed,
+			},
+			Expected: &OrderFulfillmentSaga{
+				OrderID:         "orderID",
+				Description:     "test description",
+				IsDeliveryOrder: true,
+				Approved:        true,
+				Cancelled:       true,
+			},
+		},
+	}
+
+	for i, c := range cases {
+		store := memory.NewSagaStore()
+		manager := saga.NewManager(store, saga.WithDeadlines(memory.NewDeadlineStore()))
+		newSaga := func() saga.SagaAPI {
+			return New(&mockOrderSvc{Expected: "orderID"})
+		}
+		manager.Register(newSaga)
+		dispatcher := saga.NewOutboxDispatcher(manager, store)
+		HandleIntents(dispatcher, &mockApprovalSvc{}, &mockDeliverySvc{})
+
+		var sagaID string
+		for _, e := range c.Events {
+			_, e.EventType = eventsource.GetTypeName(e.Data)
+			if err := manager.ProcessEvent(context.Background(), e, newSaga()); err != nil {
+				t.Fatalf("Cases[%d] FAILED: %s.  Error processing %s: %s", i, c.Label, e.EventType, err)
+			}
+			// Submissions to the vendor systems are made from the outbox, before their callbacks arrive
+			if _, err := dispatcher.RunPending(context.Background(), time.Now()); err != nil {
+				t.Fatalf("Cases[%d] FAILED: %s.  Error dispatching intents after %s: %s", i, c.Label, e.EventType, err)
+			}
+			if w, err := store.Load(context.Background(), &saga.SagaAssociation{ID: "orderID", AssociationType: "OrderID"}, testSaga.Type()); err == nil {
+				sagaID = w.ID
+			}
+		}
+
+		if _, err := store.Load(context.Background(), &saga.SagaAssociation{ID: "orderID", AssociationType: "OrderID"}, testSaga.Type()); err == nil {
+			t.Errorf("Cases[%d] FAILED: %s.  Expected the saga's associations to be removed once it ended", i, c.Label)
+		}
+		w, err := store.Archived(sagaID)
+		if err != nil {
+			t.Errorf("Cases[%d] FAILED: %s.  Expected the saga to be archived, got: %s", i, c.Label, err)
+			continue
+		}
+		got := &OrderFulfillmentSaga{}
+		if err := got.Load(w.Data, w.Version); err != nil {
+			t.Fatal(err)
+		}
+		if diff := deep.Equal(got, c.Expected); diff != nil {
+			t.Errorf("Cases[%d] FAILED: %s.  Error: %s", i, c.Label, diff)
+		}
+	}
+}
+
+func TestOrderFulfillmentSaga_ProcessEvent_EarlyCallback(t *testing.T) {
+	store := memory.NewSagaStore()
+	manager := saga.NewManager(store, saga.WithDeadlines(memory.NewDeadlineStore()), saga.IgnoreUnknownSagas())
+	newSaga := func() saga.SagaAPI {
+		return New(&mockOrderSvc{Expected: "orderID"})
+	}
+	manager.Register(newSaga)
+	dispatcher := saga.NewOutboxDispatcher(manager, store)
+	HandleIntents(dispatcher, &mockApprovalSvc{}, &mockDeliverySvc{})
+
+	process := func(e eventsource.Event) error {
+		_, e.EventType = eventsource.GetTypeName(e.Data)
+		return manager.ProcessEvent(context.Background(), e, newSaga())
+	}
+	for _, e := range []eventsource.Event{orderStartedEvent, submittedEvent} {
+		if err := process(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The approval arrives before the outbox has saved the approval ID, so it fails to be redelivered
+	err := process(approvalReceived)
+	if _, ok := err.(*saga.SagaAssociationPendingError); !ok {
+		t.Fatalf("Expected a SagaAssociationPendingError, got: %v", err)
+	}
+
+	if _, err := dispatcher.RunPending(context.Background(), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := process(approvalReceived); err != nil {
+		t.Fatalf("Expected the redelivered approval to be processed, got: %s", err)
+	}
+	if _, err := store.Load(context.Background(), &saga.SagaAssociation{ID: "orderID", AssociationType: "OrderID"}, testSaga.Type()); err == nil {
+		t.Errorf("Expected the approved pickup order's saga to end")
+	}
+}
+
+func TestOrderFulfillmentSaga_ProcessEvent_Metadata(t *testing.T) {
+	store := memory.NewSagaStore()
+	manager := saga.NewManager(store, saga.WithDeadlines(memory.NewDeadlineStore()))
+	orderSvc := &mockOrderSvc{}
+	approvalSvc := &mockApprovalSvc{}
+	newSaga := func() saga.SagaAPI { return New(orderSvc) }
+	manager.Register(newSaga)
+	dispatcher := saga.NewOutboxDispatcher(manager, store)
+	HandleIntents(dispatcher, approvalSvc, &mockDeliverySvc{})
+
+	request := eventsource.Metadata{CorrelationID: "request", CausationID: "request", UserID: "user"}
+	submitted := submittedEvent
+	submitted.EventID = "submitted"
+	submitted.Metadata = request
+	received := approvalReceived
+	received.EventID = "received"
+	received.Metadata = eventsource.Metadata{CorrelationID: "request", CausationID: "approval", UserID: "user"}
+
+	for _, e := range []eventsource.Event{orderStartedEvent, submitted, received} {
+		_, e.EventType = eventsource.GetTypeName(e.Data)
+		if err := manager.ProcessEvent(context.Background(), e, newSaga()); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := dispatcher.RunPending(context.Background(), time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(approvalSvc.Metadata) != 1 {
+		t.Fatalf("Expected the order to be submitted for approval once, got %d", len(approvalSvc.Metadata))
+	}
+	// The submission is caused by the saga's intent, rather than the event directly
+	if got := approvalSvc.Metadata[0]; got.CorrelationID != "request" || got.UserID != "user" || got.CausationID == "submitted" {
+		t.Errorf("Expected the submission to continue the request's correlation, got %+v", got)
+	}
+
+	expected := []eventsource.Metadata{{CorrelationID: "request", CausationID: "received", UserID: "user"}}
+	if diff := deep.Equal(orderSvc.Metadata, expected); diff != nil {
+		t.Errorf("Expected the approval to be caused by the ApprovalReceived event: %s", diff)
+	}
+}
+
+func TestHandleIntents(t *testing.T) {
+	cases := []struct {
+		Label       string
+		ApprovalSvc *mockApprovalSvc
+		DeliverySvc *mockDeliverySvc
+		Events      []eventsource.Event
+		ShouldError bool
+	}{
+		{
+			Label:       "submits the order for approval and associates the approval",
+			ApprovalSvc: &mockApprovalSvc{Expected: &approval.OrderApproval{Description: "test description"}},
+			DeliverySvc: &mockDeliverySvc{},
+			Events:      []eventsource.Event{orderStartedEvent, submittedEvent, approvalReceived},
+		},
+		{
+			Label:       "submits the order for delivery and associates the delivery",
+			ApprovalSvc: &mockApprovalSvc{},
+			DeliverySvc: &mockDeliverySvc{Expected: &delivery.OrderDelivery{Description: "test description"}},
+			Events:      []eventsource.Event{orderStartedEvent, serviceTypeSetEvent, submittedEvent, approvalReceived, deliveryConfirmed},
+		},
+		{
+			Label:       "keeps the intent in the outbox when the approval service fails",
+			ApprovalSvc: &mockApprovalSvc{ShouldError: true},
+			DeliverySvc: &mockDeliverySvc{},
+			Events:      []eventsource.Event{orderStartedEvent, submittedEvent},
+			ShouldError: true,
+		},
+		{
+			Label:       "keeps the intent in the outbox when the delivery service fails",
+			ApprovalSvc: &mockApprovalSvc{},
+			DeliverySvc: &mockDeliverySvc{ShouldError: true},
+			Events:      []eventsource.Event{orderStartedEvent, serviceTypeSetEvent, submittedEvent, approvalReceived},
+			ShouldError: true,
+		},
+	}
+
+	for i, c := range cases {
+		store := memory.NewSagaStore()
+		manager := saga.NewManager(store, saga.WithDeadlines(memory.NewDeadlineStore()))
+		manager.Register(func() saga.SagaAPI { return New(&mockOrderSvc{}) })
+		dispatcher := saga.NewOutboxDispatcher(manager, store)
+		dispatcher.Backoff = nil
+		HandleIntents(dispatcher, c.ApprovalSvc, c.DeliverySvc)
+
+		var err error
+		for _, e := range c.Events {
+			_, e.EventType = eventsource.GetTypeName(e.Data)
+			if err = manager.Dispatch(context.Background(), e); err != nil {
+				break
+			}
+			if _, err = dispatcher.RunPending(context.Background(), time.Now()); err != nil {
+				break
+			}
+		}
+		if c.ShouldError != (err != nil) {
+			t.Errorf("Cases[%d] FAILED: %s.  Error: %v", i, c.Label, err)
+			continue
+		}
+
+		pending, _ := store.Pending(context.Background(), time.Now().Add(time.Hour))
+		if c.ShouldError && len(pending) != 1 {
+			t.Errorf("Cases[%d] FAILED: %s.  Expected the failed intent to be pending, got %d", i, c.Label, len(pending))
+		}
+		if !c.ShouldError && len(pending) != 0 {
+			t.Errorf("Cases[%d] FAILED: %s.  Expected no pending intents, got %d", i, c.Label, len(pending))
+		}
+	}
+}
+
 type mockOrderSvc struct {
 	order.ServiceAPI
 	Expected    interface{}
 	ShouldError bool
+	// Metadata records the metadata each command was issued with
+	Metadata []eventsource.Metadata
 }
 
-func (m *mockOrderSvc) ApproveOrder(orderId string) error {
+func (m *mockOrderSvc) ApproveOrder(ctx context.Context, orderId string) error {
+	m.Metadata = append(m.Metadata, eventsource.MetadataFrom(ctx))
 	if m.ShouldError {
 		return fmt.Errorf("Error in ApproveOrder")
 	}
@@ -371,7 +824,8 @@ func (m *mockOrderSvc) ApproveOrder(orderId string) error {
 	return nil
 }
 
-func (m *mockOrderSvc) DeliverOrder(orderId string) error {
+func (m *mockOrderSvc) DeliverOrder(ctx context.Context, orderId string) error {
+	m.Metadata = append(m.Metadata, eventsource.MetadataFrom(ctx))
 	if m.ShouldError {
 		return fmt.Errorf("Error in DeliverOrder")
 	}
@@ -383,13 +837,41 @@ func (m *mockOrderSvc) DeliverOrder(orderId string) error {
 	return nil
 }
 
+func (m *mockOrderSvc) RejectOrder(ctx context.Context, orderId string, reason string) error {
+	m.Metadata = append(m.Metadata, eventsource.MetadataFrom(ctx))
+	if m.ShouldError {
+		return fmt.Errorf("Error in RejectOrder")
+	}
+
+	if diff := deep.Equal(orderId, m.Expected); m.Expected != nil && diff != nil {
+		return fmt.Errorf("OrderID in OrderService does not match expected, details: %s", diff)
+	}
+
+	return nil
+}
+
+func (m *mockOrderSvc) CancelOrder(ctx context.Context, orderId string, reason string) error {
+	m.Metadata = append(m.Metadata, eventsource.MetadataFrom(ctx))
+	if m.ShouldError {
+		return fmt.Errorf("Error in CancelOrder")
+	}
+
+	if diff := deep.Equal(orderId, m.Expected); m.Expected != nil && diff != nil {
+		return fmt.Errorf("OrderID in OrderService does not match expected, details: %s", diff)
+	}
+
+	return nil
+}
+
 type mockApprovalSvc struct {
 	approval.ServiceAPI
 	Expected    *approval.OrderApproval
 	ShouldError bool
+	Metadata    []eventsource.Metadata
 }
 
-func (m *mockApprovalSvc) SubmitOrderForApproval(a *approval.OrderApproval) (*approval.OrderApproval, error) {
+func (m *mockApprovalSvc) SubmitOrderForApproval(ctx context.Context, a *approval.OrderApproval) (*approval.OrderApproval, error) {
+	m.Metadata = append(m.Metadata, eventsource.MetadataFrom(ctx))
 	if m.ShouldError {
 		return nil, fmt.Errorf("Error in SubmitOrderForApproval")
 	}
@@ -409,7 +891,7 @@ type mockDeliverySvc struct {
 	ShouldError bool
 }
 
-func (m *mockDeliverySvc) SubmitOrderForDelivery(d *delivery.OrderDelivery) (*delivery.OrderDelivery, error) {
+func (m *mockDeliverySvc) SubmitOrderForDelivery(_ context.Context, d *delivery.OrderDelivery) (*delivery.OrderDelivery, error) {
 	if m.ShouldError {
 		return nil, fmt.Errorf("Error in SubmitOrderForDelivery")
 	}
@@ -10,6 +10,7 @@ import (
 	"strconv"
 	"sync"
 
+	"forge.lmig.com/n1505471/pizza-shop/eventsource"
 	es "forge.lmig.com/n1505471/pizza-shop/eventsource/store/dynamodb"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go/aws"
@@ -124,6 +125,7 @@ func HandleRequest(ctx context.Context, e DynamoEvent) error {
 						},
 					},
 				}
+				addMetadataAttributes(i.MessageAttributes, event.Metadata)
 
 				log.Printf("Publish Input: %+v", i)
 
@@ -165,3 +167,22 @@ type DynamoEventRecord struct {
 type DynamoEvent struct {
 	Records []DynamoEventRecord `json:"Records"`
 }
+
+// addMetadataAttributes forwards the event's metadata, so subscribers can filter and trace
+// events by it.  SNS doesn't accept empty attributes, so unset fields are left out.
+func addMetadataAttributes(attributes map[string]*sns.MessageAttributeValue, m eventsource.Metadata) {
+	fields := map[string]string{
+		"correlationId": m.CorrelationID,
+		"causationId":   m.CausationID,
+		"userId":        m.UserID,
+	}
+	for name, value := range fields {
+		if value == "" {
+			continue
+		}
+		attributes[name] = &sns.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(value),
+		}
+	}
+}
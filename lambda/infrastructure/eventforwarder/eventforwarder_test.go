@@ -16,6 +16,7 @@ import (
 	"testing"
 	"time"
 
+	"forge.lmig.com/n1505471/pizza-shop/eventsource"
 	es "forge.lmig.com/n1505471/pizza-shop/eventsource/store/dynamodb"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
@@ -45,6 +46,22 @@ func TestHandleRequest(t *testing.T) {
 				"test": "test",
 			},
 		},
+		{
+			EventID:          "causedEventId",
+			AggregateID:      "aggregateId",
+			AggregateType:    "aggregateType",
+			EventTypeVersion: 1,
+			EventType:        "TestType",
+			Timestamp:        time.Now(),
+			Metadata: eventsource.Metadata{
+				CorrelationID: "correlationId",
+				CausationID:   "eventId",
+				UserID:        "userId",
+			},
+			RawData: map[string]interface{}{
+				"test": "test",
+			},
+		},
 	}
 
 	var encoded []map[string]*dynamodb.AttributeValue
@@ -108,6 +125,58 @@ func TestHandleRequest(t *testing.T) {
 				},
 			},
 		},
+		{
+			Event: DynamoEvent{
+				Records: []DynamoEventRecord{
+					{
+						EventName: "INSERT",
+						Change: DynamoEventChange{
+							NewImage: encoded[1],
+						},
+					},
+				},
+			},
+			ExpectedS3: []*s3.PutObjectInput{
+				{
+					Bucket:      bucketName,
+					ContentType: aws.String("application/json"),
+					Key:         aws.String(fmt.Sprintf("events/%s--%s--%s", records[1].Timestamp.Format("2006-01-02T15:04:05.999Z"), records[1].EventType, records[1].EventID)),
+					Body:        aws.ReadSeekCloser(bytes.NewReader(raw[1])),
+				},
+			},
+			ExpectedSNS: []*sns.PublishInput{
+				{
+					TopicArn: eventBus,
+					Message:  aws.String(string(raw[1])),
+					MessageAttributes: map[string]*sns.MessageAttributeValue{
+						"eventType": {
+							DataType:    aws.String("String"),
+							StringValue: aws.String(records[1].EventType),
+						},
+						"eventVersion": {
+							DataType:    aws.String("Number"),
+							StringValue: aws.String(strconv.Itoa(records[1].EventTypeVersion)),
+						},
+						"eventId": {
+							DataType:    aws.String("String"),
+							StringValue: aws.String(records[1].EventID),
+						},
+						"correlationId": {
+							DataType:    aws.String("String"),
+							StringValue: aws.String("correlationId"),
+						},
+						"causationId": {
+							DataType:    aws.String("String"),
+							StringValue: aws.String("eventId"),
+						},
+						"userId": {
+							DataType:    aws.String("String"),
+							StringValue: aws.String("userId"),
+						},
+					},
+				},
+			},
+		},
 	}
 
 	for _, c := range cases {
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+
+	es "forge.lmig.com/n1505471/pizza-shop/eventsource/store/dynamodb"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// appender adds saved events to the global stream
+type appender interface {
+	Append(ctx context.Context, items []map[string]*dynamodb.AttributeValue) error
+}
+
+var sequencer appender
+
+func main() {
+	db := dynamodb.New(session.New(), aws.NewConfig())
+	sequencer = es.NewSequencer(db, os.Getenv("STREAM_TABLE_NAME"))
+	lambda.Start(HandleRequest)
+}
+
+// HandleRequest appends the events saved in the batch to the global stream, in the order they
+// were saved.  An error fails the batch, which the stream redelivers until it's appended, so
+// the events after it in the shard wait rather than take earlier positions.
+func HandleRequest(ctx context.Context, e DynamoEvent) error {
+	var items []map[string]*dynamodb.AttributeValue
+	for _, r := range e.Records {
+		// Events are never updated, only inserted
+		if r.EventName != "INSERT" {
+			continue
+		}
+		items = append(items, r.Change.NewImage)
+	}
+	if len(items) == 0 {
+		return nil
+	}
+
+	if err := sequencer.Append(ctx, items); err != nil {
+		log.Printf("Error appending %d events to the stream: %s", len(items), err)
+		return err
+	}
+	return nil
+}
+
+type DynamoEventChange struct {
+	NewImage map[string]*dynamodb.AttributeValue `json:"NewImage"`
+	// ... more fields if needed: https://docs.aws.amazon.com/amazondynamodb/latest/APIReference/API_streams_GetRecords.html
+}
+
+type DynamoEventRecord struct {
+	Change    DynamoEventChange `json:"dynamodb"`
+	EventName string            `json:"eventName"`
+	EventID   string            `json:"eventID"`
+	// ... more fields if needed: https://docs.aws.amazon.com/amazondynamodb/latest/APIReference/API_streams_GetRecords.html
+}
+
+type DynamoEvent struct {
+	Records []DynamoEventRecord `json:"Records"`
+}
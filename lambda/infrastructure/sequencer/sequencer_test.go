@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/go-test/deep"
+)
+
+// SETUP
+func TestMain(m *testing.M) {
+	log.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+func TestHandleRequest(t *testing.T) {
+	first := map[string]*dynamodb.AttributeValue{
+		"aggregateId":       {S: aws.String("aggregateId")},
+		"aggregateSequence": {N: aws.String("1")},
+	}
+	second := map[string]*dynamodb.AttributeValue{
+		"aggregateId":       {S: aws.String("aggregateId")},
+		"aggregateSequence": {N: aws.String("2")},
+	}
+
+	cases := []struct {
+		Label       string
+		Event       DynamoEvent
+		AppendErr   error
+		Expected    [][]map[string]*dynamodb.AttributeValue
+		ShouldError bool
+	}{
+		{
+			Label: "appends the inserted events in order",
+			Event: DynamoEvent{
+				Records: []DynamoEventRecord{
+					{EventName: "INSERT", Change: DynamoEventChange{NewImage: first}},
+					{EventName: "INSERT", Change: DynamoEventChange{NewImage: second}},
+				},
+			},
+			Expected: [][]map[string]*dynamodb.AttributeValue{{first, second}},
+		},
+		{
+			Label: "ignores changes other than inserts",
+			Event: DynamoEvent{
+				Records: []DynamoEventRecord{
+					{EventName: "MODIFY", Change: DynamoEventChange{NewImage: first}},
+					{EventName: "REMOVE"},
+				},
+			},
+		},
+		{
+			Label: "fails the batch when the events can't be appended",
+			Event: DynamoEvent{
+				Records: []DynamoEventRecord{
+					{EventName: "INSERT", Change: DynamoEventChange{NewImage: first}},
+				},
+			},
+			AppendErr:   fmt.Errorf("I am error"),
+			Expected:    [][]map[string]*dynamodb.AttributeValue{{first}},
+			ShouldError: true,
+		},
+	}
+
+	for i, c := range cases {
+		mock := &mockAppender{err: c.AppendErr}
+		sequencer = mock
+
+		err := HandleRequest(context.Background(), c.Event)
+		if c.ShouldError != (err != nil) {
+			t.Errorf("Cases[%d] FAILED: %s.  Error: %v", i, c.Label, err)
+		}
+		if diff := deep.Equal(mock.appended, c.Expected); diff != nil {
+			t.Errorf("Cases[%d] FAILED: %s.  Error: %s", i, c.Label, diff)
+		}
+	}
+}
+
+type mockAppender struct {
+	appended [][]map[string]*dynamodb.AttributeValue
+	err      error
+}
+
+func (m *mockAppender) Append(_ context.Context, items []map[string]*dynamodb.AttributeValue) error {
+	m.appended = append(m.appended, items)
+	return m.err
+}
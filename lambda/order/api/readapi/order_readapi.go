@@ -2,11 +2,16 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"time"
 
+	"forge.lmig.com/n1505471/pizza-shop/eventsource"
+	ddbES "forge.lmig.com/n1505471/pizza-shop/eventsource/store/dynamodb"
+	"forge.lmig.com/n1505471/pizza-shop/internal/domain/order"
 	domain "forge.lmig.com/n1505471/pizza-shop/internal/domain/order/model"
 	"forge.lmig.com/n1505471/pizza-shop/internal/projections/order/model"
 	"forge.lmig.com/n1505471/pizza-shop/internal/projections/order/repository"
@@ -19,11 +24,23 @@ import (
 
 var svc = dynamodb.New(session.New(), aws.NewConfig())
 var repo = repository.NewRepository(svc, os.Getenv("TABLE_NAME"))
+var events eventsource.EventStorer = ddbES.New(svc, os.Getenv("EVENTS_TABLE_NAME"))
 var router = httprouter.New()
 
+// Page sizes for GET /orders
+const (
+	defaultLimit = 25
+	maxLimit     = 100
+)
+
+// cursorHeader carries the cursor for the next page of GET /orders, leaving the body a plain
+// array of orders.  It's left out of the last page.
+const cursorHeader = "Next-Cursor"
+
 func init() {
 	router.GET("/orders", queryAllOrders)
 	router.GET("/orders/:orderID", getOrder)
+	router.GET("/orders/:orderID/history", getOrderHistory)
 }
 
 func main() {
@@ -34,20 +51,33 @@ func main() {
  * Routes
  */
 
+// queryAllOrders lists a page of orders, e.g. /orders?status=Submitted&serviceType=Delivery&sort=updatedAt&order=desc&limit=10&cursor=...
+// The cursor for the next page is returned in the Next-Cursor header.
 func queryAllOrders(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 
-	orders, err := repo.QueryAllOrders()
+	query, err := parseOrderQuery(r)
 	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := repo.QueryOrders(r.Context(), query)
+	if err != nil {
+		if _, ok := err.(*repository.InvalidQueryError); ok {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		http.Error(w, err.Error(), 500)
 		return
 	}
-	var resources []*orderResource
-	for _, o := range orders {
+	resources := []*orderResource{}
+	for _, o := range page.Orders {
 		resources = append(resources, resourceFromOrder(o))
 	}
 
-	log.Printf("Orders: %+v", orders)
-
+	if page.Cursor != "" {
+		w.Header().Set(cursorHeader, page.Cursor)
+	}
 	jsonResponse(w, resources)
 }
 
@@ -55,7 +85,12 @@ func getOrder(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
 
 	orderID := p.ByName("orderID")
 
-	order, err := repo.GetOrder(orderID)
+	if v := r.URL.Query().Get("asOf"); v != "" {
+		getOrderAt(w, r, orderID, v)
+		return
+	}
+
+	order, err := repo.GetOrder(r.Context(), orderID)
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
@@ -65,6 +100,59 @@ func getOrder(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
 	jsonResponse(w, resourceFromOrder(order))
 }
 
+// getOrderAt rebuilds the order from its events as of a sequence number or RFC 3339 timestamp.
+// Ids of other types of aggregate aren't found.
+func getOrderAt(w http.ResponseWriter, r *http.Request, orderID string, v string) {
+
+	asOf, err := parseAsOf(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	a := &order.Aggregate{}
+	a.Init(orderID)
+	err = eventsource.New(events).LoadAggregateAt(r.Context(), a, asOf)
+	if _, ok := err.(*eventsource.AggregateTypeError); ok {
+		http.Error(w, fmt.Sprintf("Order %s not found", orderID), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if a.Sequence == 0 {
+		http.Error(w, fmt.Sprintf("Order %s not found as of %s", orderID, v), http.StatusNotFound)
+		return
+	}
+	log.Printf("Order as of %s: %+v", v, a)
+
+	jsonResponse(w, resourceFromAggregate(a))
+}
+
+// getOrderHistory lists every event stored for the order, in sequence order
+func getOrderHistory(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	orderID := p.ByName("orderID")
+
+	history, err := events.EventsForAggregate(r.Context(), orderID)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if len(history) == 0 || history[0].AggregateType != (&order.Aggregate{}).Type() {
+		http.Error(w, fmt.Sprintf("Order %s not found", orderID), http.StatusNotFound)
+		return
+	}
+
+	resources := make([]*eventResource, len(history))
+	for i, e := range history {
+		resources[i] = resourceFromEvent(e)
+	}
+
+	jsonResponse(w, resources)
+}
+
 /*
  * Resources
  */
@@ -95,10 +183,98 @@ func resourceFromOrder(o *model.Order) *orderResource {
 	}
 }
 
+// resourceFromAggregate maps a rebuilt order, which doesn't track when it was created or updated
+func resourceFromAggregate(a *order.Aggregate) *orderResource {
+	return &orderResource{
+		OrderID:     a.OrderID,
+		ServiceType: a.ServiceType,
+		Status:      a.Status,
+		Description: a.Description,
+	}
+}
+
+type eventResource struct {
+	EventID  string `json:"eventId"`
+	Sequence int    `json:"sequence"`
+	Type     string `json:"type"`
+	// Version is the version the event was stored with, Data is upcast to the latest version
+	Version   int         `json:"version"`
+	Timestamp time.Time   `json:"timestamp"`
+	Data      interface{} `json:"data"`
+}
+
+func resourceFromEvent(e eventsource.Event) *eventResource {
+	return &eventResource{
+		EventID:   e.EventID,
+		Sequence:  e.AggregateSequence,
+		Type:      e.EventType,
+		Version:   e.EventTypeVersion,
+		Timestamp: e.Timestamp,
+		Data:      e.Data,
+	}
+}
+
 /*
  * Helpers
  */
 
+// parseAsOf reads an aggregate sequence number, or otherwise an RFC 3339 timestamp
+func parseAsOf(v string) (eventsource.AsOf, error) {
+	if sequence, err := strconv.Atoi(v); err == nil {
+		if sequence < 1 {
+			return eventsource.AsOf{}, fmt.Errorf("invalid asOf %q, sequences start at 1", v)
+		}
+		return eventsource.AsOf{Sequence: sequence}, nil
+	}
+	timestamp, err := time.Parse(time.RFC3339Nano, v)
+	if err != nil {
+		return eventsource.AsOf{}, fmt.Errorf("invalid asOf %q, expected a sequence number or RFC 3339 timestamp", v)
+	}
+	return eventsource.AsOf{Timestamp: timestamp}, nil
+}
+
+func parseOrderQuery(r *http.Request) (*repository.OrderQuery, error) {
+	params := r.URL.Query()
+	query := &repository.OrderQuery{
+		SortBy: params.Get("sort"),
+		Cursor: params.Get("cursor"),
+		Limit:  defaultLimit,
+	}
+
+	if v := params.Get("status"); v != "" {
+		status, err := domain.ParseStatus(v)
+		if err != nil {
+			return nil, err
+		}
+		query.Status = status
+	}
+	if v := params.Get("serviceType"); v != "" {
+		serviceType, err := domain.ParseServiceType(v)
+		if err != nil {
+			return nil, err
+		}
+		query.ServiceType = serviceType
+	}
+
+	switch params.Get("order") {
+	case "", "asc":
+	case "desc":
+		query.Descending = true
+	default:
+		return nil, fmt.Errorf("invalid order %q, expected asc or desc", params.Get("order"))
+	}
+
+	if v := params.Get("limit"); v != "" {
+		limit, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || limit < 1 || limit > maxLimit {
+			return nil, fmt.Errorf("invalid limit %q, expected 1 to %d", v, maxLimit)
+		}
+		query.Limit = limit
+	}
+
+	return query, nil
+}
+
 func jsonResponse(w http.ResponseWriter, body interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"forge.lmig.com/n1505471/pizza-shop/eventsource"
+	"forge.lmig.com/n1505471/pizza-shop/eventsource/store/memory"
+	"forge.lmig.com/n1505471/pizza-shop/internal/domain/order/event"
+	"forge.lmig.com/n1505471/pizza-shop/internal/domain/order/model"
+	"github.com/go-test/deep"
+)
+
+// SETUP
+func TestMain(m *testing.M) {
+	log.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+var timestamp = time.Date(2020, 4, 19, 19, 45, 11, 0, time.UTC)
+
+func seedEvents() {
+	store := memory.New()
+	store.SaveEvents(context.Background(), []eventsource.Event{
+		{
+			EventID:           "a",
+			AggregateID:       "orderId",
+			AggregateType:     "OrderAggregate",
+			AggregateSequence: 1,
+			EventTypeVersion:  2,
+			EventType:         "OrderStartedEvent",
+			Timestamp:         timestamp,
+			Data:              &event.OrderStartedEvent{OrderID: "orderId", ServiceType: model.Pickup, Description: "Pizza!"},
+		},
+		{
+			EventID:           "b",
+			AggregateID:       "orderId",
+			AggregateType:     "OrderAggregate",
+			AggregateSequence: 2,
+			EventTypeVersion:  1,
+			EventType:         "OrderSubmitted",
+			Timestamp:         timestamp.Add(time.Minute),
+			Data:              &event.OrderSubmitted{OrderID: "orderId"},
+		},
+		{
+			EventID:           "c",
+			AggregateID:       "approvalId",
+			AggregateType:     "ApprovalAggregate",
+			AggregateSequence: 1,
+			EventTypeVersion:  1,
+			EventType:         "ApprovalRequested",
+			Timestamp:         timestamp,
+		},
+	})
+	events = store
+}
+
+func TestGetOrderHistory(t *testing.T) {
+	seedEvents()
+
+	cases := []struct {
+		Label          string
+		Path           string
+		ExpectedStatus int
+		Expected       []map[string]interface{}
+	}{
+		{
+			Label:          "lists the order's events in sequence order",
+			Path:           "/orders/orderId/history",
+			ExpectedStatus: http.StatusOK,
+			Expected: []map[string]interface{}{
+				{
+					"eventId":   "a",
+					"sequence":  float64(1),
+					"type":      "OrderStartedEvent",
+					"version":   float64(2),
+					"timestamp": "2020-04-19T19:45:11Z",
+					"data":      map[string]interface{}{"orderId": "orderId", "serviceType": "Pickup", "description": "Pizza!"},
+				},
+				{
+					"eventId":   "b",
+					"sequence":  float64(2),
+					"type":      "OrderSubmitted",
+					"version":   float64(1),
+					"timestamp": "2020-04-19T19:46:11Z",
+					"data":      map[string]interface{}{"orderId": "orderId"},
+				},
+			},
+		},
+		{
+			Label:          "returns not found for unknown orders",
+			Path:           "/orders/missing/history",
+			ExpectedStatus: http.StatusNotFound,
+		},
+		{
+			Label:          "returns not found for other aggregates",
+			Path:           "/orders/approvalId/history",
+			ExpectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for i, c := range cases {
+		req, _ := http.NewRequest("GET", c.Path, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		if rr.Code != c.ExpectedStatus {
+			t.Errorf("Cases[%d] FAILED: %s.  Expected status %d, got %d", i, c.Label, c.ExpectedStatus, rr.Code)
+			continue
+		}
+		if c.Expected == nil {
+			continue
+		}
+
+		var got []map[string]interface{}
+		if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+			t.Errorf("Cases[%d] FAILED: %s.  Error: %s", i, c.Label, err)
+			continue
+		}
+		if diff := deep.Equal(got, c.Expected); diff != nil {
+			t.Errorf("Cases[%d] FAILED: %s.  Error: %s", i, c.Label, diff)
+		}
+	}
+}
+
+func TestGetOrder_AsOf(t *testing.T) {
+	seedEvents()
+
+	cases := []struct {
+		Label          string
+		OrderID        string
+		AsOf           string
+		ExpectedStatus int
+		Expected       map[string]interface{}
+	}{
+		{
+			Label:          "rebuilds the order as of a sequence",
+			OrderID:        "orderId",
+			AsOf:           "1",
+			ExpectedStatus: http.StatusOK,
+			Expected: map[string]interface{}{
+				"orderId":     "orderId",
+				"serviceType": "Pickup",
+				"status":      "Started",
+				"description": "Pizza!",
+				"createdAt":   nil,
+				"updatedAt":   nil,
+			},
+		},
+		{
+			Label:          "rebuilds the order as of a timestamp",
+			OrderID:        "orderId",
+			AsOf:           "2020-04-19T19:50:00Z",
+			ExpectedStatus: http.StatusOK,
+			Expected: map[string]interface{}{
+				"orderId":     "orderId",
+				"serviceType": "Pickup",
+				"status":      "Submitted",
+				"description": "Pizza!",
+				"createdAt":   nil,
+				"updatedAt":   nil,
+			},
+		},
+		{
+			Label:          "returns not found before the order started",
+			OrderID:        "orderId",
+			AsOf:           "2020-04-19T19:00:00Z",
+			ExpectedStatus: http.StatusNotFound,
+		},
+		{
+			Label:          "returns not found for other types of aggregate",
+			OrderID:        "approvalId",
+			AsOf:           "1",
+			ExpectedStatus: http.StatusNotFound,
+		},
+		{
+			Label:          "rejects malformed values",
+			OrderID:        "orderId",
+			AsOf:           "yesterday",
+			ExpectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for i, c := range cases {
+		req, _ := http.NewRequest("GET", "/orders/"+c.OrderID+"?asOf="+c.AsOf, nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		if rr.Code != c.ExpectedStatus {
+			t.Errorf("Cases[%d] FAILED: %s.  Expected status %d, got %d", i, c.Label, c.ExpectedStatus, rr.Code)
+			continue
+		}
+		if c.Expected == nil {
+			continue
+		}
+
+		var got map[string]interface{}
+		if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+			t.Errorf("Cases[%d] FAILED: %s.  Error: %s", i, c.Label, err)
+			continue
+		}
+		if diff := deep.Equal(got, c.Expected); diff != nil {
+			t.Errorf("Cases[%d] FAILED: %s.  Error: %s", i, c.Label, diff)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+
+	"forge.lmig.com/n1505471/pizza-shop/eventsource/idempotency"
+	"github.com/julienschmidt/httprouter"
+)
+
+// idempotencyHeader lets clients retry a command safely, a repeated key replays the original response
+const idempotencyHeader = "Idempotency-Key"
+
+// replayedHeader marks a response replayed for a repeated idempotency key
+const replayedHeader = "Idempotent-Replayed"
+
+// idempotencyTTL is how long a response is replayed for
+const idempotencyTTL = 24 * time.Hour
+
+// idempotencyLease is how long a key is held in progress.  It's longer than the function's
+// timeout, so a request still running keeps its key, and a key held by a request which crashed
+// can be claimed again once it lapses.
+const idempotencyLease = 5 * time.Minute
+
+// recordTimeout bounds completing or releasing a key, which happens after the handler returns,
+// when the request's context may already be cancelled
+const recordTimeout = 5 * time.Second
+
+// idempotent processes a request with an Idempotency-Key once per command type, replaying the
+// response to later requests with the same key.  A key reused for a different request is
+// rejected.  Server errors aren't recorded, so the request can be retried with the same key.
+// Requests without the header are always processed.
+func (c *Controller) idempotent(commandType string, handle httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		key := r.Header.Get(idempotencyHeader)
+		if key == "" || c.idempotency == nil {
+			handle(w, r, p)
+			return
+		}
+
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			errorResponse(w, err, http.StatusBadRequest)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		now := time.Now()
+		record := &idempotency.Record{
+			CommandType: commandType,
+			Key:         key,
+			Fingerprint: idempotency.Fingerprint([]byte(r.URL.Path), body),
+			ExpiresAt:   now.Add(idempotencyLease),
+		}
+		existing, claimed, err := c.idempotency.Claim(r.Context(), record, now)
+		if err != nil {
+			errorResponse(w, err, http.StatusInternalServerError)
+			return
+		}
+		if !claimed {
+			replayResponse(w, record, existing)
+			return
+		}
+
+		rec := &responseRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+		handle(rec, r, p)
+
+		ctx, cancel := context.WithTimeout(context.Background(), recordTimeout)
+		defer cancel()
+
+		if rec.statusCode < http.StatusInternalServerError {
+			completed := *record
+			completed.Completed = true
+			completed.StatusCode = rec.statusCode
+			completed.ContentType = rec.Header().Get("content-type")
+			completed.Body = rec.body.Bytes()
+			completed.ExpiresAt = time.Now().Add(idempotencyTTL)
+			err = c.idempotency.Complete(ctx, &completed)
+			if err == nil {
+				return
+			}
+			log.Printf("Error recording the response for idempotency key %s, details: %s", key, err)
+		}
+
+		// Free the key, so a retry isn't told the request is still in progress
+		if err := c.idempotency.Release(ctx, record); err != nil {
+			log.Printf("Error releasing idempotency key %s, details: %s", key, err)
+		}
+	}
+}
+
+// replayResponse writes the response recorded for the key, unless the key was used for a
+// different request or its request is still being processed
+func replayResponse(w http.ResponseWriter, record *idempotency.Record, existing *idempotency.Record) {
+	if existing.Fingerprint != record.Fingerprint {
+		errorResponse(w, fmt.Errorf("Idempotency key %s has already been used for a different request.", record.Key), http.StatusUnprocessableEntity)
+		return
+	}
+	if !existing.Completed {
+		errorResponse(w, fmt.Errorf("A request with idempotency key %s is still being processed.", record.Key), http.StatusConflict)
+		return
+	}
+
+	log.Printf("Replaying the response for idempotency key %s", record.Key)
+	w.Header().Set("content-type", existing.ContentType)
+	w.Header().Set(replayedHeader, "true")
+	w.WriteHeader(existing.StatusCode)
+	if _, err := w.Write(existing.Body); err != nil {
+		log.Printf("Error replaying the response for idempotency key %s, details: %s", record.Key, err)
+	}
+}
+
+// responseRecorder keeps a copy of the response written through it
+type responseRecorder struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(code int) {
+	r.statusCode = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
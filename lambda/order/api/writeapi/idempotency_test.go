@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"forge.lmig.com/n1505471/pizza-shop/eventsource/idempotency"
+	"forge.lmig.com/n1505471/pizza-shop/eventsource/idempotency/store/memory"
+)
+
+func TestIdempotent(t *testing.T) {
+	svc := &mockOrderService{}
+	store := memory.New()
+	con := &Controller{
+		orderSvc:    svc,
+		idempotency: store,
+	}
+	router := httprouter.New()
+	con.registerRoutes(router)
+
+	started := `{"serviceType": "Pickup", "description": "Large Pepperoni"}`
+
+	// The same request is still being processed with the "in-progress" key
+	now := time.Now()
+	store.Claim(context.Background(), &idempotency.Record{
+		CommandType: "StartOrderCommand",
+		Key:         "in-progress",
+		Fingerprint: idempotency.Fingerprint([]byte("/orders"), []byte(started)),
+		ExpiresAt:   now.Add(time.Hour),
+	}, now)
+
+	cases := []struct {
+		Label            string
+		Method           string
+		Path             string
+		Key              string
+		Body             string
+		ExpectedStatus   int
+		ExpectedStarted  int
+		ExpectedReplayed string
+	}{
+		{
+			Label:           "processes the first request with a key",
+			Method:          "POST",
+			Path:            "/orders",
+			Key:             "key",
+			Body:            started,
+			ExpectedStatus:  http.StatusOK,
+			ExpectedStarted: 1,
+		},
+		{
+			Label:            "replays the response to a repeated request",
+			Method:           "POST",
+			Path:             "/orders",
+			Key:              "key",
+			Body:             started,
+			ExpectedStatus:   http.StatusOK,
+			ExpectedStarted:  1,
+			ExpectedReplayed: "true",
+		},
+		{
+			Label:           "rejects a key reused for a different request",
+			Method:          "POST",
+			Path:            "/orders",
+			Key:             "key",
+			Body:            `{"serviceType": "Delivery", "description": "Large Pepperoni"}`,
+			ExpectedStatus:  http.StatusUnprocessableEntity,
+			ExpectedStarted: 1,
+		},
+		{
+			Label:           "conflicts with a request still being processed",
+			Method:          "POST",
+			Path:            "/orders",
+			Key:             "in-progress",
+			Body:            started,
+			ExpectedStatus:  http.StatusConflict,
+			ExpectedStarted: 1,
+		},
+		{
+			Label:           "scopes keys to the command",
+			Method:          "POST",
+			Path:            "/orders/submit/orderId",
+			Key:             "key",
+			ExpectedStatus:  http.StatusOK,
+			ExpectedStarted: 1,
+		},
+		{
+			Label:           "processes requests without a key",
+			Method:          "POST",
+			Path:            "/orders",
+			Body:            started,
+			ExpectedStatus:  http.StatusOK,
+			ExpectedStarted: 2,
+		},
+		{
+			Label:           "processes repeated requests without a key",
+			Method:          "POST",
+			Path:            "/orders",
+			Body:            started,
+			ExpectedStatus:  http.StatusOK,
+			ExpectedStarted: 3,
+		},
+	}
+
+	for i, c := range cases {
+		req, _ := http.NewRequest(c.Method, c.Path, strings.NewReader(c.Body))
+		req.Header.Set("content-type", "application/json")
+		if c.Key != "" {
+			req.Header.Set(idempotencyHeader, c.Key)
+		}
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		if err := checkStatusCode(c.ExpectedStatus, rr); err != nil {
+			t.Errorf("Cases[%d] FAILED: %s.  Error: %s", i, c.Label, err)
+		}
+		if svc.started != c.ExpectedStarted {
+			t.Errorf("Cases[%d] FAILED: %s.  Expected %d orders started, got %d", i, c.Label, c.ExpectedStarted, svc.started)
+		}
+		if err := checkHeader(replayedHeader, c.ExpectedReplayed, rr); err != nil {
+			t.Errorf("Cases[%d] FAILED: %s.  Error: %s", i, c.Label, err)
+		}
+		if c.ExpectedReplayed != "" {
+			if err := checkHeader("content-type", "application/json", rr); err != nil {
+				t.Errorf("Cases[%d] FAILED: %s.  Error: %s", i, c.Label, err)
+			}
+			expected := map[string]interface{}{
+				"ok":     true,
+				"result": map[string]interface{}{"orderId": "orderId"},
+			}
+			if err := checkResponseBody(expected, map[string]interface{}{}, rr); err != nil {
+				t.Errorf("Cases[%d] FAILED: %s.  Error: %s", i, c.Label, err)
+			}
+		}
+	}
+}
+
+func TestIdempotent_ServerError(t *testing.T) {
+	store := memory.New()
+	con := &Controller{idempotency: store}
+	calls := 0
+	handle := con.idempotent("StartOrderCommand", func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		calls++
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	// A server error releases the key, so the retry is processed
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest("POST", "/orders", strings.NewReader(`{}`))
+		req.Header.Set(idempotencyHeader, "key")
+		handle(httptest.NewRecorder(), req, nil)
+	}
+	if calls != 2 {
+		t.Errorf("Expected the retry after a server error to be processed, got %d calls", calls)
+	}
+}
+
+func TestIdempotent_Lease(t *testing.T) {
+	store := &recordingStore{Store: memory.New()}
+	con := &Controller{idempotency: store}
+	handle := con.idempotent("StartOrderCommand", func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	// The request's context is cancelled by the time the response is recorded
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req, _ := http.NewRequest("POST", "/orders", strings.NewReader(`{}`))
+	req = req.WithContext(ctx)
+	req.Header.Set(idempotencyHeader, "key")
+	start := time.Now()
+	handle(httptest.NewRecorder(), req, nil)
+
+	if store.claimed == nil || store.claimed.ExpiresAt.After(start.Add(idempotencyLease+time.Minute)) {
+		t.Errorf("Expected the key to be claimed for the lease, got %+v", store.claimed)
+	}
+	if store.completed == nil || store.completed.ExpiresAt.Before(start.Add(idempotencyTTL)) {
+		t.Errorf("Expected the completed key to be kept for the TTL, got %+v", store.completed)
+	}
+	if store.completeErr != nil {
+		t.Errorf("Expected the response to be recorded with a live context, got %s", store.completeErr)
+	}
+}
+
+// recordingStore keeps the records claimed and completed, and whether Complete was called with a
+// context already done
+type recordingStore struct {
+	*memory.Store
+	claimed     *idempotency.Record
+	completed   *idempotency.Record
+	completeErr error
+}
+
+func (s *recordingStore) Claim(ctx context.Context, record *idempotency.Record, now time.Time) (*idempotency.Record, bool, error) {
+	claimed := *record
+	s.claimed = &claimed
+	return s.Store.Claim(ctx, record, now)
+}
+
+func (s *recordingStore) Complete(ctx context.Context, record *idempotency.Record) error {
+	completed := *record
+	s.completed = &completed
+	s.completeErr = ctx.Err()
+	return s.Store.Complete(ctx, record)
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -20,15 +22,22 @@ import (
 	"forge.lmig.com/n1505471/pizza-shop/internal/domain/order/model"
 
 	"forge.lmig.com/n1505471/pizza-shop/eventsource"
+	"forge.lmig.com/n1505471/pizza-shop/eventsource/idempotency"
+	ddbIdempotency "forge.lmig.com/n1505471/pizza-shop/eventsource/idempotency/store/dynamodb"
 	ddbES "forge.lmig.com/n1505471/pizza-shop/eventsource/store/dynamodb"
 	"forge.lmig.com/n1505471/pizza-shop/internal/domain/order"
 	"github.com/apex/gateway"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/julienschmidt/httprouter"
 )
 
+// snapshotFrequency is the number of events between aggregate snapshots
+const snapshotFrequency = 20
+
 var router *httprouter.Router
 var validate *validator.Validate
 
@@ -38,39 +47,55 @@ type Controller struct {
 	orderSvc    order.ServiceAPI
 	approvalSvc approval.ServiceAPI
 	deliverySvc delivery.ServiceAPI
+	// idempotency records the responses to requests with an Idempotency-Key, when set
+	idempotency idempotency.Store
 }
 
+// registerRoutes keys the idempotency of each route by the command it issues
 func (c *Controller) registerRoutes(router *httprouter.Router) {
-	router.POST("/orders", c.startOrder)
-	router.PATCH("/orders/edit/:orderID", c.updateOrder)
-	router.POST("/orders/submit/:orderID", c.submitOrder)
-	router.POST("/orders/approvals/:approvalID", c.approveCallback)
-	router.POST("/orders/deliveries/:deliveryID", c.deliveryCallback)
+	router.POST("/orders", c.idempotent("StartOrderCommand", c.startOrder))
+	router.PATCH("/orders/edit/:orderID", c.idempotent("UpdateOrderCommand", c.updateOrder))
+	router.POST("/orders/submit/:orderID", c.idempotent("SubmitOrderCommand", c.submitOrder))
+	router.POST("/orders/approvals/:approvalID", c.idempotent("ReceiveApproval", c.approveCallback))
+	router.POST("/orders/approvals/:approvalID/reject", c.idempotent("RejectApproval", c.rejectCallback))
+	router.POST("/orders/deliveries/:deliveryID", c.idempotent("ConfirmDelivery", c.deliveryCallback))
+	router.POST("/orders/deliveries/:deliveryID/fail", c.idempotent("FailDelivery", c.deliveryFailedCallback))
 
 }
 
 func init() {
 	var store eventsource.EventStorer
+	var snapshots eventsource.SnapshotStore
+	var requests idempotency.Store
 	f := os.Getenv("AWS_LAMBDA_FUNCTION_NAME")
 	if strings.Contains(f, "local") {
 		svc := dynamodb.New(session.New(), aws.NewConfig().WithRegion("localhost").WithEndpoint("http://host.docker.internal:9898"))
 		store = ddbES.New(svc, "EventsTable-local")
+		snapshots = ddbES.NewSnapshotStore(svc, "SnapshotTable-local")
+		requests = ddbIdempotency.New(svc, "IdempotencyTable-local")
 	} else {
 		svc := dynamodb.New(session.New(), aws.NewConfig())
 		store = ddbES.New(svc, os.Getenv("TABLE_NAME"))
+		snapshots = ddbES.NewSnapshotStore(svc, os.Getenv("SNAPSHOT_TABLE_NAME"))
+		requests = ddbIdempotency.New(svc, os.Getenv("IDEMPOTENCY_TABLE_NAME"))
 	}
 
-	es := eventsource.New(store)
+	validate = validator.New()
+
+	// Commands are validated by their struct tags, and logged and timed with the request's correlation
+	es := eventsource.New(store,
+		eventsource.WithSnapshots(snapshots, eventsource.SnapshotEvery(snapshotFrequency)),
+		eventsource.WithMiddleware(eventsource.LogCommands(), eventsource.TimeCommands(nil), eventsource.ValidateCommands(validate)),
+	)
 	orderSvc := order.NewService(es)
 
 	controller = &Controller{
 		orderSvc:    orderSvc,
 		approvalSvc: approval.NewService(es),
 		deliverySvc: delivery.NewService(es),
+		idempotency: requests,
 	}
 
-	validate = validator.New()
-
 	router = httprouter.New()
 	controller.registerRoutes(router)
 }
@@ -87,14 +112,10 @@ func (c *Controller) startOrder(w http.ResponseWriter, r *http.Request, _ httpro
 		errorResponse(w, err, http.StatusBadRequest)
 		return
 	}
-	if err := validate.Struct(resource); err != nil {
-		invalidResponse(w, err)
-		return
-	}
 
-	orderID, err := c.orderSvc.StartOrder(resource.toOrder())
+	orderID, err := c.orderSvc.StartOrder(commandContext(r), resource.toOrder())
 	if err != nil {
-		errorResponse(w, err, http.StatusBadRequest)
+		commandErrorResponse(w, err)
 		return
 	}
 	resource.OrderID = orderID
@@ -117,8 +138,8 @@ func (c *Controller) updateOrder(w http.ResponseWriter, r *http.Request, p httpr
 	}
 	resource.OrderID = orderID
 
-	if err := c.orderSvc.UpdateOrder(resource.toOrderPatch()); err != nil {
-		errorResponse(w, err, http.StatusBadRequest)
+	if err := c.orderSvc.UpdateOrder(commandContext(r), resource.toOrderPatch()); err != nil {
+		commandErrorResponse(w, err)
 		return
 	}
 
@@ -130,8 +151,8 @@ func (c *Controller) updateOrder(w http.ResponseWriter, r *http.Request, p httpr
 func (c *Controller) submitOrder(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
 	orderID := p.ByName("orderID")
 
-	if err := c.orderSvc.SubmitOrder(orderID); err != nil {
-		errorResponse(w, err, http.StatusBadRequest)
+	if err := c.orderSvc.SubmitOrder(commandContext(r), orderID); err != nil {
+		commandErrorResponse(w, err)
 		return
 	}
 
@@ -140,7 +161,7 @@ func (c *Controller) submitOrder(w http.ResponseWriter, r *http.Request, p httpr
 	})
 }
 
-func (c *Controller) approveCallback(w http.ResponseWriter, _ *http.Request, p httprouter.Params) {
+func (c *Controller) approveCallback(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
 	approvalID := p.ByName("approvalID")
 
 	i, err := strconv.Atoi(approvalID)
@@ -150,17 +171,61 @@ func (c *Controller) approveCallback(w http.ResponseWriter, _ *http.Request, p h
 	}
 	fmt.Printf("Got approvalID: %d", i)
 
-	if err := c.approvalSvc.ReceiveApproval(i); err != nil {
+	if err := c.approvalSvc.ReceiveApproval(commandContext(r), i); err != nil {
+		commandErrorResponse(w, err)
+		return
+	}
+
+	jsonResponse(w, &response{
+		OK: true,
+	})
+}
+
+func (c *Controller) deliveryCallback(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	deliveryID := p.ByName("deliveryID")
+
+	i, err := strconv.Atoi(deliveryID)
+	if err != nil {
+		errorResponse(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if err := c.deliverySvc.ReceiveDeliveryNotification(commandContext(r), i); err != nil {
+		commandErrorResponse(w, err)
+		return
+	}
+
+	jsonResponse(w, &response{
+		OK: true,
+	})
+}
+
+func (c *Controller) rejectCallback(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	approvalID := p.ByName("approvalID")
+
+	i, err := strconv.Atoi(approvalID)
+	if err != nil {
 		errorResponse(w, err, http.StatusBadRequest)
 		return
 	}
 
+	var resource *failureResource
+	if err := json.NewDecoder(r.Body).Decode(&resource); err != nil {
+		errorResponse(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if err := c.approvalSvc.RejectApproval(commandContext(r), i, resource.Reason); err != nil {
+		commandErrorResponse(w, err)
+		return
+	}
+
 	jsonResponse(w, &response{
 		OK: true,
 	})
 }
 
-func (c *Controller) deliveryCallback(w http.ResponseWriter, _ *http.Request, p httprouter.Params) {
+func (c *Controller) deliveryFailedCallback(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
 	deliveryID := p.ByName("deliveryID")
 
 	i, err := strconv.Atoi(deliveryID)
@@ -169,11 +234,17 @@ func (c *Controller) deliveryCallback(w http.ResponseWriter, _ *http.Request, p
 		return
 	}
 
-	if err := c.deliverySvc.ReceiveDeliveryNotification(i); err != nil {
+	var resource *failureResource
+	if err := json.NewDecoder(r.Body).Decode(&resource); err != nil {
 		errorResponse(w, err, http.StatusBadRequest)
 		return
 	}
 
+	if err := c.deliverySvc.ReceiveDeliveryFailure(commandContext(r), i, resource.Reason); err != nil {
+		commandErrorResponse(w, err)
+		return
+	}
+
 	jsonResponse(w, &response{
 		OK: true,
 	})
@@ -183,6 +254,11 @@ func (c *Controller) deliveryCallback(w http.ResponseWriter, _ *http.Request, p
  * Types
  */
 
+// failureResource is the body of the rejection and failure callbacks from vendor systems
+type failureResource struct {
+	Reason string `json:"reason"`
+}
+
 type orderResource struct {
 	OrderID     string            `json:"orderId"`
 	ServiceType model.ServiceType `json:"serviceType"`
@@ -215,6 +291,27 @@ func (o *orderPatchResource) toOrderPatch() *model.OrderPatch {
  * Helpers
  */
 
+// correlationHeader lets callers correlate their requests with the events they cause
+const correlationHeader = "X-Correlation-Id"
+
+// commandContext carries the metadata for the events of the request's command.  The request
+// causes the events, and starts their correlation unless the caller gave one.
+func commandContext(r *http.Request) context.Context {
+	m := eventsource.Metadata{
+		CorrelationID: r.Header.Get(correlationHeader),
+	}
+	if rc, ok := gateway.RequestContext(r.Context()); ok {
+		m.CausationID = rc.RequestID
+		if principal, ok := rc.Authorizer["principalId"].(string); ok {
+			m.UserID = principal
+		}
+	}
+	if m.CorrelationID == "" {
+		m.CorrelationID = m.CausationID
+	}
+	return eventsource.WithMetadata(r.Context(), m)
+}
+
 func jsonResponse(w http.ResponseWriter, body interface{}) {
 	w.Header().Set("content-type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -252,6 +349,48 @@ func invalidResponse(w http.ResponseWriter, err error) {
 	}
 }
 
+// commandErrorResponse responds with the fields of commands which fail validation, or forbids
+// commands which aren't authorized.  Failures of the service, which the client may retry, are
+// server errors, so the idempotency key isn't spent on them.  Other errors are the client's,
+// e.g. an order in the wrong state.
+func commandErrorResponse(w http.ResponseWriter, err error) {
+	var fieldErrs validator.ValidationErrors
+	if errors.As(err, &fieldErrs) {
+		invalidResponse(w, fieldErrs)
+		return
+	}
+	if _, ok := err.(*eventsource.UnauthorizedError); ok {
+		errorResponse(w, err, http.StatusForbidden)
+		return
+	}
+	if code, ok := serverErrorStatus(err); ok {
+		log.Printf("Error processing command, details: %s", err)
+		errorResponse(w, err, code)
+		return
+	}
+	errorResponse(w, err, http.StatusBadRequest)
+}
+
+// serverErrorStatus returns the status for errors of the service rather than the command.  Errors
+// which should pass on retry, e.g. the aggregate still being locked after the RetryPolicy,
+// throttling or timeouts, are unavailable, and other AWS errors are internal.
+func serverErrorStatus(err error) (int, bool) {
+	var lockErr *eventsource.AggregateLockError
+	if errors.As(err, &lockErr) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return http.StatusServiceUnavailable, true
+	}
+
+	var aerr awserr.Error
+	if errors.As(err, &aerr) {
+		if request.IsErrorRetryable(aerr) || request.IsErrorThrottle(aerr) {
+			return http.StatusServiceUnavailable, true
+		}
+		return http.StatusInternalServerError, true
+	}
+
+	return 0, false
+}
+
 func errorResponse(w http.ResponseWriter, err error, code int) {
 	w.Header().Set("content-type", "application/json")
 	w.WriteHeader(code)
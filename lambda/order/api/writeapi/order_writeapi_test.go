@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -8,12 +9,20 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/go-playground/validator/v10"
 	"github.com/go-test/deep"
 
 	"forge.lmig.com/n1505471/pizza-shop/internal/domain/order"
+	"forge.lmig.com/n1505471/pizza-shop/internal/domain/order/command"
 
 	"forge.lmig.com/n1505471/pizza-shop/internal/domain/order/model"
 
+	"forge.lmig.com/n1505471/pizza-shop/eventsource"
+
+	"github.com/apex/gateway"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/julienschmidt/httprouter"
 )
 
@@ -71,6 +80,50 @@ func TestStartOrder(t *testing.T) {
 				return nil
 			},
 		},
+		{
+			svc: &mockOrderService{
+				err: invalidCommand(&command.StartOrderCommand{}),
+			},
+			body: `
+				{
+					"description": "Here is a description."
+				}
+			`,
+			condition: func(rr *httptest.ResponseRecorder) error {
+				if err := checkStatusCode(http.StatusBadRequest, rr); err != nil {
+					return err
+				}
+
+				expected := &response{
+					OK: false,
+					Errors: []*validationError{
+						{Field: "StartOrderCommand.OrderID", Message: "OrderID is a required field."},
+						{Field: "StartOrderCommand.ServiceType", Message: "ServiceType is a required field."},
+					},
+				}
+				if err := checkResponseBody(expected, &response{}, rr); err != nil {
+					return err
+				}
+				return nil
+			},
+		},
+		{
+			svc: &mockOrderService{
+				err: &eventsource.UnauthorizedError{UserID: "guest", Command: "*command.StartOrderCommand", Err: fmt.Errorf("Not allowed.")},
+			},
+			body: `
+				{
+					"serviceType": "Delivery",
+					"description": "Here is a description."
+				}
+			`,
+			condition: func(rr *httptest.ResponseRecorder) error {
+				if err := checkStatusCode(http.StatusForbidden, rr); err != nil {
+					return err
+				}
+				return nil
+			},
+		},
 		{
 			svc: &mockOrderService{},
 			body: `
@@ -239,6 +292,36 @@ func TestSubmitOrder(t *testing.T) {
 				return nil
 			},
 		},
+		{
+			svc: &mockOrderService{err: fmt.Errorf("Cannot submit order which has already been submitted.")},
+			condition: func(rr *httptest.ResponseRecorder) error {
+				return checkStatusCode(http.StatusBadRequest, rr)
+			},
+		},
+		{
+			svc: &mockOrderService{err: &eventsource.AggregateLockError{ID: "orderId", Sequence: 2}},
+			condition: func(rr *httptest.ResponseRecorder) error {
+				return checkStatusCode(http.StatusServiceUnavailable, rr)
+			},
+		},
+		{
+			svc: &mockOrderService{err: fmt.Errorf("Loading order: %w", context.DeadlineExceeded)},
+			condition: func(rr *httptest.ResponseRecorder) error {
+				return checkStatusCode(http.StatusServiceUnavailable, rr)
+			},
+		},
+		{
+			svc: &mockOrderService{err: awserr.New(dynamodb.ErrCodeProvisionedThroughputExceededException, "Rate exceeded", nil)},
+			condition: func(rr *httptest.ResponseRecorder) error {
+				return checkStatusCode(http.StatusServiceUnavailable, rr)
+			},
+		},
+		{
+			svc: &mockOrderService{err: awserr.New(dynamodb.ErrCodeResourceNotFoundException, "Table not found", nil)},
+			condition: func(rr *httptest.ResponseRecorder) error {
+				return checkStatusCode(http.StatusInternalServerError, rr)
+			},
+		},
 	}
 
 	for i, c := range cases {
@@ -264,20 +347,64 @@ func TestSubmitOrder(t *testing.T) {
 	}
 }
 
+func TestCommandContext(t *testing.T) {
+	cases := []struct {
+		Label    string
+		Request  events.APIGatewayProxyRequest
+		Expected eventsource.Metadata
+	}{
+		{
+			Label: "correlates the events with the request",
+			Request: events.APIGatewayProxyRequest{
+				RequestContext: events.APIGatewayProxyRequestContext{
+					RequestID:  "requestId",
+					Authorizer: map[string]interface{}{"principalId": "userId"},
+				},
+			},
+			Expected: eventsource.Metadata{CorrelationID: "requestId", CausationID: "requestId", UserID: "userId"},
+		},
+		{
+			Label: "continues the caller's correlation",
+			Request: events.APIGatewayProxyRequest{
+				Headers:        map[string]string{correlationHeader: "correlationId"},
+				RequestContext: events.APIGatewayProxyRequestContext{RequestID: "requestId"},
+			},
+			Expected: eventsource.Metadata{CorrelationID: "correlationId", CausationID: "requestId"},
+		},
+	}
+
+	for i, c := range cases {
+		c.Request.HTTPMethod = "POST"
+		c.Request.Path = "/orders"
+		req, err := gateway.NewRequest(context.Background(), c.Request)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := eventsource.MetadataFrom(commandContext(req))
+		if diff := deep.Equal(got, c.Expected); diff != nil {
+			t.Errorf("Case[%d]: %s.  %s", i, c.Label, diff)
+		}
+	}
+}
+
 type mockOrderService struct {
 	order.ServiceAPI
 	err error
+	// started counts the orders started
+	started int
 }
 
-func (m *mockOrderService) StartOrder(order *model.Order) (string, error) {
+func (m *mockOrderService) StartOrder(_ context.Context, order *model.Order) (string, error) {
+	m.started++
 	return "orderId", m.err
 }
 
-func (m *mockOrderService) UpdateOrder(order *model.OrderPatch) error {
+func (m *mockOrderService) UpdateOrder(_ context.Context, order *model.OrderPatch) error {
 	return m.err
 }
 
-func (m *mockOrderService) SubmitOrder(orderID string) error {
+func (m *mockOrderService) SubmitOrder(_ context.Context, orderID string) error {
 	return m.err
 }
 
@@ -285,6 +412,14 @@ func (m *mockOrderService) SubmitOrder(orderID string) error {
  * Helpers
  */
 
+// invalidCommand returns the error ProcessCommand gives for the command failing validation
+func invalidCommand(c eventsource.Command) error {
+	return &eventsource.CommandValidationError{
+		Command: fmt.Sprintf("%T", c),
+		Err:     validator.New().Struct(c),
+	}
+}
+
 func checkStatusCode(expected int, rr *httptest.ResponseRecorder) error {
 	if status := rr.Code; status != expected {
 		return fmt.Errorf("Expected HTTP Status Code %d, got %d.  Details: %s", expected, status, rr.Body)
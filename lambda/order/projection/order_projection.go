@@ -21,7 +21,9 @@ var projection es.Projection
 
 func init() {
 	repo = repository.NewRepository(dynamodb.New(session.New(), aws.NewConfig()), os.Getenv("TABLE_NAME"))
-	projection = order.NewProjection(repo)
+	// Track the last applied sequence per order, so redelivered events are skipped and events
+	// arriving out of order are retried
+	projection = es.NewProjectionRunner(order.NewProjection(repo), repo)
 }
 
 func main() {
@@ -31,28 +33,31 @@ func main() {
 func HandleRequest(ctx context.Context, e events.SNSEvent) error {
 
 	for _, r := range e.Records {
-		if err := handleEvent(r); err != nil {
+		event, err := decodeEvent(r)
+		if err != nil {
+			// An undecodable message fails the same way every time, so it isn't redelivered
 			log.Println(err)
 			continue
 		}
+
+		// Fail the invocation so Lambda redelivers the event.  Skipping it would leave the order's
+		// checkpoint behind it, so every later event for the order would be a gap.
+		if err := projection.HandleEvent(ctx, event); err != nil {
+			return fmt.Errorf("Error handling event with payload: %+v, details: %w", event, err)
+		}
 	}
 
 	return nil
 }
 
-func handleEvent(r events.SNSEventRecord) error {
+func decodeEvent(r events.SNSEventRecord) (es.Event, error) {
 	eventTypeAttribute := r.SNS.MessageAttributes["eventType"].(map[string]interface{})
 	event := es.Event{
 		EventType: eventTypeAttribute["Value"].(string),
 	}
 	if err := event.Load([]byte(r.SNS.Message)); err != nil {
-		return fmt.Errorf("Error unmarhalling json: %s", err)
+		return event, fmt.Errorf("Error unmarhalling json: %s", err)
 	}
 
-	// Handle projection
-	if err := projection.HandleEvent(event); err != nil {
-		return fmt.Errorf("Error handling event with payload: %+v, details: %s", event, err)
-	}
-
-	return nil
+	return event, nil
 }
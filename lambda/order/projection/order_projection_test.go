@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -22,11 +23,13 @@ func TestMain(m *testing.M) {
 	os.Exit(m.Run())
 }
 
-func TestHandleEvent(t *testing.T) {
+func TestHandleRequest(t *testing.T) {
 
 	cases := []struct {
-		Record   events.SNSEventRecord
-		Expected []es.Event
+		Record        events.SNSEventRecord
+		Expected      []es.Event
+		ProjectionErr error
+		ShouldError   bool
 	}{
 		{
 			Record: events.SNSEventRecord{
@@ -68,14 +71,57 @@ func TestHandleEvent(t *testing.T) {
 				},
 			},
 		},
+		{
+			// A failed event is redelivered, rather than leaving the order's checkpoint behind it
+			Record: events.SNSEventRecord{
+				SNS: events.SNSEntity{
+					MessageAttributes: map[string]interface{}{
+						"eventType": map[string]interface{}{
+							"Type":  "String",
+							"Value": "OrderStartedEvent",
+						},
+					},
+					Message: `{"eventId":"eventId","aggregateId":"orderId","eventType":"OrderStartedEvent","eventData":{"orderId":"orderId"}}`,
+				},
+			},
+			Expected: []es.Event{
+				{
+					EventID:     "eventId",
+					AggregateID: "orderId",
+					EventType:   "OrderStartedEvent",
+					Data:        &event.OrderStartedEvent{OrderID: "orderId"},
+				},
+			},
+			ProjectionErr: fmt.Errorf("I am error"),
+			ShouldError:   true,
+		},
+		{
+			// An undecodable message would fail every time, so it isn't redelivered
+			Record: events.SNSEventRecord{
+				SNS: events.SNSEntity{
+					MessageAttributes: map[string]interface{}{
+						"eventType": map[string]interface{}{
+							"Type":  "String",
+							"Value": "OrderStartedEvent",
+						},
+					},
+					Message: `not json`,
+				},
+			},
+		},
 	}
 
-	for _, c := range cases {
+	for i, c := range cases {
 		projection = &mockProjection{
 			Expected: c.Expected,
+			err:      c.ProjectionErr,
+		}
+		err := HandleRequest(context.Background(), events.SNSEvent{Records: []events.SNSEventRecord{c.Record}})
+		if c.ShouldError != (err != nil) {
+			t.Errorf("Cases[%d] FAILED.  Error: %v", i, err)
 		}
-		if err := handleEvent(c.Record); err != nil {
-			t.Error(err)
+		if m := projection.(*mockProjection); m.index != len(c.Expected) {
+			t.Errorf("Cases[%d] FAILED.  Expected %d events handled, got %d", i, len(c.Expected), m.index)
 		}
 	}
 
@@ -84,14 +130,15 @@ func TestHandleEvent(t *testing.T) {
 type mockProjection struct {
 	Expected []es.Event
 	index    int
+	err      error
 }
 
-func (m *mockProjection) HandleEvent(event es.Event) error {
+func (m *mockProjection) HandleEvent(_ context.Context, event es.Event) error {
 	expected := m.Expected[m.index]
 	m.index++
 
 	if diff := deep.Equal(expected, event); diff != nil {
 		return fmt.Errorf("%s", diff)
 	}
-	return nil
+	return m.err
 }
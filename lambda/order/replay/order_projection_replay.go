@@ -1,14 +1,267 @@
 package main
 
-import "log"
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	es "forge.lmig.com/n1505471/pizza-shop/eventsource"
+	"forge.lmig.com/n1505471/pizza-shop/eventsource/store/memory"
+	"forge.lmig.com/n1505471/pizza-shop/internal/projections/order"
+	. "forge.lmig.com/n1505471/pizza-shop/internal/projections/order/model"
+	"forge.lmig.com/n1505471/pizza-shop/internal/projections/order/repository"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// eventsPrefix is where the event forwarder stores events, keyed as events/<timestamp>--<eventType>--<eventId>
+const eventsPrefix = "events/"
+
+// secondLength is the length of the timestamp in an event key, truncated to the second.  The
+// fractional seconds are trimmed by the forwarder, so keys only sort chronologically to the second.
+const secondLength = len("2006-01-02T15:04:05")
 
 func main() {
-	log.Printf("Gonna start replaying now!")
-	// List events in s3, page by page
+	bucket := flag.String("bucket", os.Getenv("BUCKET_NAME"), "bucket the event forwarder stores events in")
+	table := flag.String("table", os.Getenv("TABLE_NAME"), "name of the order table to replay the projection into")
+	checkpoint := flag.String("checkpoint", os.Getenv("CHECKPOINT_KEY"), "S3 key used to record progress, defaults to checkpoints/order-projection-replay/<table>")
+	dryRun := flag.Bool("dry-run", false, "decode and apply events without writing to the table or the checkpoint")
+	flag.Parse()
+
+	if *bucket == "" || *table == "" {
+		log.Fatal("A bucket and target table must be provided, using -bucket and -table or BUCKET_NAME and TABLE_NAME.")
+	}
+	if *checkpoint == "" {
+		*checkpoint = fmt.Sprintf("checkpoints/order-projection-replay/%s", *table)
+	}
+
+	sess := session.New()
+	var projection es.Projection
+	if *dryRun {
+		projection = es.NewProjectionRunner(order.NewProjection(&dryRunRepository{}), memory.NewCheckpointStore())
+	} else {
+		repo := repository.NewRepository(dynamodb.New(sess, aws.NewConfig()), *table)
+		projection = es.NewProjectionRunner(order.NewProjection(repo), repo)
+	}
+
+	r := &replayer{
+		s3:            s3.New(sess),
+		bucket:        aws.String(*bucket),
+		checkpointKey: aws.String(*checkpoint),
+		projection:    projection,
+		dryRun:        *dryRun,
+	}
+
+	log.Printf("Gonna start replaying now! Bucket: %s, table: %s, dry run: %t", *bucket, *table, *dryRun)
+	if err := r.Run(context.Background()); err != nil {
+		log.Fatalf("Replay failed after applying %d events, details: %s", r.applied, err)
+	}
+	log.Printf("Replay complete, applied %d events.", r.applied)
+}
+
+type replayer struct {
+	s3            s3iface.S3API
+	bucket        *string
+	checkpointKey *string
+	projection    es.Projection
+	dryRun        bool
+
+	applied int
+}
+
+type pendingEvent struct {
+	key   string
+	event es.Event
+}
+
+// Run pages through the stored events from the last checkpoint, applying them to the projection
+// in timestamp and sequence order.  Events are buffered until every event in the same second
+// has been listed, since the keys alone can't order events within a second.
+func (r *replayer) Run(ctx context.Context) error {
+	checkpoint, err := r.loadCheckpoint()
+	if err != nil {
+		return err
+	}
+
+	input := &s3.ListObjectsV2Input{
+		Bucket: r.bucket,
+		Prefix: aws.String(eventsPrefix),
+	}
+	if checkpoint != "" {
+		log.Printf("Resuming from checkpoint: %s", checkpoint)
+		input.StartAfter = aws.String(checkpoint)
+	}
+
+	var pending []*pendingEvent
+	var lastKey string
+	var replayErr error
+	err = r.s3.ListObjectsV2Pages(input, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, o := range page.Contents {
+			key := aws.StringValue(o.Key)
+			lastKey = key
+
+			event, ok, err := r.fetchEvent(key)
+			if err != nil {
+				replayErr = err
+				return false
+			}
+			if ok {
+				pending = append(pending, &pendingEvent{key: key, event: event})
+			}
+		}
+
+		// Later pages can still hold events from the same second as the last key on this one
+		if !lastPage && lastKey != "" {
+			boundary := second(lastKey)
+			pending, replayErr = r.flush(ctx, pending, boundary, eventsPrefix+boundary)
+			return replayErr == nil
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if replayErr != nil {
+		return replayErr
+	}
+
+	if lastKey == "" {
+		return nil
+	}
+	_, err = r.flush(ctx, pending, "", lastKey)
+	return err
+}
 
-	// Get each event
+// flush applies the pending events from before the boundary second, or all of them when the
+// boundary is empty, then records the checkpoint.  The remaining events are returned.
+func (r *replayer) flush(ctx context.Context, pending []*pendingEvent, boundary string, checkpoint string) ([]*pendingEvent, error) {
+	sort.SliceStable(pending, func(i, j int) bool {
+		a, b := pending[i].event, pending[j].event
+		if !a.Timestamp.Equal(b.Timestamp) {
+			return a.Timestamp.Before(b.Timestamp)
+		}
+		return a.AggregateSequence < b.AggregateSequence
+	})
 
-	// process each event and save to dynamodb
+	var remaining []*pendingEvent
+	for _, p := range pending {
+		if boundary != "" && second(p.key) >= boundary {
+			remaining = append(remaining, p)
+			continue
+		}
+		if err := r.projection.HandleEvent(ctx, p.event); err != nil {
+			return nil, fmt.Errorf("Error applying event %s, details: %s", p.key, err)
+		}
+		r.applied++
+	}
+
+	if err := r.saveCheckpoint(checkpoint); err != nil {
+		return nil, err
+	}
+
+	return remaining, nil
+}
+
+// fetchEvent downloads and decodes the event, skipping event types which aren't registered
+func (r *replayer) fetchEvent(key string) (es.Event, bool, error) {
+	parts := strings.Split(strings.TrimPrefix(key, eventsPrefix), "--")
+	if len(parts) != 3 {
+		log.Printf("Skipping unrecognized key: %s", key)
+		return es.Event{}, false, nil
+	}
+	if _, err := es.GetEventOfType(parts[1]); err != nil {
+		return es.Event{}, false, nil
+	}
+
+	out, err := r.s3.GetObject(&s3.GetObjectInput{
+		Bucket: r.bucket,
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return es.Event{}, false, err
+	}
+	defer out.Body.Close()
+	body, err := ioutil.ReadAll(out.Body)
+	if err != nil {
+		return es.Event{}, false, err
+	}
+
+	event := es.Event{
+		EventType: parts[1],
+	}
+	if err := event.Load(body); err != nil {
+		return es.Event{}, false, fmt.Errorf("Error decoding event %s, details: %s", key, err)
+	}
+
+	return event, true, nil
+}
+
+func (r *replayer) loadCheckpoint() (string, error) {
+	out, err := r.s3.GetObject(&s3.GetObjectInput{
+		Bucket: r.bucket,
+		Key:    r.checkpointKey,
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case s3.ErrCodeNoSuchKey:
+				return "", nil
+			}
+		}
+		return "", err
+	}
+	defer out.Body.Close()
+
+	body, err := ioutil.ReadAll(out.Body)
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
+
+func (r *replayer) saveCheckpoint(checkpoint string) error {
+	if r.dryRun {
+		log.Printf("Dry run, skipping checkpoint: %s", checkpoint)
+		return nil
+	}
+
+	_, err := r.s3.PutObject(&s3.PutObjectInput{
+		Bucket:      r.bucket,
+		Key:         r.checkpointKey,
+		ContentType: aws.String("text/plain"),
+		Body:        aws.ReadSeekCloser(bytes.NewReader([]byte(checkpoint))),
+	})
+	return err
+}
+
+// second returns the timestamp of the event key, truncated to the second
+func second(key string) string {
+	t := strings.TrimPrefix(key, eventsPrefix)
+	if len(t) < secondLength {
+		return t
+	}
+	return t[:secondLength]
+}
+
+// dryRunRepository logs the writes the projection would make
+type dryRunRepository struct{}
+
+func (d *dryRunRepository) Save(_ context.Context, order *Order) error {
+	log.Printf("Dry run, would save order: %+v", order)
+	return nil
+}
 
-	// complete the job
+func (d *dryRunRepository) Patch(_ context.Context, orderID string, updates *Order) error {
+	log.Printf("Dry run, would patch order %s with: %+v", orderID, updates)
+	return nil
 }
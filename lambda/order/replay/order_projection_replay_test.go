@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	es "forge.lmig.com/n1505471/pizza-shop/eventsource"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/go-test/deep"
+)
+
+// SETUP
+func TestMain(m *testing.M) {
+	log.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+func eventJSON(id string, eventType string, timestamp string, sequence int) string {
+	return fmt.Sprintf(`{
+		"eventId": "%s",
+		"aggregateId": "orderId",
+		"aggregateType": "OrderAggregate",
+		"aggregateSequence": %d,
+		"eventVersion": 1,
+		"eventType": "%s",
+		"eventTimestamp": "%s",
+		"eventData": {"orderId": "orderId"}
+	}`, id, sequence, eventType, timestamp)
+}
+
+// Keys within the same second don't sort chronologically, since trailing zeros are trimmed
+var testObjects = map[string]string{
+	"events/2020-04-19T19:45:11.5Z--OrderSubmitted--b":   eventJSON("b", "OrderSubmitted", "2020-04-19T19:45:11.5Z", 2),
+	"events/2020-04-19T19:45:11.51Z--OrderApproved--c":   eventJSON("c", "OrderApproved", "2020-04-19T19:45:11.51Z", 3),
+	"events/2020-04-19T19:45:11.1Z--OrderSubmitted--a":   eventJSON("a", "OrderSubmitted", "2020-04-19T19:45:11.1Z", 1),
+	"events/2020-04-19T19:45:12.2Z--ApprovalReceived--x": `{"eventType": "ApprovalReceived"}`,
+	"events/2020-04-19T19:45:12.3Z--OrderDelivered--d":   eventJSON("d", "OrderDelivered", "2020-04-19T19:45:12.3Z", 4),
+}
+
+func TestReplayer_Run(t *testing.T) {
+	cases := []struct {
+		Label              string
+		PageSize           int
+		Checkpoint         string
+		DryRun             bool
+		Expected           []string
+		ExpectedCheckpoint string
+	}{
+		{
+			Label:              "applies every event in order",
+			PageSize:           10,
+			Expected:           []string{"a", "b", "c", "d"},
+			ExpectedCheckpoint: "events/2020-04-19T19:45:12.3Z--OrderDelivered--d",
+		},
+		{
+			Label:              "keeps events ordered within a second split across pages",
+			PageSize:           1,
+			Expected:           []string{"a", "b", "c", "d"},
+			ExpectedCheckpoint: "events/2020-04-19T19:45:12.3Z--OrderDelivered--d",
+		},
+		{
+			Label:              "resumes from the checkpoint",
+			PageSize:           2,
+			Checkpoint:         "events/2020-04-19T19:45:12",
+			Expected:           []string{"d"},
+			ExpectedCheckpoint: "events/2020-04-19T19:45:12.3Z--OrderDelivered--d",
+		},
+		{
+			Label:    "does not write checkpoints in dry run mode",
+			PageSize: 2,
+			DryRun:   true,
+			Expected: []string{"a", "b", "c", "d"},
+		},
+	}
+
+	for i, c := range cases {
+		client := &mockS3Client{
+			objects:  testObjects,
+			pageSize: c.PageSize,
+		}
+		if c.Checkpoint != "" {
+			client.checkpoint = aws.String(c.Checkpoint)
+		}
+		projection := &mockProjection{}
+		r := &replayer{
+			s3:            client,
+			bucket:        aws.String("testBucket"),
+			checkpointKey: aws.String("checkpoint"),
+			projection:    projection,
+			dryRun:        c.DryRun,
+		}
+
+		if err := r.Run(context.Background()); err != nil {
+			t.Errorf("Cases[%d] FAILED: %s.  Error: %s", i, c.Label, err)
+			continue
+		}
+
+		if diff := deep.Equal(projection.ids, c.Expected); diff != nil {
+			t.Errorf("Cases[%d] FAILED: %s.  Error: %s", i, c.Label, diff)
+		}
+
+		got := aws.StringValue(client.checkpoint)
+		if c.DryRun {
+			if client.puts != 0 {
+				t.Errorf("Cases[%d] FAILED: %s.  Expected no checkpoint writes, got %d", i, c.Label, client.puts)
+			}
+		} else if got != c.ExpectedCheckpoint {
+			t.Errorf("Cases[%d] FAILED: %s.  Expected checkpoint %s, got %s", i, c.Label, c.ExpectedCheckpoint, got)
+		}
+	}
+}
+
+type mockProjection struct {
+	ids []string
+}
+
+func (m *mockProjection) HandleEvent(_ context.Context, event es.Event) error {
+	m.ids = append(m.ids, event.EventID)
+	return nil
+}
+
+type mockS3Client struct {
+	s3iface.S3API
+	objects    map[string]string
+	pageSize   int
+	checkpoint *string
+	puts       int
+}
+
+func (m *mockS3Client) ListObjectsV2Pages(in *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool) error {
+	var keys []string
+	for k := range m.objects {
+		if strings.HasPrefix(k, aws.StringValue(in.Prefix)) && k > aws.StringValue(in.StartAfter) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	for start := 0; start < len(keys); start += m.pageSize {
+		end := start + m.pageSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		page := &s3.ListObjectsV2Output{}
+		for _, k := range keys[start:end] {
+			page.Contents = append(page.Contents, &s3.Object{Key: aws.String(k)})
+		}
+		if !fn(page, end == len(keys)) {
+			break
+		}
+	}
+	return nil
+}
+
+func (m *mockS3Client) GetObject(in *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	key := aws.StringValue(in.Key)
+	if key == "checkpoint" {
+		if m.checkpoint == nil {
+			return nil, awserr.New(s3.ErrCodeNoSuchKey, "not found", nil)
+		}
+		return &s3.GetObjectOutput{Body: ioutil.NopCloser(strings.NewReader(*m.checkpoint))}, nil
+	}
+	if key == "events/2020-04-19T19:45:12.2Z--ApprovalReceived--x" {
+		return nil, fmt.Errorf("unregistered event types should not be downloaded")
+	}
+
+	return &s3.GetObjectOutput{Body: ioutil.NopCloser(strings.NewReader(m.objects[key]))}, nil
+}
+
+func (m *mockS3Client) PutObject(in *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	m.puts++
+	buf := new(bytes.Buffer)
+	buf.ReadFrom(in.Body)
+	m.checkpoint = aws.String(buf.String())
+	return &s3.PutObjectOutput{}, nil
+}
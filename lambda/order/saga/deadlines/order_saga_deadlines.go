@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"time"
+
+	"forge.lmig.com/n1505471/pizza-shop/internal/domain/approval"
+	"forge.lmig.com/n1505471/pizza-shop/internal/domain/delivery"
+	"forge.lmig.com/n1505471/pizza-shop/internal/domain/order"
+	"forge.lmig.com/n1505471/pizza-shop/internal/saga/orderfulfillment"
+
+	es "forge.lmig.com/n1505471/pizza-shop/eventsource"
+	"forge.lmig.com/n1505471/pizza-shop/eventsource/saga"
+	ddbSagaStore "forge.lmig.com/n1505471/pizza-shop/eventsource/saga/store/dynamodb"
+	ddbEventStore "forge.lmig.com/n1505471/pizza-shop/eventsource/store/dynamodb"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/go-playground/validator/v10"
+)
+
+// snapshotFrequency is the number of events between aggregate snapshots
+const snapshotFrequency = 20
+
+// pollInterval is how often deadlines are checked when running outside of Lambda
+const pollInterval = 10 * time.Second
+
+var scheduler *saga.Scheduler
+var dispatcher *saga.OutboxDispatcher
+
+func init() {
+	db := dynamodb.New(session.New(), aws.NewConfig())
+	store := ddbSagaStore.New(db, os.Getenv("ASSOCIATIONS_TABLE_NAME"), os.Getenv("SAGA_TABLE_NAME"), os.Getenv("SAGA_ARCHIVE_TABLE_NAME"), os.Getenv("OUTBOX_TABLE_NAME"))
+	deadlines := ddbSagaStore.NewDeadlineStore(db, os.Getenv("DEADLINE_TABLE_NAME"))
+	eventStore := ddbEventStore.New(db, os.Getenv("EVENT_TABLE_NAME"))
+	snapshotStore := ddbEventStore.NewSnapshotStore(db, os.Getenv("SNAPSHOT_TABLE_NAME"))
+	eventsource := es.New(eventStore,
+		es.WithSnapshots(snapshotStore, es.SnapshotEvery(snapshotFrequency)),
+		es.WithMiddleware(es.LogCommands(), es.TimeCommands(nil), es.ValidateCommands(validator.New())),
+	)
+	deliverySvc := delivery.NewService(eventsource)
+	approvalSvc := approval.NewService(eventsource)
+	orderSvc := order.NewService(eventsource)
+
+	// Deadlines left behind by sagas which have gone are dropped, rather than retried forever
+	manager := saga.NewManager(store, saga.WithDeadlines(deadlines), saga.IgnoreUnknownSagas())
+	manager.Register(func() saga.SagaAPI {
+		return orderfulfillment.New(orderSvc)
+	})
+	scheduler = saga.NewScheduler(manager, deadlines)
+	dispatcher = saga.NewOutboxDispatcher(manager, store)
+	orderfulfillment.HandleIntents(dispatcher, approvalSvc, deliverySvc)
+}
+
+func main() {
+	if os.Getenv("AWS_LAMBDA_FUNCTION_NAME") != "" {
+		lambda.Start(HandleRequest)
+		return
+	}
+
+	// Running locally, poll for deadlines until interrupted
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	log.Printf("Checking for saga deadlines every %s", pollInterval)
+	scheduler.Run(ctx, pollInterval)
+}
+
+// HandleRequest delivers the due deadlines and retries the saga intents which failed when
+// first dispatched, triggered on a schedule
+func HandleRequest(ctx context.Context, e events.CloudWatchEvent) error {
+	n, err := scheduler.RunDue(ctx, time.Now())
+	log.Printf("Delivered %d saga deadlines", n)
+
+	dispatched, dispatchErr := dispatcher.RunPending(ctx, time.Now())
+	log.Printf("Dispatched %d saga intents", dispatched)
+
+	if err != nil {
+		return err
+	}
+	return dispatchErr
+}
@@ -2,9 +2,12 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"time"
 
 	"forge.lmig.com/n1505471/pizza-shop/internal/domain/order"
 
@@ -24,9 +27,14 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/go-playground/validator/v10"
 )
 
+// snapshotFrequency is the number of events between aggregate snapshots
+const snapshotFrequency = 20
+
 var manager *saga.SagaManager
+var dispatcher *saga.OutboxDispatcher
 var deliverySvc delivery.ServiceAPI
 var approvalSvc approval.ServiceAPI
 var orderSvc order.ServiceAPI
@@ -34,13 +42,26 @@ var eventsource es.EventSourceAPI
 
 func init() {
 	db := dynamodb.New(session.New(), aws.NewConfig())
-	store := ddbSagaStore.New(db, os.Getenv("ASSOCIATIONS_TABLE_NAME"), os.Getenv("SAGA_TABLE_NAME"))
+	store := ddbSagaStore.New(db, os.Getenv("ASSOCIATIONS_TABLE_NAME"), os.Getenv("SAGA_TABLE_NAME"), os.Getenv("SAGA_ARCHIVE_TABLE_NAME"), os.Getenv("OUTBOX_TABLE_NAME"))
 	eventStore := ddbEventStore.New(db, os.Getenv("EVENT_TABLE_NAME"))
-	eventsource = es.New(eventStore)
-	manager = saga.NewManager(store)
+	snapshotStore := ddbEventStore.NewSnapshotStore(db, os.Getenv("SNAPSHOT_TABLE_NAME"))
+	eventsource = es.New(eventStore,
+		es.WithSnapshots(snapshotStore, es.SnapshotEvery(snapshotFrequency)),
+		es.WithMiddleware(es.LogCommands(), es.TimeCommands(nil), es.ValidateCommands(validator.New())),
+	)
+	deadlines := ddbSagaStore.NewDeadlineStore(db, os.Getenv("DEADLINE_TABLE_NAME"))
+	manager = saga.NewManager(store, saga.WithDeadlines(deadlines), saga.IgnoreUnknownSagas(), saga.OnPendingAssociation(recordPendingAssociation))
 	deliverySvc = delivery.NewService(eventsource)
 	approvalSvc = approval.NewService(eventsource)
 	orderSvc = order.NewService(eventsource)
+
+	// Every saga registered here sees the events it declares, which must also be in the
+	// function's SNS filter policy
+	manager.Register(func() saga.SagaAPI {
+		return orderfulfillment.New(orderSvc)
+	})
+	dispatcher = saga.NewOutboxDispatcher(manager, store)
+	orderfulfillment.HandleIntents(dispatcher, approvalSvc, deliverySvc)
 }
 
 func main() {
@@ -49,17 +70,32 @@ func main() {
 
 func HandleRequest(ctx context.Context, e events.SNSEvent) error {
 
+	// Events which arrived before their saga was associated with them fail the invocation, so
+	// Lambda redelivers them once the outbox has saved the association
+	var redeliver error
 	for _, r := range e.Records {
-		if err := handleEvent(r); err != nil {
+		if err := handleEvent(ctx, r); err != nil {
 			log.Println(err)
+			var pending *saga.SagaAssociationPendingError
+			if errors.As(err, &pending) {
+				redeliver = err
+			}
 			continue
 		}
 	}
 
-	return nil
+	// Carry out the intents the events raised straight away, the deadlines function picks up
+	// any which fail here
+	n, err := dispatcher.RunPending(ctx, time.Now())
+	log.Printf("Dispatched %d saga intents", n)
+	if err != nil {
+		log.Println(err)
+	}
+
+	return redeliver
 }
 
-func handleEvent(r events.SNSEventRecord) error {
+func handleEvent(ctx context.Context, r events.SNSEventRecord) error {
 	eventTypeAttribute := r.SNS.MessageAttributes["eventType"].(map[string]interface{})
 	event := es.Event{
 		EventType: eventTypeAttribute["Value"].(string),
@@ -68,11 +104,35 @@ func handleEvent(r events.SNSEventRecord) error {
 		return fmt.Errorf("Error unmarhalling json: %s", err)
 	}
 
-	// Handle saga
-	orderFulfillmentSaga := orderfulfillment.New(orderSvc, deliverySvc, approvalSvc)
-	if err := manager.ProcessEvent(event, orderFulfillmentSaga); err != nil {
-		return fmt.Errorf("Error handling event with payload: %+v, details: %s", event, err)
+	// Handle sagas
+	if err := manager.Dispatch(ctx, event); err != nil {
+		return fmt.Errorf("Error handling event with payload: %+v, details: %w", event, err)
 	}
 
 	return nil
 }
+
+// recordPendingAssociation counts the events which arrive before their saga is associated with
+// them.  It's logged in CloudWatch's embedded metric format, so CloudWatch records the metric.
+func recordPendingAssociation(event es.Event, err *saga.SagaAssociationPendingError) {
+	b, marshalErr := json.Marshal(map[string]interface{}{
+		"_aws": map[string]interface{}{
+			"Timestamp": time.Now().UnixNano() / int64(time.Millisecond),
+			"CloudWatchMetrics": []interface{}{map[string]interface{}{
+				"Namespace":  "PizzaShop",
+				"Dimensions": [][]string{{"SagaType", "AssociationType"}},
+				"Metrics":    []interface{}{map[string]string{"Name": "PendingSagaAssociations", "Unit": "Count"}},
+			}},
+		},
+		"SagaType":                err.SagaType,
+		"AssociationType":         err.AssociationType,
+		"EventType":               event.EventType,
+		"PendingSagaAssociations": 1,
+	})
+	if marshalErr != nil {
+		log.Printf("Error recording the pending association metric, details: %s", marshalErr)
+		return
+	}
+	// Printed without the log prefix, which CloudWatch wouldn't parse
+	fmt.Println(string(b))
+}